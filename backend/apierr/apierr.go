@@ -0,0 +1,106 @@
+// Package apierr provides errors that carry a machine-readable code, so that clients don't have to match on messages
+package apierr
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// A Code says what kind of error happened. GraphQL responses carry it in extensions.code.
+type Code string
+
+const (
+	NotFound        Code = "NOT_FOUND"
+	Unauthenticated Code = "UNAUTHENTICATED"
+	Forbidden       Code = "FORBIDDEN"
+	Validation      Code = "VALIDATION_FAILED"
+	Conflict        Code = "CONFLICT"
+	RateLimited     Code = "RATE_LIMITED"
+	Internal        Code = "INTERNAL"
+)
+
+// An Error is an error with a code, and for validation errors the argument that was invalid
+type Error struct {
+	Code    Code
+	Message string
+	Field   string
+	// The error this one was made from. It is never shown to clients.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Extensions lets GraphQL responses carry the code along with the message
+func (e *Error) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code": e.Code,
+	}
+	if e.Field != "" {
+		extensions["field"] = e.Field
+	}
+	return extensions
+}
+
+// Make an error for something that doesn't exist, or that the viewer can't see
+func NewNotFound(message string) *Error {
+	return &Error{Code: NotFound, Message: message}
+}
+
+// Make an error for a request that needs the viewer to be logged in, or whose credentials were rejected
+func NewUnauthenticated(format string, args ...interface{}) *Error {
+	return &Error{Code: Unauthenticated, Message: fmt.Sprintf(format, args...)}
+}
+
+// Make an error for a logged in viewer who isn't allowed to do something
+func NewForbidden(format string, args ...interface{}) *Error {
+	return &Error{Code: Forbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+// Make an error for an invalid argument, named by field
+func NewValidation(field string, format string, args ...interface{}) *Error {
+	return &Error{Code: Validation, Message: fmt.Sprintf(format, args...), Field: field}
+}
+
+// Make an error for a request that clashes with what is already there, like a username that is taken
+func NewConflict(message string) *Error {
+	return &Error{Code: Conflict, Message: message}
+}
+
+// Make an error for something that can't be done again yet
+func NewRateLimited(format string, args ...interface{}) *Error {
+	return &Error{Code: RateLimited, Message: fmt.Sprintf(format, args...)}
+}
+
+// Make an error for something that went wrong on our side. The cause is logged instead of being sent to the client.
+func NewInternal(err error) *Error {
+	// Internal errors passed up through another NewInternal were already logged
+	var coded *Error
+	if errors.As(err, &coded) && coded.Code == Internal {
+		return coded
+	}
+	log.Printf("internal server error: %v", err)
+	return &Error{Code: Internal, Message: "internal server error", Err: err}
+}
+
+// Give an error a code if it doesn't have one. Errors that already carry extensions of their own are left alone.
+// Anything else never had a message meant for clients, so it becomes an internal error and is logged.
+func From(err error) error {
+	if err == nil {
+		return nil
+	}
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded
+	}
+	if _, extended := err.(interface{ Extensions() map[string]interface{} }); extended {
+		return err
+	}
+	return NewInternal(err)
+}
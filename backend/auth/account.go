@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/mailer"
+	"github.com/soumitradev/Dwitter/backend/passwords"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
+)
+
+// How long the links for an email change stay valid
+const emailChangeExpiry = time.Hour * 24
+
+// How long an account is kept after its owner deletes it, so they can change their mind by logging in
+const accountDeletionGracePeriod = time.Hour * 24 * 30
+
+// Users without a password confirm account deletion by having logged in this recently
+const reauthenticationWindow = time.Minute * 10
+
+// Tell a user their password was changed
+func SendPasswordChangedEmail(emailID string) error {
+	return mailer.Send(emailID, "password_changed", map[string]interface{}{
+		"Link": "http://localhost:5000/forgot_password",
+	})
+}
+
+// Send the link that confirms an email change to the new address
+func SendEmailChangeConfirmationEmail(emailID string, link string) error {
+	return mailer.Send(emailID, "email_change_confirm", map[string]interface{}{
+		"Link": link,
+	})
+}
+
+// Tell a user their email is being changed, with a link to cancel it
+func SendEmailChangeNoticeEmail(emailID string, newEmail string, link string) error {
+	return mailer.Send(emailID, "email_change_notice", map[string]interface{}{
+		"NewEmail": newEmail,
+		"Link":     link,
+	})
+}
+
+// Tell a user their account will be deleted, and how to stop it
+func SendAccountDeletionEmail(emailID string, deleteAfter time.Time) error {
+	return mailer.Send(emailID, "account_deletion", map[string]interface{}{
+		"Date": deleteAfter.UTC().Format("January 2, 2006"),
+		"Link": "http://localhost:5000/login",
+	})
+}
+
+// Change a user's password after checking their current one.
+// Every other session is logged out, and new tokens are returned for the current one.
+func ChangePassword(username string, sessionID string, currentPassword string, newPassword string) (schema.AuthTokensType, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return schema.AuthTokensType{}, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return schema.AuthTokensType{}, apierr.NewInternal(err)
+	}
+
+	if !user.HasPassword {
+		return schema.AuthTokensType{}, apierr.NewConflict("your account doesn't have a password yet, set one with a password reset link")
+	}
+	matches, _, err := passwords.Verify(currentPassword, user.PasswordHash)
+	if err != nil || !matches {
+		return schema.AuthTokensType{}, apierr.NewValidation("currentPassword", "current password is incorrect")
+	}
+
+	err = passwords.Validate(newPassword)
+	if err != nil {
+		return schema.AuthTokensType{}, err
+	}
+	if newPassword == currentPassword {
+		return schema.AuthTokensType{}, apierr.NewValidation("newPassword", "new password must be different from the current one")
+	}
+
+	passwordHash, err := passwords.Hash(newPassword)
+	if err != nil {
+		return schema.AuthTokensType{}, apierr.NewInternal(err)
+	}
+
+	// Bump the token version so that all existing refresh tokens stop working
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.PasswordHash.Set(string(passwordHash)),
+		db.User.TokenVersion.Increment(1),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.AuthTokensType{}, apierr.NewInternal(err)
+	}
+
+	_, err = RevokeAllSessions(username, sessionID)
+	if err != nil {
+		return schema.AuthTokensType{}, err
+	}
+
+	err = SendPasswordChangedEmail(user.Email)
+	if err != nil {
+		fmt.Printf("Error sending password changed email: %v\n", err)
+	}
+
+	tokenData, err := sessionTokens(username, sessionID)
+	if err != nil {
+		return schema.AuthTokensType{}, err
+	}
+	return schema.AuthTokensType{
+		AccessToken: tokenData.AccessToken,
+		JID:         tokenData.RefreshToken,
+	}, nil
+}
+
+// Start changing a user's email. The new email is only set once it is confirmed with a link sent to it,
+// and the current email gets a link to cancel the change.
+func RequestEmailChange(username string, newEmail string) error {
+	err := common.ValidateVar("email", newEmail, "required,email,lte=100")
+	if err != nil {
+		return err
+	}
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	if strings.EqualFold(user.Email, newEmail) {
+		return apierr.NewConflict("that is already your email")
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Email.Equals(newEmail),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		return apierr.NewConflict("email already taken")
+	}
+	if err != db.ErrNotFound {
+		return apierr.NewInternal(err)
+	}
+
+	// Only the latest change should be pending
+	_, err = common.Client.EmailChangeRequest.FindMany(
+		db.EmailChangeRequest.UserID.Equals(username),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+
+	confirmToken, err := util.GenSecureToken(32)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	cancelToken, err := util.GenSecureToken(32)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+
+	_, err = common.Client.EmailChangeRequest.CreateOne(
+		db.EmailChangeRequest.NewEmail.Set(newEmail),
+		db.EmailChangeRequest.ConfirmTokenHash.Set(util.HashToken(confirmToken)),
+		db.EmailChangeRequest.CancelTokenHash.Set(util.HashToken(cancelToken)),
+		db.EmailChangeRequest.User.Link(
+			db.User.Username.Equals(username),
+		),
+		db.EmailChangeRequest.ExpiresAt.Set(time.Now().Add(emailChangeExpiry)),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+
+	err = SendEmailChangeConfirmationEmail(newEmail, "http://localhost:5000/api/confirm_email/"+confirmToken)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	err = SendEmailChangeNoticeEmail(user.Email, newEmail, "http://localhost:5000/api/cancel_email_change/"+cancelToken)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	return nil
+}
+
+// Schedule a user's account for deletion after a grace period, and log them out everywhere.
+// Users with a password have to confirm it, and users without one have to have logged in recently.
+func ScheduleAccountDeletion(username string, sessionID string, password string) (time.Time, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return time.Time{}, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return time.Time{}, apierr.NewInternal(err)
+	}
+
+	err = reauthenticate(user, sessionID, password, "deleting your account")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	deleteAfter := time.Now().Add(accountDeletionGracePeriod)
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.DeleteAfter.Set(deleteAfter),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return time.Time{}, apierr.NewInternal(err)
+	}
+
+	_, err = RevokeAllSessions(username, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = SendAccountDeletionEmail(user.Email, deleteAfter)
+	if err != nil {
+		fmt.Printf("Error sending account deletion email: %v\n", err)
+	}
+	return deleteAfter, nil
+}
+
+// Make sure it's really the user doing something drastic, by checking their password.
+// Users without a password have to have logged in within the last few minutes instead.
+func reauthenticate(user *db.UserModel, sessionID string, password string, action string) error {
+	if user.HasPassword {
+		matches, _, err := passwords.Verify(password, user.PasswordHash)
+		if err != nil || !matches {
+			return apierr.NewValidation("password", "password is incorrect")
+		}
+		return nil
+	}
+
+	session, err := common.Client.Session.FindUnique(
+		db.Session.DbID.Equals(sessionID),
+	).Exec(common.BaseCtx)
+	if err != nil && err != db.ErrNotFound {
+		return apierr.NewInternal(err)
+	}
+	if err == db.ErrNotFound || time.Since(session.CreatedAt) > reauthenticationWindow {
+		return apierr.NewUnauthenticated("please log in again to confirm %s", action)
+	}
+	return nil
+}
+
+// Keep an account that was going to be deleted
+func cancelAccountDeletion(username string) error {
+	_, err := common.Client.User.FindMany(
+		db.User.Username.Equals(username),
+		db.User.Not(
+			db.User.DeleteAfter.IsNull(),
+		),
+	).Update(
+		db.User.DeleteAfter.SetOptional(nil),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	return nil
+}
+
+// Write a plain HTML response for pages opened from an email link
+func sendLinkPage(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// Handles email change confirmations using a token from a link sent to the new email
+func ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	// Delete the request before using it so that it can only ever be used once
+	changeRequest, err := common.Client.EmailChangeRequest.FindUnique(
+		db.EmailChangeRequest.ConfirmTokenHash.Equals(util.HashToken(token)),
+	).Delete().Exec(common.BaseCtx)
+	if err == db.ErrNotFound || (err == nil && time.Now().After(changeRequest.ExpiresAt)) {
+		sendLinkPage(w, http.StatusOK, "Unrecognized, cancelled or expired confirmation link")
+		return
+	}
+	if err != nil {
+		sendLinkPage(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Somebody may have taken the email since the change was asked for
+	_, err = common.Client.User.FindUnique(
+		db.User.Email.Equals(changeRequest.NewEmail),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		sendLinkPage(w, http.StatusConflict, "This email is already used by another account")
+		return
+	}
+	if err != db.ErrNotFound {
+		sendLinkPage(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(changeRequest.UserID),
+	).Update(
+		db.User.Email.Set(changeRequest.NewEmail),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		sendLinkPage(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	sendLinkPage(w, http.StatusOK, "Email changed!\nYou may close this tab now.")
+}
+
+// Handles email change cancellations using a token from a link sent to the old email
+func CancelEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	result, err := common.Client.EmailChangeRequest.FindMany(
+		db.EmailChangeRequest.CancelTokenHash.Equals(util.HashToken(token)),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		sendLinkPage(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if result.Count == 0 {
+		sendLinkPage(w, http.StatusOK, "Unrecognized link\nThe email change may have already been confirmed or cancelled.")
+		return
+	}
+
+	sendLinkPage(w, http.StatusOK, "Email change cancelled.\nIf you didn't ask for it, change your password too.")
+}
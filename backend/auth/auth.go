@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/common"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
 
@@ -56,25 +57,108 @@ func splitCookie(cookieString string) string {
 	return val
 }
 
+// Decode a JSON request body into dst, and send an appropriate error response if it is malformed.
+// Returns false if an error response was sent.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	// Check if content type is "application/json"
+	if r.Header.Get("Content-Type") != "" {
+		value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
+		if value != "application/json" {
+			sendError(w, http.StatusBadRequest, "Content-Type header is not application/json")
+			return false
+		}
+	}
+
+	// Read a maximum of 1MB from body
+	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
+
+	// Create a JSON decoder and decode the request JSON
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(dst)
+
+	// If any error occurred during the decoding, send an appropriate response
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		// Return errors based on what error JSON parser returned
+		switch {
+		case errors.As(err, &syntaxError):
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset))
+
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			sendError(w, http.StatusBadRequest, "Request body contains badly-formed JSON")
+
+		case errors.As(err, &unmarshalTypeError):
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset))
+
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			sendError(w, http.StatusBadRequest, fmt.Sprintf("Request body contains unknown field %s", fieldName))
+
+		case errors.Is(err, io.EOF):
+			sendError(w, http.StatusBadRequest, "Request body must not be empty")
+
+		case err.Error() == "http: request body too large":
+			sendError(w, http.StatusRequestEntityTooLarge, "Request body must not be larger than 1MB")
+
+		default:
+			sendError(w, http.StatusInternalServerError, err.Error())
+		}
+		return false
+	}
+
+	// Decode it and check for an external JSON error
+	err = decoder.Decode(&struct{}{})
+	if err != io.EOF {
+		sendError(w, http.StatusBadRequest, "Request body must only contain a single JSON object")
+		return false
+	}
+
+	return true
+}
+
+// Send a JSON error response
+func sendError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(common.HTTPError{
+		Error: msg,
+	})
+}
+
+// Send a JSON message response
+func sendMessage(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(common.HTTPMessage{
+		Message: msg,
+	})
+}
+
 // Generate an Access Token
-func generateAccessToken(username string) (string, error) {
+func generateAccessToken(username string, sessionID string) (string, error) {
 	// Check if user exists
-	_, err := common.Client.User.FindUnique(
+	userDB, err := common.Client.User.FindUnique(
 		db.User.Username.Equals(username),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return "", errors.New("user doesn't exist")
+		return "", apierr.NewUnauthenticated("user doesn't exist")
+	}
+	if err != nil {
+		return "", apierr.NewInternal(err)
 	}
 
 	// Save data in claims and generate token
 	tokenClaims := jwt.MapClaims{}
 	tokenClaims["authorized"] = true
 	tokenClaims["username"] = username
+	tokenClaims["role"] = userDB.Role
+	tokenClaims["session_id"] = sessionID
 	tokenClaims["exp"] = time.Now().Add(time.Minute * 15).Unix()
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims)
-
-	token, err := accessToken.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	// Access tokens are signed with a private key, so other services can verify them with our public keys
+	token, err := signAccessToken(tokenClaims)
 	if err != nil {
 		return "", err
 	}
@@ -83,13 +167,13 @@ func generateAccessToken(username string) (string, error) {
 }
 
 // Generate a Refresh Token
-func generateRefreshToken(username string) (string, error) {
+func generateRefreshToken(username string, sessionID string, tokenID string) (string, error) {
 	// Check if user exists
 	userDB, err := common.Client.User.FindUnique(
 		db.User.Username.Equals(username),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return "", errors.New("user doesn't exist")
+		return "", apierr.NewUnauthenticated("user doesn't exist")
 	}
 
 	// Save data in claims and generate token
@@ -97,7 +181,9 @@ func generateRefreshToken(username string) (string, error) {
 	tokenClaims["authorized"] = true
 	tokenClaims["username"] = username
 	tokenClaims["token_version"] = userDB.TokenVersion
-	tokenClaims["exp"] = time.Now().Add(time.Hour * 24 * 7).Unix()
+	tokenClaims["session_id"] = sessionID
+	tokenClaims["jti"] = tokenID
+	tokenClaims["exp"] = time.Now().Add(sessionExpiry).Unix()
 
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims)
 
@@ -109,36 +195,161 @@ func generateRefreshToken(username string) (string, error) {
 	return token, nil
 }
 
-// Authorize user and return tokens
-func generateTokens(username string, password string) (tokenType, error) {
-	authenticated, authErr := common.CheckCreds(username, password)
-	if authenticated {
-		JWT, err := generateAccessToken(username)
-		if err != nil {
-			return tokenType{}, err
-		}
+// Start a new session for a user that has already been authenticated, and generate its tokens
+func issueTokens(username string, r *http.Request) (tokenType, error) {
+	// Every way of logging in ends up here, so this is where suspended and deactivated accounts are kept out
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return tokenType{}, apierr.NewUnauthenticated("user doesn't exist")
+	}
+	if err != nil {
+		return tokenType{}, apierr.NewInternal(err)
+	}
+	err = common.CheckAccountStatus(user)
+	if err != nil {
+		return tokenType{}, err
+	}
+
+	// Logging in is how users keep an account they asked to delete
+	err = cancelAccountDeletion(username)
+	if err != nil {
+		return tokenType{}, err
+	}
+
+	sessionID, err := createSession(username, r)
+	if err != nil {
+		return tokenType{}, err
+	}
+	return sessionTokens(username, sessionID)
+}
+
+// Generate tokens bound to an existing session, starting a new refresh token rotation
+func sessionTokens(username string, sessionID string) (tokenType, error) {
+	tokenID, err := newRefreshTokenID(sessionID)
+	if err != nil {
+		return tokenType{}, err
+	}
+	return rotatedSessionTokens(username, sessionID, tokenID)
+}
 
-		refTok, err := generateRefreshToken(username)
+// Generate tokens bound to an existing session, with the refresh token ID the session expects next
+func rotatedSessionTokens(username string, sessionID string, tokenID string) (tokenType, error) {
+	JWT, err := generateAccessToken(username, sessionID)
+	if err != nil {
+		return tokenType{}, err
+	}
+
+	refTok, err := generateRefreshToken(username, sessionID, tokenID)
+	if err != nil {
+		return tokenType{}, err
+	}
+
+	return tokenType{
+		AccessToken:  JWT,
+		RefreshToken: refTok,
+	}, err
+}
+
+// Send tokens to the client, with the refresh token also in an HTTPOnly cookie
+func sendTokens(w http.ResponseWriter, tokenData tokenType) {
+	expireRefreshCookie(w, legacyRefreshCookiePath)
+	c := http.Cookie{
+		Name:     "jid",
+		Value:    tokenData.RefreshToken,
+		HttpOnly: true,
+		Secure:   true,
+		Path:     refreshCookiePath,
+	}
+	http.SetCookie(w, &c)
+
+	// Set the response headers
+	w.Header().Set("Content-Type", "application/json")
+	// Send the access token in JSON
+	json.NewEncoder(w).Encode(loginResponse{
+		AccessToken: tokenData.AccessToken,
+		JID:         tokenData.RefreshToken,
+	})
+}
+
+// Finish logging in a user whose credentials have been checked.
+// Users with two-factor authentication enabled get a challenge token instead of tokens.
+func completeLogin(w http.ResponseWriter, r *http.Request, username string) {
+	startSecondFactor(w, r, username, false)
+}
+
+// Ask for a TOTP code if the user has set one up, and send tokens otherwise.
+// Deactivated accounts that are being reactivated are only switched back on once the code has been checked.
+func startSecondFactor(w http.ResponseWriter, r *http.Request, username string, reactivate bool) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		sendError(w, http.StatusUnauthorized, "user doesn't exist")
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if user.TotpEnabled {
+		challengeToken, err := generateTOTPChallengeToken(username, reactivate)
 		if err != nil {
-			return tokenType{}, err
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return
 		}
 
-		return tokenType{
-			AccessToken:  JWT,
-			RefreshToken: refTok,
-		}, err
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(totpChallengeResponse{
+			TOTPRequired:   true,
+			ChallengeToken: challengeToken,
+		})
+		return
 	}
-	return tokenType{}, authErr
+
+	if reactivate {
+		finishReactivation(w, r, username)
+		return
+	}
+	finishLogin(w, r, username)
 }
 
-// Verify an Access Token
-func VerifyAccessToken(tokenString string) (jwt.MapClaims, bool, error) {
-	// Handle empty token string
-	if tokenString == "" {
-		return jwt.MapClaims{}, false, nil
+// Send tokens to a user who has passed every check, including a second factor if they have one
+func finishLogin(w http.ResponseWriter, r *http.Request, username string) {
+	// Only forget failed attempts once the login is complete, so a known password doesn't reset guesses at the code
+	err := UnlockAccount(username)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
 	}
 
-	// Validate token
+	tokenData, err := issueTokens(username, r)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	sendTokens(w, tokenData)
+}
+
+// Generate a short-lived token for a single purpose, like a TOTP challenge.
+// The purpose claim stops these tokens from being accepted as access tokens.
+func generatePurposeToken(username string, purpose string, expiry time.Duration, extraClaims jwt.MapClaims) (string, error) {
+	tokenClaims := jwt.MapClaims{}
+	for key, value := range extraClaims {
+		tokenClaims[key] = value
+	}
+	tokenClaims["purpose"] = purpose
+	tokenClaims["username"] = username
+	tokenClaims["exp"] = time.Now().Add(expiry).Unix()
+
+	purposeToken := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims)
+	return purposeToken.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+}
+
+// Verify a token generated by generatePurposeToken and return its claims
+func verifyPurposeToken(tokenString string, purpose string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		//Make sure that the token method conform to "SigningMethodHMAC"
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -146,32 +357,88 @@ func VerifyAccessToken(tokenString string) (jwt.MapClaims, bool, error) {
 		}
 		return []byte(os.Getenv("ACCESS_SECRET")), nil
 	})
+	if err != nil {
+		return jwt.MapClaims{}, apierr.NewUnauthenticated("authentication error: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["purpose"] != purpose {
+		return jwt.MapClaims{}, apierr.NewUnauthenticated("invalid token")
+	}
+	if _, ok := claims["username"].(string); !ok {
+		return jwt.MapClaims{}, apierr.NewUnauthenticated("field username not found in token")
+	}
+	return claims, nil
+}
+
+// Verify an Access Token
+func VerifyAccessToken(tokenString string) (jwt.MapClaims, bool, error) {
+	// Handle empty token string
+	if tokenString == "" {
+		return jwt.MapClaims{}, false, nil
+	}
+
+	// Personal access tokens are checked against the database instead
+	if strings.HasPrefix(tokenString, personalAccessTokenPrefix) {
+		return verifyPersonalAccessToken(tokenString)
+	}
+
+	// Validate token against the key named in its header
+	token, err := jwt.Parse(tokenString, accessTokenKey)
 
 	if err != nil {
-		return jwt.MapClaims{}, false, fmt.Errorf("authentication error: %v", err)
+		return jwt.MapClaims{}, false, apierr.NewUnauthenticated("authentication error: %v", err)
 	}
 
 	// Extract metadata from token
 	claims, ok := token.Claims.(jwt.MapClaims)
 
 	if ok && token.Valid {
+		// Tokens issued for anything else, like TOTP challenges, are not access tokens
+		if _, present := claims["purpose"]; present {
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("not an access token")
+		}
+
 		// Check for username field
 		_, ok := claims["username"].(string)
 		if !ok {
-			return jwt.MapClaims{}, false, errors.New("field username not found in access token")
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("field username not found in access token")
 		}
-		_, err = common.Client.User.FindUnique(
+		user, err := common.Client.User.FindUnique(
 			db.User.Username.Equals(claims["username"].(string)),
 		).Exec(common.BaseCtx)
 		if err == db.ErrNotFound {
-			return jwt.MapClaims{}, false, errors.New("user doesn't exist")
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("user doesn't exist")
+		}
+		if err != nil {
+			return jwt.MapClaims{}, false, apierr.NewInternal(err)
+		}
+		// Access tokens are short-lived, but a suspension should still work right away
+		err = checkTokenOwner(user)
+		if err != nil {
+			return jwt.MapClaims{}, false, err
 		}
+		// The role in the token is for other services. We already have the user, so use their current role,
+		// so that taking a role away works right away instead of when the token expires.
+		claims["role"] = user.Role
 		return claims, true, nil
 	} else {
 		return jwt.MapClaims{}, false, nil
 	}
 }
 
+// Check that the owner of an access token can still use it, which suspended, deactivated and soon to be deleted accounts can't
+func checkTokenOwner(user *db.UserModel) error {
+	err := common.CheckAccountStatus(user)
+	if err != nil {
+		return err
+	}
+	if _, pendingDeletion := user.DeleteAfter(); pendingDeletion {
+		return apierr.NewUnauthenticated("account is scheduled for deletion, log in again to keep it")
+	}
+	return nil
+}
+
 // Verify a Refresh Token
 func verifyRefreshToken(tokenString string) (jwt.MapClaims, bool, error) {
 	// Validate token
@@ -184,7 +451,7 @@ func verifyRefreshToken(tokenString string) (jwt.MapClaims, bool, error) {
 	})
 
 	if err != nil {
-		return jwt.MapClaims{}, false, fmt.Errorf("authentication error: %v", err)
+		return jwt.MapClaims{}, false, apierr.NewUnauthenticated("authentication error: %v", err)
 	}
 
 	// Extract metadata from token
@@ -194,12 +461,22 @@ func verifyRefreshToken(tokenString string) (jwt.MapClaims, bool, error) {
 		// Check for username field
 		username, ok := claims["username"].(string)
 		if !ok {
-			return jwt.MapClaims{}, false, errors.New("field username not found in refresh token")
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("field username not found in refresh token")
 		}
 		// Check for token_version field
 		tokenV, ok := claims["token_version"].(float64)
 		if !ok {
-			return jwt.MapClaims{}, false, errors.New("field token_version not found in refresh token")
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("field token_version not found in refresh token")
+		}
+		// Check for session_id field
+		sessionID, ok := claims["session_id"].(string)
+		if !ok {
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("field session_id not found in refresh token")
+		}
+		// Check for jti field
+		_, ok = claims["jti"].(string)
+		if !ok {
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("field jti not found in refresh token")
 		}
 
 		userDB, err := common.Client.User.FindUnique(
@@ -207,123 +484,47 @@ func verifyRefreshToken(tokenString string) (jwt.MapClaims, bool, error) {
 		).Exec(common.BaseCtx)
 
 		if err == db.ErrNotFound {
-			return jwt.MapClaims{}, false, errors.New("user doesn't exist")
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("user doesn't exist")
 		}
 		if userDB.TokenVersion != int(tokenV) {
-			return jwt.MapClaims{}, false, errors.New("invalid token version")
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("invalid token version")
+		}
+
+		active, err := sessionActive(sessionID, username)
+		if err != nil {
+			return jwt.MapClaims{}, false, err
+		}
+		if !active {
+			return jwt.MapClaims{}, false, apierr.NewUnauthenticated("session has been revoked")
 		}
 
 		return claims, true, nil
 	} else {
-		return jwt.MapClaims{}, false, errors.New("unauthorized")
+		return jwt.MapClaims{}, false, apierr.NewUnauthenticated("unauthorized")
 	}
 }
 
 // Handles login requests
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	// Check if content type is "application/json"
-	if r.Header.Get("Content-Type") != "" {
-		value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
-		if value != "application/json" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: "Content-Type header is not application/json",
-			})
-			return
-		}
-	}
-
-	// Read a maximum of 1MB from body
-	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
-
-	// Create a JSON decoder and decode the request JSON
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
 	var loginData loginType
-	err := decoder.Decode(&loginData)
-
-	// If any error occurred during the decoding, send an appropriate response
-	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-
-		// Return errors based on what error JSON parser returned
-		switch {
-		case errors.As(err, &syntaxError):
-			msg := fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			msg := "Request body contains badly-formed JSON"
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case errors.As(err, &unmarshalTypeError):
-			msg := fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case errors.Is(err, io.EOF):
-			msg := "Request body must not be empty"
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case err.Error() == "http: request body too large":
-			msg := "Request body must not be larger than 1MB"
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusRequestEntityTooLarge)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		default:
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: err.Error(),
-			})
-		}
+	if !decodeJSONBody(w, r, &loginData) {
 		return
 	}
 
-	// Decode it and check for an external JSON error
-	err = decoder.Decode(&struct{}{})
-	if err != io.EOF {
-		msg := "Request body must only contain a single JSON object"
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(common.HTTPError{
-			Error: msg,
-		})
+	// Don't check the password at all while the account or IP is locked out
+	if rejectIfThrottled(w, r, loginData.Username) {
 		return
 	}
 
-	// After checking for any errors, log the user in, and generate tokens
-	tokenData, err := generateTokens(loginData.Username, loginData.Password)
-	if err != nil {
+	// After checking for any errors, check the credentials and log the user in
+	authenticated, err := common.CheckCreds(loginData.Username, loginData.Password)
+	if !authenticated {
+		if errors.Is(err, common.ErrInvalidCreds) {
+			if err := recordFailedAttempt(r, loginData.Username); err != nil {
+				sendError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(common.HTTPError{
@@ -332,116 +533,13 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Send the refresh token in a HTTPOnly cookie
-	c := http.Cookie{
-		Name:     "jid",
-		Value:    tokenData.RefreshToken,
-		HttpOnly: true,
-		Secure:   true,
-		Path:     "/api/refresh_token",
-	}
-	http.SetCookie(w, &c)
-
-	// Set the response headers
-	w.Header().Set("Content-Type", "application/json")
-	// Send the access token in JSON
-	json.NewEncoder(w).Encode(loginResponse{
-		AccessToken: tokenData.AccessToken,
-		JID:         tokenData.RefreshToken,
-	})
-
+	completeLogin(w, r, loginData.Username)
 }
 
 // Handle refresh-token requests
 func RefreshHandler(w http.ResponseWriter, r *http.Request) {
-	// Check if content type is "application/json"
-	if r.Header.Get("Content-Type") != "" {
-		value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
-		if value != "application/json" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: "Content-Type header is not application/json",
-			})
-			return
-		}
-	}
-	// Read a maximum of 1MB from body
-	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
-
-	// Create a JSON decoder and decode the request JSON
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
 	var loginData loginResponse
-	err := decoder.Decode(&loginData)
-
-	// If any error occurred during the decoding, send an appropriate response
-	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-
-		// Return errors based on what error JSON parser returned
-		switch {
-		case errors.As(err, &syntaxError):
-			msg := fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			msg := "Request body contains badly-formed JSON"
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case errors.As(err, &unmarshalTypeError):
-			msg := fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		case err.Error() == "http: request body too large":
-			msg := "Request body must not be larger than 1MB"
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusRequestEntityTooLarge)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: msg,
-			})
-
-		default:
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: err.Error(),
-			})
-		}
-		return
-	}
-
-	// Decode it and check for an external JSON error
-	err = decoder.Decode(&struct{}{})
-	if err != io.EOF {
-		msg := "Request body must only contain a single JSON object"
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(common.HTTPError{
-			Error: msg,
-		})
+	if !decodeJSONBody(w, r, &loginData) {
 		return
 	}
 
@@ -456,14 +554,23 @@ func RefreshHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var token string
-	if cookieString.String() != "" {
+	if err == nil {
 		token = splitCookie(cookieString.String())
 	} else {
 		token = loginData.JID
 	}
 
+	// Refresh tokens can be guessed at too, so clients that keep sending bad ones get throttled
+	if rejectIfThrottled(w, r, "") {
+		return
+	}
+
 	claims, verified, err := verifyRefreshToken(token)
 	if (err != nil) || (!verified) {
+		if err := recordFailedAttempt(r, ""); err != nil {
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
 		msg := fmt.Sprintf("Unauthorized: %v", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
@@ -484,28 +591,25 @@ func RefreshHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refTok, err := generateRefreshToken(userID)
+	sessionID := claims["session_id"].(string)
+	tokenID, fresh, err := rotateRefreshTokenID(sessionID, claims["jti"].(string))
 	if err != nil {
-		msg := "Invalid refresh token"
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(common.HTTPError{
-			Error: msg,
-		})
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !fresh {
+		clearRefreshCookie(w)
+		sendError(w, http.StatusUnauthorized, "Unauthorized: refresh token was already used, please log in again")
 		return
 	}
 
-	// Send the refresh token in a HTTPOnly cookie
-	c := http.Cookie{
-		Name:     "jid",
-		Value:    refTok,
-		HttpOnly: true,
-		Secure:   true,
-		Path:     "/api/refresh_token",
+	err = touchSession(sessionID, r)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
 	}
-	http.SetCookie(w, &c)
 
-	accessTok, err := generateAccessToken(userID)
+	tokenData, err := rotatedSessionTokens(userID, sessionID, tokenID)
 	if err != nil {
 		msg := "Invalid refresh token"
 		w.Header().Set("Content-Type", "application/json")
@@ -515,23 +619,30 @@ func RefreshHandler(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	// Set the response headers
-	w.Header().Set("Content-Type", "application/json")
-	// Send the access token in JSON
-	json.NewEncoder(w).Encode(loginResponse{
-		AccessToken: accessTok,
-		JID:         refTok,
-	})
+
+	// Send the refresh token in a HTTPOnly cookie, and the access token in JSON
+	sendTokens(w, tokenData)
 }
 
-// Check header of request and authenticate
-func Authenticate(authHeader string) (string, error) {
+// Check header of request and authenticate, making sure the token is authorized for a scope
+func Authenticate(authHeader string, scope string) (string, error) {
+	username, _, err := authenticateSession(authHeader, scope)
+	return username, err
+}
+
+// Authenticate like Authenticate, and also return the session the token belongs to, which is empty for personal access tokens
+func authenticateSession(authHeader string, scope string) (string, string, error) {
 	tokenString := SplitAuthToken(authHeader)
 	data, isAuth, err := VerifyAccessToken(tokenString)
 	if (err != nil) || !isAuth {
-		return "", errors.New("Unauthorized")
+		return "", "", apierr.NewUnauthenticated("Unauthorized")
+	}
+	err = RequireScope(data, scope)
+	if err != nil {
+		return "", "", err
 	}
 
 	username := data["username"].(string)
-	return username, nil
+	sessionID, _ := data["session_id"].(string)
+	return username, sessionID, nil
 }
@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// A signingKey is a key access tokens are signed or verified with
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	// Nil for keys that are only kept around to verify tokens signed before a rotation
+	private interface{}
+	public  interface{}
+}
+
+// A jwk is a public key in JSON Web Key format
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// A jwks is a set of public keys in JSON Web Key format
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+// All keys access tokens may be verified with, by key ID
+var signingKeys = map[string]*signingKey{}
+
+// The key new access tokens are signed with
+var activeSigningKey *signingKey
+
+// Load the access token signing keys.
+// Every <kid>.pem file in JWT_KEYS_DIR is a key, either a private key (PKCS#8, or PKCS#1 for RSA) or a public key.
+// New tokens are signed with JWT_ACTIVE_KID, and the rest are only used to verify tokens, so keys can be rotated
+// by adding a new key, making it active, and removing the old one once all tokens signed with it have expired.
+func InitSigningKeys() error {
+	signingKeys = map[string]*signingKey{}
+	activeSigningKey = nil
+
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		log.Println("JWT_KEYS_DIR is not set, using a temporary signing key. Access tokens will stop working when the server restarts.")
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		key, err := newSigningKey("ephemeral", private)
+		if err != nil {
+			return err
+		}
+		signingKeys[key.kid] = key
+		activeSigningKey = key
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(keysDir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := parseSigningKey(kid, data)
+		if err != nil {
+			return fmt.Errorf("could not load signing key %s: %v", file, err)
+		}
+		signingKeys[kid] = key
+	}
+
+	activeKID := os.Getenv("JWT_ACTIVE_KID")
+	key, ok := signingKeys[activeKID]
+	if !ok {
+		return fmt.Errorf("JWT_ACTIVE_KID %q is not a key in %s", activeKID, keysDir)
+	}
+	if key.private == nil {
+		return fmt.Errorf("JWT_ACTIVE_KID %q is a public key, and can't sign tokens", activeKID)
+	}
+	activeSigningKey = key
+	return nil
+}
+
+// Parse a PEM encoded private or public key
+func parseSigningKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(kid, parsed)
+}
+
+// Work out the signing method and public key for a parsed key
+func newSigningKey(kid string, parsed interface{}) (*signingKey, error) {
+	switch key := parsed.(type) {
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, private: key, public: key.Public()}, nil
+	case ed25519.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodEdDSA, public: key}, nil
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, private: key, public: &key.PublicKey}, nil
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, public: key}, nil
+	default:
+		return nil, errors.New("only Ed25519 and RSA keys are supported")
+	}
+}
+
+// Sign an access token with the active key
+func signAccessToken(tokenClaims jwt.MapClaims) (string, error) {
+	if activeSigningKey == nil {
+		return "", errors.New("signing keys not loaded")
+	}
+	token := jwt.NewWithClaims(activeSigningKey.method, tokenClaims)
+	token.Header["kid"] = activeSigningKey.kid
+	return token.SignedString(activeSigningKey.private)
+}
+
+// Find the key an access token was signed with, making sure it was signed with the algorithm of that key
+func accessTokenKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("token has no key ID")
+	}
+	key, ok := signingKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID: %v", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// Convert a public key to JSON Web Key format
+func (key *signingKey) toJWK() jwk {
+	switch public := key.public.(type) {
+	case ed25519.PublicKey:
+		return jwk{
+			Kty: "OKP",
+			Kid: key.kid,
+			Use: "sig",
+			Alg: key.method.Alg(),
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}
+	case *rsa.PublicKey:
+		return jwk{
+			Kty: "RSA",
+			Kid: key.kid,
+			Use: "sig",
+			Alg: key.method.Alg(),
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	}
+	return jwk{}
+}
+
+// Serve the public keys access tokens can be verified with, so other services can verify them offline
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	kids := make([]string, 0, len(signingKeys))
+	for kid := range signingKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keySet := jwks{Keys: []jwk{}}
+	for _, kid := range kids {
+		keySet.Keys = append(keySet.Keys, signingKeys[kid].toJWK())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// Let verifiers cache the keys, but not for so long that they miss a rotation
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(keySet)
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/mailer"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/util"
+)
+
+// How long a magic login link stays valid
+const magicLinkExpiry = time.Minute * 15
+
+// The cookie that binds a magic link to the browser that asked for it
+const magicLinkNonceCookie = "magic_nonce"
+
+// Every link request sends an email, so requests are limited per email so nobody's inbox can be flooded,
+// and per IP so one client can't send links to lots of emails
+var magicLinkEmailThrottle = throttlePolicy{threshold: 3, baseLockout: time.Minute * 5, maxLockout: time.Hour}
+var magicLinkIPThrottle = throttlePolicy{threshold: 10, baseLockout: time.Minute, maxLockout: time.Hour}
+
+// A magicLinkRequestType stores a request for a magic login link
+type magicLinkRequestType struct {
+	Email string `json:"email"`
+}
+
+// A magicLoginType stores the token from a magic login link
+type magicLoginType struct {
+	Token string `json:"token"`
+}
+
+// Send a user a magic login link
+func SendMagicLinkEmail(emailID string, link string) error {
+	return mailer.Send(emailID, "magic_link", map[string]interface{}{
+		"Link": link,
+	})
+}
+
+// Handles requests for a magic login link.
+// The browser that asks for the link gets a nonce cookie, and the link only works in that browser.
+func MagicLinkRequestHandler(w http.ResponseWriter, r *http.Request) {
+	var requestData magicLinkRequestType
+	if !decodeJSONBody(w, r, &requestData) {
+		return
+	}
+
+	err := common.ValidateVar("email", requestData.Email, "required,email,lte=100")
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	// Registered and unregistered emails are limited alike, so the limit doesn't give away which is which
+	if rejectIfRequestedTooOften(w, map[string]throttlePolicy{
+		"magic_email:" + strings.ToLower(requestData.Email): magicLinkEmailThrottle,
+		"magic_ip:" + clientIP(r):                           magicLinkIPThrottle,
+	}) {
+		return
+	}
+
+	// The nonce cookie is set even if the email isn't registered, so the response doesn't give that away
+	nonce, err := util.GenSecureToken(32)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkNonceCookie,
+		Value:    nonce,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/api/magic_login",
+		MaxAge:   int(magicLinkExpiry.Seconds()),
+	})
+
+	// Always send the same response so that the endpoint can't be used to find out which emails are registered
+	msg := "If an account with that email exists, a login link has been sent to it"
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Email.Equals(requestData.Email),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound || (err == nil && !user.Verified) {
+		sendMessage(w, msg)
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Only the latest link should work
+	_, err = common.Client.MagicLinkToken.FindMany(
+		db.MagicLinkToken.UserID.Equals(user.Username),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	token, err := util.GenSecureToken(32)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	_, err = common.Client.MagicLinkToken.CreateOne(
+		db.MagicLinkToken.TokenHash.Set(util.HashToken(token)),
+		db.MagicLinkToken.NonceHash.Set(util.HashToken(nonce)),
+		db.MagicLinkToken.User.Link(
+			db.User.Username.Equals(user.Username),
+		),
+		db.MagicLinkToken.ExpiresAt.Set(time.Now().Add(magicLinkExpiry)),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	link := "http://localhost:5000/magic_login/" + token
+	err = SendMagicLinkEmail(user.Email, link)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "error sending email, please try again later")
+		return
+	}
+
+	sendMessage(w, msg)
+}
+
+// Handles logins using a token from a magic login link, sending the same tokens as LoginHandler
+func MagicLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if rejectIfThrottled(w, r, "") {
+		return
+	}
+
+	var loginData magicLoginType
+	if !decodeJSONBody(w, r, &loginData) {
+		return
+	}
+
+	invalidLink := func() {
+		err := recordFailedAttempt(r, "")
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		sendError(w, http.StatusUnauthorized, "Invalid or expired login link")
+	}
+
+	// Delete the token before using it so that it can only ever be used once, even from the wrong browser
+	magicToken, err := common.Client.MagicLinkToken.FindUnique(
+		db.MagicLinkToken.TokenHash.Equals(util.HashToken(loginData.Token)),
+	).Delete().Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		invalidLink()
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if time.Now().After(magicToken.ExpiresAt) {
+		invalidLink()
+		return
+	}
+
+	nonceCookie, err := r.Cookie(magicLinkNonceCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(util.HashToken(nonceCookie.Value)), []byte(magicToken.NonceHash)) != 1 {
+		invalidLink()
+		return
+	}
+
+	// The nonce is useless now, so clear it
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkNonceCookie,
+		Value:    "",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/api/magic_login",
+		MaxAge:   -1,
+	})
+
+	completeLogin(w, r, magicToken.UserID)
+}
+
+// Delete magic links that have expired
+func DeleteExpiredMagicLinks() error {
+	_, err := common.Client.MagicLinkToken.FindMany(
+		db.MagicLinkToken.ExpiresAt.Before(time.Now()),
+	).Delete().Exec(common.BaseCtx)
+	return err
+}
+
+// Delete expired magic links right away, and then again every interval
+func SweepMagicLinks(interval time.Duration) {
+	for {
+		err := DeleteExpiredMagicLinks()
+		if err != nil {
+			fmt.Printf("Error sweeping magic links: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
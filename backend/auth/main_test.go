@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+)
+
+var connectOnce sync.Once
+var connectErr error
+
+func TestMain(m *testing.M) {
+	// Tokens only have to be valid for the length of the tests
+	for _, name := range []string{"ACCESS_SECRET", "REFRESH_SECRET"} {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, "test-secret")
+		}
+	}
+	os.Unsetenv("JWT_KEYS_DIR")
+	if err := InitSigningKeys(); err != nil {
+		panic(err)
+	}
+	common.Validate = validator.New()
+
+	code := m.Run()
+	if common.Client != nil && connectErr == nil {
+		common.Client.Prisma.Disconnect()
+	}
+	os.Exit(code)
+}
+
+// Connect to the database in schema.prisma, and skip the test if it isn't running
+func requireDatabase(t *testing.T) {
+	t.Helper()
+	connectOnce.Do(func() {
+		common.Client = db.NewClient()
+		connectErr = common.Client.Prisma.Connect()
+	})
+	if connectErr != nil {
+		t.Skipf("database not available: %v", connectErr)
+	}
+}
+
+// Create a verified user that is deleted again when the test ends
+func createTestUser(t *testing.T, username string, hasPassword bool) *db.UserModel {
+	t.Helper()
+	requireDatabase(t)
+
+	// Leftovers from a test that was killed halfway through would make the create fail
+	deleteTestUser(username)
+	user, err := common.Client.User.CreateOne(
+		db.User.Username.Set(username),
+		db.User.PasswordHash.Set("not a real hash"),
+		db.User.Name.Set(username),
+		db.User.Email.Set(username+"@example.com"),
+		db.User.Bio.Set(""),
+		db.User.ProfilePicURL.Set(common.DefaultPFPURL),
+		db.User.TokenVersion.Set(rand.Intn(10000)),
+		db.User.CreatedAt.Set(time.Now()),
+		db.User.Verified.Set(true),
+		db.User.HasPassword.Set(hasPassword),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		t.Fatalf("could not create user %s: %v", username, err)
+	}
+	t.Cleanup(func() {
+		deleteTestUser(username)
+	})
+	return user
+}
+
+// Delete a test user, along with everything that belongs to them
+func deleteTestUser(username string) {
+	common.Client.User.FindMany(
+		db.User.Username.Equals(username),
+	).Delete().Exec(common.BaseCtx)
+}
+
+// Add the cookies a response set to a request, like a browser would
+func addCookies(r *http.Request, cookies []*http.Cookie) {
+	for _, cookie := range cookies {
+		if cookie.MaxAge < 0 {
+			continue
+		}
+		r.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+}
@@ -1,252 +1,375 @@
 package auth
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"net/http"
-	"net/url"
-	"os"
-	"strings"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/oauth"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
-
-	"github.com/golang/gddo/httputil/header"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
 )
 
-type DiscordTokenData struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int    `json:"expires_in"`
-	Scope        string `json:"scope"`
+// An oauthLinkResponse stores where to send a user to link a provider
+type oauthLinkResponse struct {
+	URL string `json:"url"`
 }
 
-type DiscordUserData struct {
-	ID         string `json:"id"`
-	Username   string `json:"username"`
-	AvatarHash string `json:"avatar"`
-	Email      string `json:"email"`
+// How long a user has to finish logging in with a provider
+const oauthFlowExpiry = time.Minute * 10
+
+// Client used to talk to OAuth providers
+var oauthHTTPClient = &http.Client{Timeout: time.Second * 10}
+
+// OAuth providers users can log in with, by name
+var oauthProviders = map[string]oauth.Provider{}
+
+// Prefix of the provider user IDs given to Discord users who signed up before their Discord ID was stored.
+// The ID is filled in the next time they log in with Discord.
+const legacyProviderUserIDPrefix = "legacy:"
+
+// Load the OAuth providers configured in the environment
+func InitOAuthProviders() error {
+	ctx, cancel := context.WithTimeout(common.BaseCtx, time.Second*30)
+	defer cancel()
+
+	providers, err := oauth.LoadFromEnv(ctx, oauthHTTPClient)
+	if err != nil {
+		return err
+	}
+	for _, provider := range providers {
+		RegisterOAuthProvider(provider)
+	}
+	return nil
 }
 
-// Handles login requests (only works with Discord for now)
-func OAuth2callbackHandler(w http.ResponseWriter, r *http.Request) {
+// Make a provider available for logging in at /api/oauth/{provider}/...
+func RegisterOAuthProvider(provider oauth.Provider) {
+	oauthProviders[provider.Name()] = provider
+}
 
-	fmt.Println(r)
+// Set a short-lived cookie that is only sent back to a provider's OAuth routes
+func setOAuthCookie(w http.ResponseWriter, providerName string, name string, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		HttpOnly: true,
+		Secure:   true,
+		// Lax, so the cookie is sent when the provider redirects back to the callback
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/api/oauth/" + providerName,
+		MaxAge:   int(oauthFlowExpiry.Seconds()),
+	})
+}
 
-	// Check if content type is "application/json"
-	if r.Header.Get("Content-Type") != "" {
-		value, _ := header.ParseValueAndParams(r.Header, "Content-Type")
-		if value != "application/json" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: "Content-Type header is not application/json",
-			})
-			return
-		}
+// Delete a cookie set by setOAuthCookie
+func clearOAuthCookie(w http.ResponseWriter, providerName string, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/api/oauth/" + providerName,
+		MaxAge:   -1,
+	})
+}
+
+// Begin an OAuth flow with a provider, and return the URL of its authorization page
+func beginOAuthFlow(w http.ResponseWriter, provider oauth.Provider) (string, error) {
+	// The state protects the callback from CSRF, and the PKCE verifier protects the authorization code
+	state, err := util.GenSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := util.GenSecureToken(32)
+	if err != nil {
+		return "", err
 	}
 
-	data := url.Values{}
-	data.Set("client_id", os.Getenv("DISCORD_CLIENT_ID"))
-	data.Set("client_secret", os.Getenv("DISCORD_CLIENT_SECRET"))
-	data.Set("grant_type", "authorization_code")
-	data.Set("code", r.URL.Query().Get("code"))
-	data.Set("redirect_uri", "http://localhost:5000/callback")
+	setOAuthCookie(w, provider.Name(), "oauth_state", state)
+	setOAuthCookie(w, provider.Name(), "oauth_verifier", verifier)
+	return provider.AuthCodeURL(state, oauth.CodeChallenge(verifier)), nil
+}
 
-	fmt.Println(data)
+// Start logging in with a provider by redirecting to its authorization page
+func OAuthStartHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := oauthProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		sendError(w, http.StatusNotFound, "unknown OAuth provider")
+		return
+	}
 
-	req, err := http.NewRequest("POST", "https://discord.com/api/v8/oauth2/token", strings.NewReader(data.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	authURL, err := beginOAuthFlow(w, provider)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(common.HTTPError{
-			Error: err.Error(),
-		})
+		sendError(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// Start linking a provider to the authenticated user.
+// The client should send the user to the returned URL, and the callback links the account instead of logging in.
+func OAuthLinkHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := Authenticate(r.Header.Get("Authorization"), ScopeAccount)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(common.HTTPError{
-			Error: err.Error(),
-		})
+		sendError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	defer resp.Body.Close()
+	provider, ok := oauthProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		sendError(w, http.StatusNotFound, "unknown OAuth provider")
+		return
+	}
 
-	if resp.StatusCode == 200 {
-		tokenData := DiscordTokenData{}
-		err = json.NewDecoder(resp.Body).Decode(&tokenData)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: err.Error(),
-			})
-			return
-		}
+	linkToken, err := generatePurposeToken(username, "oauth_link", oauthFlowExpiry, jwt.MapClaims{
+		"provider": provider.Name(),
+	})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	authURL, err := beginOAuthFlow(w, provider)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	setOAuthCookie(w, provider.Name(), "oauth_link", linkToken)
 
-		req, err := http.NewRequest("GET", "https://discord.com/api/v8/users/@me", nil)
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+tokenData.AccessToken)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: err.Error(),
-			})
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(oauthLinkResponse{
+		URL: authURL,
+	})
+}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: err.Error(),
-			})
+// Handle the redirect back from a provider, and log the user in
+func OAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := oauthProviders[mux.Vars(r)["provider"]]
+	if !ok {
+		sendError(w, http.StatusNotFound, "unknown OAuth provider")
+		return
+	}
+
+	stateCookie, stateErr := r.Cookie("oauth_state")
+	verifierCookie, verifierErr := r.Cookie("oauth_verifier")
+	linkCookie, linkErr := r.Cookie("oauth_link")
+	// The state, verifier and link token are single use
+	clearOAuthCookie(w, provider.Name(), "oauth_state")
+	clearOAuthCookie(w, provider.Name(), "oauth_verifier")
+	clearOAuthCookie(w, provider.Name(), "oauth_link")
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		sendError(w, http.StatusUnauthorized, fmt.Sprintf("authorization failed: %s", providerErr))
+		return
+	}
+	if stateErr != nil || verifierErr != nil {
+		sendError(w, http.StatusBadRequest, "OAuth login expired, please try again")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(stateCookie.Value)) != 1 {
+		sendError(w, http.StatusBadRequest, "invalid OAuth state")
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		sendError(w, http.StatusBadRequest, "authorization code not present")
+		return
+	}
+
+	token, err := provider.Exchange(r.Context(), code, verifierCookie.Value)
+	if err != nil {
+		sendError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	profile, err := provider.FetchProfile(r.Context(), token)
+	if err != nil {
+		sendError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	// A link cookie means a logged in user is linking this provider to their account
+	if linkErr == nil {
+		claims, err := verifyPurposeToken(linkCookie.Value, "oauth_link")
+		if err != nil || claims["provider"] != provider.Name() {
+			sendError(w, http.StatusBadRequest, "invalid link request, please try again")
 			return
 		}
+		linkOAuthIdentity(w, claims["username"].(string), provider.Name(), profile)
+		return
+	}
+
+	loginOAuthUser(w, r, provider.Name(), profile)
+}
 
-		if resp.StatusCode == 200 {
-			userData := DiscordUserData{}
-			err = json.NewDecoder(resp.Body).Decode(&userData)
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(common.HTTPError{
-					Error: err.Error(),
-				})
-				return
-			}
-
-			fmt.Println(userData)
-
-			passwordHash, err := bcrypt.GenerateFromPassword([]byte(tokenData.RefreshToken), bcrypt.DefaultCost)
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(common.HTTPError{
-					Error: err.Error(),
-				})
-				return
-			}
-
-			// Check if user with username or email already exists
-
-			// TODO: Check if username is taken, and allow setting a new username
-			_, err1 := common.Client.User.FindUnique(
-				db.User.Username.Equals(userData.Username),
-			).Exec(common.BaseCtx)
-			_, err2 := common.Client.User.FindUnique(
-				db.User.Email.Equals(userData.Email),
-			).Exec(common.BaseCtx)
-			if (err1 == db.ErrNotFound) && (err2 == db.ErrNotFound) {
-				// Create user if no such user exists
-				_, err := common.Client.User.CreateOne(
-					db.User.Username.Set(userData.Username),
-					db.User.PasswordHash.Set(string(passwordHash)),
-					db.User.Name.Set(userData.Username),
-					db.User.Email.Set(userData.Email),
-					db.User.Bio.Set(""),
-					db.User.ProfilePicURL.Set("https://cdn.discordapp.com/avatars/"+userData.ID+"/"+userData.AvatarHash+".png"),
-					db.User.TokenVersion.Set(rand.Intn(10000)),
-					db.User.CreatedAt.Set(time.Now()),
-					db.User.OAuthProvider.Set("Discord"),
-				).With(
-					db.User.Dweets.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					).OrderBy(
-						db.Dweet.PostedAt.Order(db.DESC),
-					),
-				).Exec(common.BaseCtx)
-
-				if err != nil {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusInternalServerError)
-					json.NewEncoder(w).Encode(common.HTTPError{
-						Error: err.Error(),
-					})
-					return
-				}
-
-				// After checking for any errors, log the user in, and generate tokens
-				tokenData, err := generateTokens(userData.Username, tokenData.RefreshToken)
-				if err != nil {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusUnauthorized)
-					json.NewEncoder(w).Encode(common.HTTPError{
-						Error: err.Error(),
-					})
-					return
-				}
-
-				// Send the refresh token in a HTTPOnly cookie
-				c := http.Cookie{
-					Name:     "jid",
-					Value:    tokenData.RefreshToken,
-					HttpOnly: true,
-					Secure:   true,
-					Path:     "/api/refresh_token",
-				}
-				http.SetCookie(w, &c)
-
-				// Set the response headers
-				w.Header().Set("Content-Type", "application/json")
-				// Send the access token in JSON
-				json.NewEncoder(w).Encode(loginResponse{
-					AccessToken: tokenData.AccessToken,
-				})
-			} else {
-				// TODO: Check if user already exists, and OAuth login them in.
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(common.HTTPError{
-					Error: "Username/Email already taken",
-				})
-				return
-			}
+// Link a provider's account to an existing user
+func linkOAuthIdentity(w http.ResponseWriter, username string, providerName string, profile oauth.Profile) {
+	identity, err := common.Client.LinkedIdentity.FindFirst(
+		db.LinkedIdentity.Provider.Equals(providerName),
+		db.LinkedIdentity.ProviderUserID.Equals(profile.ID),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		if identity.UserID == username {
+			sendMessage(w, fmt.Sprintf("Your %s account is already linked", providerName))
 		} else {
-			// Send back any errors
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(common.HTTPError{
-					Error: err.Error(),
-				})
-				return
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(resp.StatusCode)
-			json.NewEncoder(w).Encode(string(body))
+			sendError(w, http.StatusConflict, fmt.Sprintf("This %s account is linked to another user", providerName))
 		}
-	} else {
-		// Send back any errors
-		body, err := ioutil.ReadAll(resp.Body)
+		return
+	}
+	if err != db.ErrNotFound {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	_, err = common.Client.LinkedIdentity.FindFirst(
+		db.LinkedIdentity.UserID.Equals(username),
+		db.LinkedIdentity.Provider.Equals(providerName),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		sendError(w, http.StatusConflict, fmt.Sprintf("You already linked a different %s account, unlink it first", providerName))
+		return
+	}
+	if err != db.ErrNotFound {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	_, err = common.Client.LinkedIdentity.CreateOne(
+		db.LinkedIdentity.Provider.Set(providerName),
+		db.LinkedIdentity.ProviderUserID.Set(profile.ID),
+		db.LinkedIdentity.User.Link(
+			db.User.Username.Equals(username),
+		),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	sendMessage(w, fmt.Sprintf("Linked your %s account", providerName))
+}
+
+// Log in the user a provider's account is linked to, or sign them up if it isn't linked to anyone
+func loginOAuthUser(w http.ResponseWriter, r *http.Request, providerName string, profile oauth.Profile) {
+	identity, err := common.Client.LinkedIdentity.FindFirst(
+		db.LinkedIdentity.Provider.Equals(providerName),
+		db.LinkedIdentity.ProviderUserID.Equals(profile.ID),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		completeLogin(w, r, identity.UserID)
+		return
+	}
+	if err != db.ErrNotFound {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Accounts are tied to emails, so only trust emails the provider has verified
+	if profile.Email == "" || !profile.EmailVerified {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("your %s account does not have a verified email", providerName))
+		return
+	}
+
+	// Never link to an existing account by email alone, or anyone who controls a provider account with
+	// the same email could take it over. The owner has to log in and link the provider themselves.
+	user, err := common.Client.User.FindUnique(
+		db.User.Email.Equals(profile.Email),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		// The exception is an account that was made from a provider account with this email before its ID was stored
+		result, err := common.Client.LinkedIdentity.FindMany(
+			db.LinkedIdentity.Provider.Equals(providerName),
+			db.LinkedIdentity.ProviderUserID.Equals(legacyProviderUserIDPrefix+user.Username),
+			db.LinkedIdentity.UserID.Equals(user.Username),
+		).Update(
+			db.LinkedIdentity.ProviderUserID.Set(profile.ID),
+		).Exec(common.BaseCtx)
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(common.HTTPError{
-				Error: err.Error(),
-			})
+			sendError(w, http.StatusInternalServerError, "internal server error")
 			return
 		}
+		if result.Count == 1 {
+			completeLogin(w, r, user.Username)
+			return
+		}
+		sendError(w, http.StatusConflict, fmt.Sprintf("An account with this email already exists. Log in and link your %s account from your settings.", providerName))
+		return
+	}
+	if err != db.ErrNotFound {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Provider usernames may be taken or invalid here, so let the user pick one before creating the account
+	startOAuthOnboarding(w, providerName, profile)
+}
+
+// List the providers linked to a user
+func GetLinkedIdentities(username string) ([]schema.LinkedIdentityType, error) {
+	identities, err := common.Client.LinkedIdentity.FindMany(
+		db.LinkedIdentity.UserID.Equals(username),
+	).OrderBy(
+		db.LinkedIdentity.CreatedAt.Order(db.ASC),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	identityList := make([]schema.LinkedIdentityType, 0, len(identities))
+	for _, identity := range identities {
+		identityList = append(identityList, schema.LinkedIdentityType{
+			Provider:  identity.Provider,
+			CreatedAt: identity.CreatedAt,
+		})
+	}
+	return identityList, nil
+}
+
+// Unlink a provider from a user, as long as they can still log in some other way
+func UnlinkProvider(username string, providerName string) (bool, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).With(
+		db.User.LinkedIdentities.Fetch(),
+		db.User.Passkeys.Fetch(),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+
+	identities := user.LinkedIdentities()
+	linked := false
+	for _, identity := range identities {
+		if identity.Provider == providerName {
+			linked = true
+		}
+	}
+	if !linked {
+		return false, apierr.NewNotFound(fmt.Sprintf("%s is not linked to your account", providerName))
+	}
+	// Passwords, other providers and passkeys can all still be used to log in
+	if !user.HasPassword && len(identities) == 1 && len(user.Passkeys()) == 0 {
+		return false, apierr.NewConflict("cannot unlink your only way to log in: add a passkey, or set a password using the forgot password link first")
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
-		json.NewEncoder(w).Encode(string(body))
+	_, err = common.Client.LinkedIdentity.FindMany(
+		db.LinkedIdentity.UserID.Equals(username),
+		db.LinkedIdentity.Provider.Equals(providerName),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
 	}
+	return true, nil
 }
@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/oauth"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+)
+
+// The GitHub account the fake provider logs everyone in as
+const fakeProviderUserID = "4242"
+
+// A fakeProvider is a GitHub-like OAuth provider that remembers the PKCE challenge of every code it hands out
+type fakeProvider struct {
+	server     *httptest.Server
+	mutex      sync.Mutex
+	challenges map[string]string
+	codes      int
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	p := &fakeProvider{challenges: map[string]string{}}
+
+	routes := http.NewServeMux()
+	// Approve every authorization request, and send the browser back to the callback with a code
+	routes.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("state") == "" {
+			http.Error(w, "missing state or PKCE challenge", http.StatusBadRequest)
+			return
+		}
+
+		p.mutex.Lock()
+		p.codes++
+		code := fmt.Sprintf("code-%d", p.codes)
+		p.challenges[code] = query.Get("code_challenge")
+		p.mutex.Unlock()
+
+		callback := query.Get("redirect_uri") + "?" + url.Values{
+			"code":  {code},
+			"state": {query.Get("state")},
+		}.Encode()
+		http.Redirect(w, r, callback, http.StatusFound)
+	})
+	// Codes can be exchanged once, and only with the verifier their challenge was made from
+	routes.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.PostForm.Get("code")
+
+		p.mutex.Lock()
+		challenge, ok := p.challenges[code]
+		delete(p.challenges, code)
+		p.mutex.Unlock()
+
+		if !ok || oauth.CodeChallenge(r.PostForm.Get("code_verifier")) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(oauth.Token{
+			AccessToken: "token-" + code,
+			TokenType:   "bearer",
+		})
+	})
+	routes.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-") {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": ` + fakeProviderUserID + `, "login": "octocat", "name": "The Octocat", "avatar_url": ""}`))
+	})
+	routes.HandleFunc("/emails", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"email": "octocat@example.com", "primary": true, "verified": true}]`))
+	})
+
+	p.server = httptest.NewServer(routes)
+	t.Cleanup(p.server.Close)
+
+	RegisterOAuthProvider(oauth.NewGitHubProvider(oauth.Config{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "http://localhost/api/oauth/github/callback",
+	}, oauth.Endpoint{
+		AuthURL:     p.server.URL + "/authorize",
+		TokenURL:    p.server.URL + "/token",
+		UserInfoURL: p.server.URL + "/user",
+	}, p.server.URL+"/emails", p.server.Client()))
+	return p
+}
+
+// Follow the authorization URL a flow was started with, and return the callback the provider redirects to
+func (p *fakeProvider) authorize(t *testing.T, authURL string) *url.URL {
+	t.Helper()
+	client := p.server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("could not authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("provider refused authorization request with %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("provider redirected to an invalid URL: %v", err)
+	}
+	return callback
+}
+
+func oauthRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/oauth/{provider}/start", OAuthStartHandler).Methods("GET")
+	router.HandleFunc("/api/oauth/{provider}/callback", OAuthCallbackHandler).Methods("GET")
+	router.HandleFunc("/api/oauth/{provider}/link", OAuthLinkHandler).Methods("POST")
+	return router
+}
+
+// Keep only the cookies a browser would still have once elapsed has passed
+func cookiesAfter(cookies []*http.Cookie, elapsed time.Duration) []*http.Cookie {
+	live := []*http.Cookie{}
+	for _, cookie := range cookies {
+		if cookie.MaxAge > 0 && time.Duration(cookie.MaxAge)*time.Second <= elapsed {
+			continue
+		}
+		live = append(live, cookie)
+	}
+	return live
+}
+
+func withoutCookie(cookies []*http.Cookie, name string) []*http.Cookie {
+	kept := []*http.Cookie{}
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			kept = append(kept, cookie)
+		}
+	}
+	return kept
+}
+
+func TestOAuthCallback(t *testing.T) {
+	provider := newFakeProvider(t)
+	router := oauthRouter()
+
+	startLogin := func(t *testing.T) *http.Request {
+		return httptest.NewRequest("GET", "/api/oauth/github/start", nil)
+	}
+
+	tests := []struct {
+		name string
+		// Whether the test needs users in the database
+		database bool
+		// Make the request that starts the flow
+		start func(t *testing.T) *http.Request
+		// Change the callback request the browser makes, like an attacker or an expired cookie would
+		tamper     func(query url.Values, cookies []*http.Cookie) []*http.Cookie
+		wantStatus int
+		wantBody   string
+		// Check what the callback did, for tests that get that far
+		check func(t *testing.T, body []byte)
+	}{
+		{
+			name:  "state mismatch",
+			start: startLogin,
+			tamper: func(query url.Values, cookies []*http.Cookie) []*http.Cookie {
+				query.Set("state", "forged-state")
+				return cookies
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid OAuth state",
+		},
+		{
+			name:  "missing state cookie",
+			start: startLogin,
+			tamper: func(query url.Values, cookies []*http.Cookie) []*http.Cookie {
+				return withoutCookie(cookies, "oauth_state")
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "OAuth login expired",
+		},
+		{
+			name:  "expired state cookie",
+			start: startLogin,
+			tamper: func(query url.Values, cookies []*http.Cookie) []*http.Cookie {
+				return cookiesAfter(cookies, oauthFlowExpiry+time.Second)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "OAuth login expired",
+		},
+		{
+			name:  "bad PKCE verifier",
+			start: startLogin,
+			tamper: func(query url.Values, cookies []*http.Cookie) []*http.Cookie {
+				cookies = withoutCookie(cookies, "oauth_verifier")
+				return append(cookies, &http.Cookie{Name: "oauth_verifier", Value: "not-the-verifier"})
+			},
+			wantStatus: http.StatusBadGateway,
+			wantBody:   "invalid_grant",
+		},
+		{
+			name:     "returning user logs in",
+			database: true,
+			start: func(t *testing.T) *http.Request {
+				createTestUser(t, "oauthreturning", false)
+				_, err := common.Client.LinkedIdentity.CreateOne(
+					db.LinkedIdentity.Provider.Set("github"),
+					db.LinkedIdentity.ProviderUserID.Set(fakeProviderUserID),
+					db.LinkedIdentity.User.Link(
+						db.User.Username.Equals("oauthreturning"),
+					),
+				).Exec(common.BaseCtx)
+				if err != nil {
+					t.Fatalf("could not link identity: %v", err)
+				}
+				return startLogin(t)
+			},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var tokens loginResponse
+				json.Unmarshal(body, &tokens)
+				claims, verified, err := VerifyAccessToken(tokens.AccessToken)
+				if err != nil || !verified {
+					t.Fatalf("did not get a valid access token: %v", err)
+				}
+				if claims["username"] != "oauthreturning" {
+					t.Errorf("logged in as %v, want oauthreturning", claims["username"])
+				}
+			},
+		},
+		{
+			name:     "link to existing account",
+			database: true,
+			start: func(t *testing.T) *http.Request {
+				createTestUser(t, "oauthlinker", true)
+				accessToken, err := generateAccessToken("oauthlinker", "")
+				if err != nil {
+					t.Fatalf("could not generate access token: %v", err)
+				}
+				r := httptest.NewRequest("POST", "/api/oauth/github/link", nil)
+				r.Header.Set("Authorization", "Bearer "+accessToken)
+				return r
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "Linked your github account",
+			check: func(t *testing.T, body []byte) {
+				identity, err := common.Client.LinkedIdentity.FindFirst(
+					db.LinkedIdentity.Provider.Equals("github"),
+					db.LinkedIdentity.ProviderUserID.Equals(fakeProviderUserID),
+				).Exec(common.BaseCtx)
+				if err != nil {
+					t.Fatalf("identity was not linked: %v", err)
+				}
+				if identity.UserID != "oauthlinker" {
+					t.Errorf("identity linked to %s, want oauthlinker", identity.UserID)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.database {
+				requireDatabase(t)
+			}
+
+			start := httptest.NewRecorder()
+			router.ServeHTTP(start, test.start(t))
+			if start.Code != http.StatusFound && start.Code != http.StatusOK {
+				t.Fatalf("starting the flow failed with %d: %s", start.Code, start.Body.String())
+			}
+
+			// Logins redirect to the provider, and links return the URL for the client to go to
+			authURL := start.Header().Get("Location")
+			if authURL == "" {
+				var link oauthLinkResponse
+				json.Unmarshal(start.Body.Bytes(), &link)
+				authURL = link.URL
+			}
+			callbackURL := provider.authorize(t, authURL)
+
+			query := callbackURL.Query()
+			cookies := start.Result().Cookies()
+			if test.tamper != nil {
+				cookies = test.tamper(query, cookies)
+			}
+			callback := httptest.NewRequest("GET", callbackURL.Path+"?"+query.Encode(), nil)
+			addCookies(callback, cookies)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, callback)
+			if rec.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, test.wantStatus, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), test.wantBody) {
+				t.Errorf("got body %q, want it to contain %q", rec.Body.String(), test.wantBody)
+			}
+			if test.check != nil {
+				test.check(t, rec.Body.Bytes())
+			}
+		})
+	}
+}
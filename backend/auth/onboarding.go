@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/oauth"
+	"github.com/soumitradev/Dwitter/backend/passwords"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
+)
+
+// How long a user has to pick a username after signing up with a provider
+const onboardingExpiry = time.Minute * 30
+
+// How many usernames to suggest to a user signing up with a provider
+const numUsernameSuggestions = 5
+
+// An onboardingResponse is sent instead of tokens when a new OAuth user still has to pick a username
+type onboardingResponse struct {
+	OnboardingRequired bool     `json:"onboardingRequired"`
+	OnboardingToken    string   `json:"onboardingToken"`
+	Suggestions        []string `json:"suggestions"`
+}
+
+// Turn a string into a username candidate by keeping only the characters usernames allow
+func sanitizeUsername(s string) string {
+	var builder strings.Builder
+	for _, c := range s {
+		if c <= unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			builder.WriteRune(c)
+		}
+	}
+	username := builder.String()
+	if len(username) > 20 {
+		username = username[:20]
+	}
+	return username
+}
+
+// Check if a username is valid and nobody has it yet
+func usernameAvailable(username string) (bool, error) {
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
+	if err != nil {
+		return false, nil
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	return false, nil
+}
+
+// Suggest free usernames based on a user's profile at a provider
+func suggestUsernames(profile oauth.Profile) ([]string, error) {
+	bases := []string{}
+	for _, candidate := range []string{profile.Username, profile.Name, strings.Split(profile.Email, "@")[0]} {
+		base := sanitizeUsername(candidate)
+		if base != "" {
+			bases = append(bases, base)
+		}
+	}
+	if len(bases) == 0 {
+		bases = append(bases, "user")
+	}
+
+	suggestions := []string{}
+	seen := map[string]bool{}
+	tryCandidate := func(candidate string) error {
+		if seen[candidate] {
+			return nil
+		}
+		seen[candidate] = true
+		available, err := usernameAvailable(candidate)
+		if err != nil {
+			return err
+		}
+		if available {
+			suggestions = append(suggestions, candidate)
+		}
+		return nil
+	}
+
+	// Prefer the names as they are, then add numbers to the end of them
+	for _, base := range bases {
+		if err := tryCandidate(base); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; len(suggestions) < numUsernameSuggestions && attempt < numUsernameSuggestions*4; attempt++ {
+		suffix := fmt.Sprint(rand.Intn(10000))
+		base := bases[attempt%len(bases)]
+		if len(base)+len(suffix) > 20 {
+			base = base[:20-len(suffix)]
+		}
+		if err := tryCandidate(base + suffix); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(suggestions) > numUsernameSuggestions {
+		suggestions = suggestions[:numUsernameSuggestions]
+	}
+	return suggestions, nil
+}
+
+// Send a new OAuth user an onboarding token that holds their profile until they pick a username
+func startOAuthOnboarding(w http.ResponseWriter, providerName string, profile oauth.Profile) {
+	suggestions, err := suggestUsernames(profile)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// The user hasn't picked a username yet, so the token isn't for any username
+	onboardingToken, err := generatePurposeToken("", "oauth_onboarding", onboardingExpiry, jwt.MapClaims{
+		"provider":         providerName,
+		"provider_user_id": profile.ID,
+		"email":            profile.Email,
+		"name":             profile.Name,
+		"avatar_url":       profile.AvatarURL,
+	})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(onboardingResponse{
+		OnboardingRequired: true,
+		OnboardingToken:    onboardingToken,
+		Suggestions:        suggestions,
+	})
+}
+
+// Create the account of a new OAuth user with the username they picked
+func createOAuthUser(onboardingToken string, username string) error {
+	claims, err := verifyPurposeToken(onboardingToken, "oauth_onboarding")
+	if err != nil {
+		return apierr.NewUnauthenticated("invalid or expired onboarding token, please log in again")
+	}
+	providerName, _ := claims["provider"].(string)
+	providerUserID, _ := claims["provider_user_id"].(string)
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+	avatarURL, _ := claims["avatar_url"].(string)
+	if providerName == "" || providerUserID == "" || email == "" {
+		return apierr.NewUnauthenticated("invalid or expired onboarding token, please log in again")
+	}
+
+	err = common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
+	if err != nil {
+		return err
+	}
+	available, err := usernameAvailable(username)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return apierr.NewConflict("username already taken")
+	}
+
+	// The token can be used more than once, so check nobody signed up with this account or email in the meantime
+	_, err = common.Client.LinkedIdentity.FindFirst(
+		db.LinkedIdentity.Provider.Equals(providerName),
+		db.LinkedIdentity.ProviderUserID.Equals(providerUserID),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		return apierr.NewConflict("this account has already been signed up, please log in again")
+	}
+	if err != db.ErrNotFound {
+		return apierr.NewInternal(err)
+	}
+	_, err = common.Client.User.FindUnique(
+		db.User.Email.Equals(email),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		return apierr.NewConflict("username/email already taken")
+	}
+	if err != db.ErrNotFound {
+		return apierr.NewInternal(err)
+	}
+
+	// OAuth users don't have a password, so give them one nobody knows
+	password, err := util.GenSecureToken(32)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	passwordHash, err := passwords.Hash(password)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+
+	if name == "" {
+		name = username
+	}
+	if nameRunes := []rune(name); len(nameRunes) > 40 {
+		name = string(nameRunes[:40])
+	}
+	if avatarURL == "" {
+		avatarURL = common.DefaultPFPURL
+	}
+
+	_, err = common.Client.User.CreateOne(
+		db.User.Username.Set(username),
+		db.User.PasswordHash.Set(string(passwordHash)),
+		db.User.Name.Set(name),
+		db.User.Email.Set(email),
+		db.User.Bio.Set(""),
+		db.User.ProfilePicURL.Set(avatarURL),
+		db.User.TokenVersion.Set(rand.Intn(10000)),
+		db.User.CreatedAt.Set(time.Now()),
+		db.User.Verified.Set(true),
+		db.User.HasPassword.Set(false),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+
+	_, err = common.Client.LinkedIdentity.CreateOne(
+		db.LinkedIdentity.Provider.Set(providerName),
+		db.LinkedIdentity.ProviderUserID.Set(providerUserID),
+		db.LinkedIdentity.User.Link(
+			db.User.Username.Equals(username),
+		),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		// Don't keep an account around that nobody can log in to
+		common.InternalDeleteUser(username)
+		return apierr.NewInternal(err)
+	}
+
+	return nil
+}
+
+// Finish signing up a new OAuth user with the username they picked, and log them in
+func CompleteOAuthSignup(onboardingToken string, username string, r *http.Request) (schema.AuthTokensType, error) {
+	err := createOAuthUser(onboardingToken, username)
+	if err != nil {
+		return schema.AuthTokensType{}, err
+	}
+
+	tokenData, err := issueTokens(username, r)
+	if err != nil {
+		return schema.AuthTokensType{}, err
+	}
+	return schema.AuthTokensType{
+		AccessToken: tokenData.AccessToken,
+		JID:         tokenData.RefreshToken,
+	}, nil
+}
@@ -0,0 +1,448 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
+	"github.com/soumitradev/Dwitter/backend/webauthn"
+)
+
+// The site passkeys are registered with. InitWebAuthn loads it again once .env has been loaded.
+var relyingParty = webauthn.LoadFromEnv()
+
+// A passkeyRegisterType stores an authenticator's response to a registration ceremony
+type passkeyRegisterType struct {
+	Name              string `json:"name"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// A passkeyRegisterBeginType stores the password of a user adding a passkey. Users without a password leave it empty.
+type passkeyRegisterBeginType struct {
+	Password string `json:"password"`
+}
+
+// A passkeyLoginBeginType stores a request to start logging in with a passkey. Username can be empty to use any passkey on the device.
+type passkeyLoginBeginType struct {
+	Username string `json:"username"`
+}
+
+// A passkeyLoginType stores an authenticator's response to a login ceremony
+type passkeyLoginType struct {
+	CredentialID      string `json:"credentialID"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+}
+
+// Load the site passkeys are registered with from the environment
+func InitWebAuthn() {
+	relyingParty = webauthn.LoadFromEnv()
+}
+
+// Format a passkey for the API
+func formatPasskey(passkey *db.PasskeyModel) schema.PasskeyType {
+	formatted := schema.PasskeyType{
+		ID:        passkey.DbID,
+		Name:      passkey.Name,
+		CreatedAt: passkey.CreatedAt,
+	}
+	if lastUsedAt, used := passkey.LastUsedAt(); used {
+		formatted.LastUsedAt = &lastUsedAt
+	}
+	return formatted
+}
+
+// Get the IDs of a user's passkeys
+func passkeyCredentialIDs(username string) ([][]byte, error) {
+	passkeys, err := common.Client.Passkey.FindMany(
+		db.Passkey.UserID.Equals(username),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	credentialIDs := make([][]byte, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		credentialID, err := webauthn.DecodeBase64(passkey.CredentialID)
+		if err != nil {
+			continue
+		}
+		credentialIDs = append(credentialIDs, credentialID)
+	}
+	return credentialIDs, nil
+}
+
+// Start a ceremony and remember its challenge until it is finished
+func beginCeremony(purpose string, username string) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", apierr.NewInternal(err)
+	}
+
+	optional := []db.WebAuthnChallengeSetParam{}
+	if username != "" {
+		optional = append(optional, db.WebAuthnChallenge.Username.Set(username))
+	}
+	_, err = common.Client.WebAuthnChallenge.CreateOne(
+		db.WebAuthnChallenge.ChallengeHash.Set(util.HashToken(challenge)),
+		db.WebAuthnChallenge.Purpose.Set(purpose),
+		db.WebAuthnChallenge.ExpiresAt.Set(time.Now().Add(webauthn.Timeout)),
+		optional...,
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return "", apierr.NewInternal(err)
+	}
+	return challenge, nil
+}
+
+// Find the ceremony a response is for, from the challenge in its client data.
+// The challenge is deleted before anything else is checked, so a response can only ever be used once.
+func finishCeremony(clientDataJSON []byte, purpose string) (string, *db.WebAuthnChallengeModel, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ceremony, err := common.Client.WebAuthnChallenge.FindUnique(
+		db.WebAuthnChallenge.ChallengeHash.Equals(util.HashToken(clientData.Challenge)),
+	).Delete().Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return "", nil, errors.New("unknown or expired challenge")
+	}
+	if err != nil {
+		return "", nil, apierr.NewInternal(err)
+	}
+	if ceremony.Purpose != purpose || time.Now().After(ceremony.ExpiresAt) {
+		return "", nil, errors.New("unknown or expired challenge")
+	}
+	return clientData.Challenge, ceremony, nil
+}
+
+// Handles requests to start registering a passkey for the authenticated user.
+// A passkey can log in without TOTP, so the user has to reauthenticate first, like when turning on TOTP.
+func PasskeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	username, sessionID, err := authenticateSession(r.Header.Get("Authorization"), ScopeAccount)
+	if err != nil || sessionID == "" {
+		sendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var beginData passkeyRegisterBeginType
+	if !decodeJSONBody(w, r, &beginData) {
+		return
+	}
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	err = reauthenticate(user, sessionID, beginData.Password, "adding a passkey")
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	existing, err := passkeyCredentialIDs(username)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	challenge, err := beginCeremony("register", username)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// The user handle is stored on the authenticator, so it's the database ID rather than anything personal
+	options := relyingParty.CreationOptions(challenge, webauthn.UserEntity{
+		ID:          webauthn.EncodeBase64([]byte(user.DbID)),
+		Name:        user.Username,
+		DisplayName: user.Name,
+	}, existing)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+// Handles an authenticator's response to a registration ceremony, and saves the new passkey
+func PasskeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := Authenticate(r.Header.Get("Authorization"), ScopeAccount)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var registerData passkeyRegisterType
+	if !decodeJSONBody(w, r, &registerData) {
+		return
+	}
+	err = common.ValidateVar("name", registerData.Name, "required,lte=60")
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "passkey name must be between 1 and 60 characters")
+		return
+	}
+	clientDataJSON, err := webauthn.DecodeBase64(registerData.ClientDataJSON)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "clientDataJSON must be base64url encoded")
+		return
+	}
+	attestationObject, err := webauthn.DecodeBase64(registerData.AttestationObject)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "attestationObject must be base64url encoded")
+		return
+	}
+
+	challenge, ceremony, err := finishCeremony(clientDataJSON, "register")
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ceremonyUser, _ := ceremony.Username()
+	if ceremonyUser != username {
+		sendError(w, http.StatusBadRequest, "unknown or expired challenge")
+		return
+	}
+
+	credential, err := relyingParty.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("passkey registration failed: %v", err))
+		return
+	}
+
+	credentialID := webauthn.EncodeBase64(credential.ID)
+	_, err = common.Client.Passkey.FindUnique(
+		db.Passkey.CredentialID.Equals(credentialID),
+	).Exec(common.BaseCtx)
+	if err == nil {
+		sendError(w, http.StatusConflict, "passkey is already registered")
+		return
+	}
+	if err != db.ErrNotFound {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	passkey, err := common.Client.Passkey.CreateOne(
+		db.Passkey.CredentialID.Set(credentialID),
+		db.Passkey.PublicKey.Set(webauthn.EncodeBase64(credential.PublicKey)),
+		db.Passkey.Name.Set(registerData.Name),
+		db.Passkey.User.Link(
+			db.User.Username.Equals(username),
+		),
+		db.Passkey.SignCount.Set(db.BigInt(credential.SignCount)),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(formatPasskey(passkey))
+}
+
+// Handles requests to start logging in with a passkey
+func PasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if rejectIfThrottled(w, r, "") {
+		return
+	}
+
+	var beginData passkeyLoginBeginType
+	if !decodeJSONBody(w, r, &beginData) {
+		return
+	}
+
+	// Unknown usernames get the same response as users without passkeys, so this can't be used to find out who is registered
+	allowed := [][]byte{}
+	if beginData.Username != "" {
+		credentialIDs, err := passkeyCredentialIDs(beginData.Username)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		allowed = credentialIDs
+	}
+
+	challenge, err := beginCeremony("login", beginData.Username)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(relyingParty.RequestOptions(challenge, allowed))
+}
+
+// Handles an authenticator's response to a login ceremony, sending the same tokens as LoginHandler
+func PasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if rejectIfThrottled(w, r, "") {
+		return
+	}
+
+	var loginData passkeyLoginType
+	if !decodeJSONBody(w, r, &loginData) {
+		return
+	}
+
+	loginFailed := func(reason error) {
+		err := recordFailedAttempt(r, "")
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		sendError(w, http.StatusUnauthorized, fmt.Sprintf("passkey login failed: %v", reason))
+	}
+
+	credentialID, err := webauthn.DecodeBase64(loginData.CredentialID)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "credentialID must be base64url encoded")
+		return
+	}
+	clientDataJSON, err := webauthn.DecodeBase64(loginData.ClientDataJSON)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "clientDataJSON must be base64url encoded")
+		return
+	}
+	authenticatorData, err := webauthn.DecodeBase64(loginData.AuthenticatorData)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "authenticatorData must be base64url encoded")
+		return
+	}
+	signature, err := webauthn.DecodeBase64(loginData.Signature)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "signature must be base64url encoded")
+		return
+	}
+
+	challenge, ceremony, err := finishCeremony(clientDataJSON, "login")
+	if err != nil {
+		loginFailed(err)
+		return
+	}
+
+	passkey, err := common.Client.Passkey.FindUnique(
+		db.Passkey.CredentialID.Equals(webauthn.EncodeBase64(credentialID)),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		loginFailed(errors.New("unknown passkey"))
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	// A ceremony started for one user can't be finished with somebody else's passkey
+	if ceremonyUser, present := ceremony.Username(); present && ceremonyUser != passkey.UserID {
+		loginFailed(errors.New("unknown passkey"))
+		return
+	}
+
+	publicKey, err := webauthn.DecodeBase64(passkey.PublicKey)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	assertion, err := relyingParty.VerifyAssertion(challenge, publicKey, uint32(passkey.SignCount), clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		loginFailed(err)
+		return
+	}
+
+	_, err = common.Client.Passkey.FindUnique(
+		db.Passkey.DbID.Equals(passkey.DbID),
+	).Update(
+		db.Passkey.SignCount.Set(db.BigInt(assertion.SignCount)),
+		db.Passkey.LastUsedAt.Set(time.Now()),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// A passkey that checked the user's PIN or biometrics is already two factors, so a TOTP code is only asked for otherwise
+	if assertion.UserVerified {
+		finishLogin(w, r, passkey.UserID)
+		return
+	}
+	completeLogin(w, r, passkey.UserID)
+}
+
+// List a user's passkeys
+func ListPasskeys(username string) ([]schema.PasskeyType, error) {
+	passkeys, err := common.Client.Passkey.FindMany(
+		db.Passkey.UserID.Equals(username),
+	).OrderBy(
+		db.Passkey.CreatedAt.Order(db.DESC),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	passkeyList := make([]schema.PasskeyType, 0, len(passkeys))
+	for i := range passkeys {
+		passkeyList = append(passkeyList, formatPasskey(&passkeys[i]))
+	}
+	return passkeyList, nil
+}
+
+// Delete one of a user's passkeys
+func DeletePasskey(username string, passkeyID string) (bool, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).With(
+		db.User.LinkedIdentities.Fetch(),
+		db.User.Passkeys.Fetch(),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	if !user.HasPassword && len(user.LinkedIdentities()) == 0 && len(user.Passkeys()) == 1 {
+		return false, apierr.NewConflict("cannot delete your only way to log in: link an account, or set a password using the forgot password link first")
+	}
+
+	result, err := common.Client.Passkey.FindMany(
+		db.Passkey.DbID.Equals(passkeyID),
+		db.Passkey.UserID.Equals(username),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	if result.Count == 0 {
+		return false, apierr.NewNotFound("passkey not found")
+	}
+	return true, nil
+}
+
+// Delete ceremonies that were never finished
+func DeleteExpiredWebAuthnChallenges() error {
+	_, err := common.Client.WebAuthnChallenge.FindMany(
+		db.WebAuthnChallenge.ExpiresAt.Before(time.Now()),
+	).Delete().Exec(common.BaseCtx)
+	return err
+}
+
+// Delete expired WebAuthn challenges right away, and then again every interval
+func SweepWebAuthnChallenges(interval time.Duration) {
+	for {
+		err := DeleteExpiredWebAuthnChallenges()
+		if err != nil {
+			fmt.Printf("Error sweeping WebAuthn challenges: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
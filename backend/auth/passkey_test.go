@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/webauthn"
+)
+
+// Append the head of a CBOR item, which is its major type and its length or value
+func appendCBORHead(out []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(out, major<<5|byte(n))
+	case n <= 0xff:
+		return append(out, major<<5|24, byte(n))
+	case n <= 0xffff:
+		return append(out, major<<5|25, byte(n>>8), byte(n))
+	default:
+		return append(out, major<<5|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func appendCBORInt(out []byte, n int64) []byte {
+	if n < 0 {
+		return appendCBORHead(out, 1, uint64(-1-n))
+	}
+	return appendCBORHead(out, 0, uint64(n))
+}
+
+func appendCBORBytes(out []byte, data []byte) []byte {
+	return append(appendCBORHead(out, 2, uint64(len(data))), data...)
+}
+
+func appendCBORText(out []byte, text string) []byte {
+	return append(appendCBORHead(out, 3, uint64(len(text))), text...)
+}
+
+// A softAuthenticator is an ES256 authenticator in memory, which does what a browser and security key would
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	// The origin the browser says the ceremony came from
+	origin string
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	credentialID := make([]byte, 32)
+	rand.Read(credentialID)
+	return &softAuthenticator{key: key, credentialID: credentialID, origin: relyingParty.Origins[0]}
+}
+
+// The credential public key as a COSE_Key
+func (a *softAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+
+	key := appendCBORHead(nil, 5, 5)
+	key = appendCBORInt(appendCBORInt(key, 1), 2)
+	key = appendCBORInt(appendCBORInt(key, 3), webauthn.AlgES256)
+	key = appendCBORInt(appendCBORInt(key, -1), 1)
+	key = appendCBORBytes(appendCBORInt(key, -2), x)
+	key = appendCBORBytes(appendCBORInt(key, -3), y)
+	return key
+}
+
+// Authenticator data with the user present and verified, bumping the signature counter
+func (a *softAuthenticator) authenticatorData(rpID string, flags byte) []byte {
+	a.signCount++
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags|0x01|0x04, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+	return data
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremonyType string, challenge string) []byte {
+	t.Helper()
+	clientDataJSON, err := json.Marshal(webauthn.ClientData{
+		Type:      ceremonyType,
+		Challenge: challenge,
+		Origin:    a.origin,
+	})
+	if err != nil {
+		t.Fatalf("could not encode client data: %v", err)
+	}
+	return clientDataJSON
+}
+
+// Create the credential, like navigator.credentials.create() with "none" attestation
+func (a *softAuthenticator) create(t *testing.T, rpID string, challenge string) passkeyRegisterType {
+	t.Helper()
+	authData := a.authenticatorData(rpID, 0x40)
+	// An all zero AAGUID, then the credential ID with its length
+	authData = append(authData, make([]byte, 18)...)
+	binary.BigEndian.PutUint16(authData[len(authData)-2:], uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, a.coseKey()...)
+
+	attestation := appendCBORHead(nil, 5, 3)
+	attestation = appendCBORText(appendCBORText(attestation, "fmt"), "none")
+	attestation = appendCBORHead(appendCBORText(attestation, "attStmt"), 5, 0)
+	attestation = appendCBORBytes(appendCBORText(attestation, "authData"), authData)
+
+	return passkeyRegisterType{
+		Name:              "Software key",
+		ClientDataJSON:    webauthn.EncodeBase64(a.clientData(t, "webauthn.create", challenge)),
+		AttestationObject: webauthn.EncodeBase64(attestation),
+	}
+}
+
+// Sign a login challenge, like navigator.credentials.get()
+func (a *softAuthenticator) get(t *testing.T, rpID string, challenge string) passkeyLoginType {
+	t.Helper()
+	authData := a.authenticatorData(rpID, 0)
+	clientDataJSON := a.clientData(t, "webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("could not sign assertion: %v", err)
+	}
+
+	return passkeyLoginType{
+		CredentialID:      webauthn.EncodeBase64(a.credentialID),
+		ClientDataJSON:    webauthn.EncodeBase64(clientDataJSON),
+		AuthenticatorData: webauthn.EncodeBase64(authData),
+		Signature:         webauthn.EncodeBase64(signature),
+	}
+}
+
+// Decode the base64url fields of an assertion
+func decodeAssertion(t *testing.T, assertion passkeyLoginType) (clientDataJSON []byte, authData []byte, signature []byte) {
+	t.Helper()
+	var err error
+	for _, field := range []struct {
+		encoded string
+		decoded *[]byte
+	}{
+		{assertion.ClientDataJSON, &clientDataJSON},
+		{assertion.AuthenticatorData, &authData},
+		{assertion.Signature, &signature},
+	} {
+		*field.decoded, err = webauthn.DecodeBase64(field.encoded)
+		if err != nil {
+			t.Fatalf("could not decode assertion: %v", err)
+		}
+	}
+	return clientDataJSON, authData, signature
+}
+
+func TestPasskeyVerification(t *testing.T) {
+	tests := []struct {
+		name string
+		// Sign the login challenge, with the registered authenticator or another one
+		sign    func(t *testing.T, owner *softAuthenticator, other *softAuthenticator, challenge string) passkeyLoginType
+		wantErr string
+	}{
+		{
+			name: "assertion",
+			sign: func(t *testing.T, owner *softAuthenticator, other *softAuthenticator, challenge string) passkeyLoginType {
+				return owner.get(t, relyingParty.ID, challenge)
+			},
+		},
+		{
+			name: "sign count regression",
+			sign: func(t *testing.T, owner *softAuthenticator, other *softAuthenticator, challenge string) passkeyLoginType {
+				// A clone of the authenticator made at registration would still have the old counter
+				owner.signCount = 0
+				return owner.get(t, relyingParty.ID, challenge)
+			},
+			wantErr: webauthn.ErrSignCountNotIncreased.Error(),
+		},
+		{
+			name: "another credential's signature",
+			sign: func(t *testing.T, owner *softAuthenticator, other *softAuthenticator, challenge string) passkeyLoginType {
+				return other.get(t, relyingParty.ID, challenge)
+			},
+			wantErr: "invalid signature",
+		},
+		{
+			name: "wrong origin",
+			sign: func(t *testing.T, owner *softAuthenticator, other *softAuthenticator, challenge string) passkeyLoginType {
+				owner.origin = "https://evil.example"
+				return owner.get(t, relyingParty.ID, challenge)
+			},
+			wantErr: "is not allowed",
+		},
+		{
+			name: "wrong relying party",
+			sign: func(t *testing.T, owner *softAuthenticator, other *softAuthenticator, challenge string) passkeyLoginType {
+				return owner.get(t, "evil.example", challenge)
+			},
+			wantErr: "credential is for another site",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owner := newSoftAuthenticator(t)
+			other := newSoftAuthenticator(t)
+
+			challenge, err := webauthn.NewChallenge()
+			if err != nil {
+				t.Fatalf("could not make challenge: %v", err)
+			}
+			registration := owner.create(t, relyingParty.ID, challenge)
+			clientDataJSON, _ := webauthn.DecodeBase64(registration.ClientDataJSON)
+			attestationObject, _ := webauthn.DecodeBase64(registration.AttestationObject)
+			credential, err := relyingParty.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if err != nil {
+				t.Fatalf("attestation was rejected: %v", err)
+			}
+			if !bytes.Equal(credential.ID, owner.credentialID) || credential.Algorithm != webauthn.AlgES256 || credential.SignCount != 1 {
+				t.Fatalf("registered the wrong credential: %+v", credential)
+			}
+
+			// Log in once, so the stored counter is ahead of where the authenticator started
+			challenge, _ = webauthn.NewChallenge()
+			clientDataJSON, authData, signature := decodeAssertion(t, owner.get(t, relyingParty.ID, challenge))
+			first, err := relyingParty.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature)
+			if err != nil {
+				t.Fatalf("first assertion was rejected: %v", err)
+			}
+
+			challenge, _ = webauthn.NewChallenge()
+			clientDataJSON, authData, signature = decodeAssertion(t, test.sign(t, owner, other, challenge))
+			assertion, err := relyingParty.VerifyAssertion(challenge, credential.PublicKey, first.SignCount, clientDataJSON, authData, signature)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("assertion was rejected: %v", err)
+				}
+				if assertion.SignCount != owner.signCount || !assertion.UserVerified {
+					t.Errorf("got assertion %+v, want sign count %d and user verified", assertion, owner.signCount)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// Send a JSON body to a passkey handler
+func callPasskeyHandler(handler http.HandlerFunc, accessToken string, body interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	r := httptest.NewRequest("POST", "/api/passkey", bytes.NewReader(encoded))
+	r.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
+
+// Register a passkey for a user without a password through the registration ceremony, from a session that just logged in
+func registerPasskey(t *testing.T, username string, authenticator *softAuthenticator) {
+	t.Helper()
+	sessionID, err := createSession(username, httptest.NewRequest("POST", "/api/login", nil))
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+	accessToken, err := generateAccessToken(username, sessionID)
+	if err != nil {
+		t.Fatalf("could not generate access token: %v", err)
+	}
+
+	begin := callPasskeyHandler(PasskeyRegisterBeginHandler, accessToken, passkeyRegisterBeginType{})
+	if begin.Code != http.StatusOK {
+		t.Fatalf("starting registration failed with %d: %s", begin.Code, begin.Body.String())
+	}
+	var options webauthn.CreationOptions
+	json.Unmarshal(begin.Body.Bytes(), &options)
+
+	finish := callPasskeyHandler(PasskeyRegisterFinishHandler, accessToken, authenticator.create(t, options.RP.ID, options.Challenge))
+	if finish.Code != http.StatusOK {
+		t.Fatalf("attestation was rejected with %d: %s", finish.Code, finish.Body.String())
+	}
+
+	passkey, err := common.Client.Passkey.FindUnique(
+		db.Passkey.CredentialID.Equals(webauthn.EncodeBase64(authenticator.credentialID)),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		t.Fatalf("passkey was not saved: %v", err)
+	}
+	if passkey.UserID != username {
+		t.Fatalf("passkey saved for %s, want %s", passkey.UserID, username)
+	}
+}
+
+// Log in with a passkey, starting the ceremony for username, or for any passkey if it is empty
+func loginWithPasskey(t *testing.T, username string, authenticator *softAuthenticator) *httptest.ResponseRecorder {
+	t.Helper()
+	begin := callPasskeyHandler(PasskeyLoginBeginHandler, "", passkeyLoginBeginType{Username: username})
+	if begin.Code != http.StatusOK {
+		t.Fatalf("starting login failed with %d: %s", begin.Code, begin.Body.String())
+	}
+	var options webauthn.RequestOptions
+	json.Unmarshal(begin.Body.Bytes(), &options)
+
+	return callPasskeyHandler(PasskeyLoginFinishHandler, "", authenticator.get(t, options.RPID, options.Challenge))
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// The user the login ceremony is started for
+		loginAs string
+		// Change the authenticator before it signs, like a clone would
+		tamper     func(a *softAuthenticator)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "assertion logs in",
+			loginAs:    "passkeyowner",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "discoverable login",
+			loginAs:    "",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:    "sign count regression",
+			loginAs: "passkeyowner",
+			tamper: func(a *softAuthenticator) {
+				a.signCount = 0
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   "signature counter did not increase",
+		},
+		{
+			name:       "another user's passkey",
+			loginAs:    "passkeyother",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   "unknown passkey",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requireDatabase(t)
+			createTestUser(t, "passkeyowner", false)
+			createTestUser(t, "passkeyother", false)
+			t.Cleanup(func() {
+				common.Client.LoginThrottle.FindMany(
+					db.LoginThrottle.Key.Equals(ipThrottleKey(clientIP(httptest.NewRequest("POST", "/", nil)))),
+				).Delete().Exec(common.BaseCtx)
+			})
+
+			authenticator := newSoftAuthenticator(t)
+			registerPasskey(t, "passkeyowner", authenticator)
+			registerPasskey(t, "passkeyother", newSoftAuthenticator(t))
+
+			// Log in once, so the stored counter is ahead of where the authenticator started
+			first := loginWithPasskey(t, "passkeyowner", authenticator)
+			if first.Code != http.StatusOK {
+				t.Fatalf("first login failed with %d: %s", first.Code, first.Body.String())
+			}
+
+			if test.tamper != nil {
+				test.tamper(authenticator)
+			}
+			rec := loginWithPasskey(t, test.loginAs, authenticator)
+			if rec.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, test.wantStatus, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), test.wantBody) {
+				t.Errorf("got body %q, want it to contain %q", rec.Body.String(), test.wantBody)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var tokens loginResponse
+			json.Unmarshal(rec.Body.Bytes(), &tokens)
+			claims, verified, err := VerifyAccessToken(tokens.AccessToken)
+			if err != nil || !verified {
+				t.Fatalf("did not get a valid access token: %v", err)
+			}
+			if claims["username"] != "passkeyowner" {
+				t.Errorf("logged in as %v, want passkeyowner", claims["username"])
+			}
+		})
+	}
+}
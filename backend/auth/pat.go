@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
+)
+
+// Scopes a request can be authorized for
+const (
+	ScopeRead         = "read"
+	ScopeWriteDweets  = "write:dweets"
+	ScopeWriteLikes   = "write:likes"
+	ScopeWriteFollows = "write:follows"
+	ScopeWriteProfile = "write:profile"
+	// Managing the account itself, like sessions, tokens and two-factor authentication.
+	// Only tokens from a real login have it, personal access tokens never do.
+	ScopeAccount = "account"
+)
+
+// Scopes that can be granted to a personal access token
+var PersonalAccessTokenScopes = []string{ScopeRead, ScopeWriteDweets, ScopeWriteLikes, ScopeWriteFollows, ScopeWriteProfile}
+
+// Personal access tokens start with this, so they are easy to tell apart from JWTs and to find in leaked code
+const personalAccessTokenPrefix = "dwt_"
+
+// Most personal access tokens a user can have at once
+const maxPersonalAccessTokens = 20
+
+// Check if a verified token is authorized for a scope.
+// Tokens from a login have no scope claim, and are authorized for everything.
+func HasScope(claims jwt.MapClaims, scope string) bool {
+	scopes, ok := claims["scope"].(string)
+	if !ok {
+		return true
+	}
+	for _, granted := range strings.Fields(scopes) {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Return an error if a verified token is not authorized for a scope
+func RequireScope(claims jwt.MapClaims, scope string) error {
+	if !HasScope(claims, scope) {
+		return apierr.NewForbidden("Forbidden: token does not have the %s scope", scope)
+	}
+	return nil
+}
+
+// Check if a verified token is a personal access token
+func IsPersonalAccessToken(claims jwt.MapClaims) bool {
+	_, ok := claims["token_id"]
+	return ok
+}
+
+// Verify a personal access token, and return claims like the ones in an access token
+func verifyPersonalAccessToken(tokenString string) (jwt.MapClaims, bool, error) {
+	token, err := common.Client.PersonalAccessToken.FindUnique(
+		db.PersonalAccessToken.TokenHash.Equals(util.HashToken(tokenString)),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return jwt.MapClaims{}, false, apierr.NewUnauthenticated("authentication error: invalid personal access token")
+	}
+	if err != nil {
+		return jwt.MapClaims{}, false, apierr.NewInternal(err)
+	}
+
+	expiresAt, expires := token.ExpiresAt()
+	if expires && time.Now().After(expiresAt) {
+		return jwt.MapClaims{}, false, apierr.NewUnauthenticated("authentication error: personal access token has expired")
+	}
+
+	// Tokens stop working while their owner is suspended, deactivated or about to be deleted, the same as access tokens
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(token.UserID),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return jwt.MapClaims{}, false, apierr.NewUnauthenticated("user doesn't exist")
+	}
+	if err != nil {
+		return jwt.MapClaims{}, false, apierr.NewInternal(err)
+	}
+	err = checkTokenOwner(user)
+	if err != nil {
+		return jwt.MapClaims{}, false, err
+	}
+
+	// Only record usage once a minute, so busy bots don't write on every request
+	lastUsedAt, used := token.LastUsedAt()
+	if !used || time.Since(lastUsedAt) > time.Minute {
+		_, err = common.Client.PersonalAccessToken.FindUnique(
+			db.PersonalAccessToken.DbID.Equals(token.DbID),
+		).Update(
+			db.PersonalAccessToken.LastUsedAt.Set(time.Now()),
+		).Exec(common.BaseCtx)
+		if err != nil {
+			return jwt.MapClaims{}, false, apierr.NewInternal(err)
+		}
+	}
+
+	return jwt.MapClaims{
+		"authorized": true,
+		"username":   token.UserID,
+		"scope":      strings.Join(token.Scopes, " "),
+		"token_id":   token.DbID,
+	}, true, nil
+}
+
+// Format a personal access token. The token itself is only known when it is created.
+func formatPersonalAccessToken(token *db.PersonalAccessTokenModel, tokenString string) schema.PersonalAccessTokenType {
+	formatted := schema.PersonalAccessTokenType{
+		ID:        token.DbID,
+		Name:      token.Name,
+		Token:     tokenString,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+	}
+	if lastUsedAt, used := token.LastUsedAt(); used {
+		formatted.LastUsedAt = &lastUsedAt
+	}
+	if expiresAt, expires := token.ExpiresAt(); expires {
+		formatted.ExpiresAt = &expiresAt
+	}
+	return formatted
+}
+
+// Create a personal access token for a user. expiresInDays can be 0 for a token that never expires.
+func CreatePersonalAccessToken(username string, name string, scopes []string, expiresInDays int) (schema.PersonalAccessTokenType, error) {
+	err := common.ValidateVar("name", name, "required,lte=60")
+	if err != nil {
+		return schema.PersonalAccessTokenType{}, err
+	}
+	err = common.ValidateVar("expiresInDays", expiresInDays, "gte=0,lte=365")
+	if err != nil {
+		return schema.PersonalAccessTokenType{}, err
+	}
+	if len(scopes) == 0 {
+		return schema.PersonalAccessTokenType{}, apierr.NewValidation("scopes", "a personal access token needs at least one scope")
+	}
+
+	// Check scopes, and drop duplicates
+	grantedScopes := []string{}
+	for _, scope := range scopes {
+		valid := false
+		for _, allowed := range PersonalAccessTokenScopes {
+			if scope == allowed {
+				valid = true
+			}
+		}
+		if !valid {
+			return schema.PersonalAccessTokenType{}, apierr.NewValidation("scopes", "invalid scope %q, scopes must be one of: %s", scope, strings.Join(PersonalAccessTokenScopes, ", "))
+		}
+		duplicate := false
+		for _, granted := range grantedScopes {
+			if scope == granted {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			grantedScopes = append(grantedScopes, scope)
+		}
+	}
+
+	existing, err := common.Client.PersonalAccessToken.FindMany(
+		db.PersonalAccessToken.UserID.Equals(username),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.PersonalAccessTokenType{}, apierr.NewInternal(err)
+	}
+	if len(existing) >= maxPersonalAccessTokens {
+		return schema.PersonalAccessTokenType{}, apierr.NewConflict(fmt.Sprintf("you can't have more than %d personal access tokens, revoke one first", maxPersonalAccessTokens))
+	}
+
+	secret, err := util.GenSecureToken(32)
+	if err != nil {
+		return schema.PersonalAccessTokenType{}, apierr.NewInternal(err)
+	}
+	tokenString := personalAccessTokenPrefix + secret
+
+	var expiresAt *time.Time
+	if expiresInDays > 0 {
+		expiry := time.Now().Add(time.Hour * 24 * time.Duration(expiresInDays))
+		expiresAt = &expiry
+	}
+
+	token, err := common.Client.PersonalAccessToken.CreateOne(
+		db.PersonalAccessToken.Name.Set(name),
+		db.PersonalAccessToken.TokenHash.Set(util.HashToken(tokenString)),
+		db.PersonalAccessToken.User.Link(
+			db.User.Username.Equals(username),
+		),
+		db.PersonalAccessToken.Scopes.Set(grantedScopes),
+		db.PersonalAccessToken.ExpiresAt.SetOptional(expiresAt),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.PersonalAccessTokenType{}, apierr.NewInternal(err)
+	}
+
+	return formatPersonalAccessToken(token, tokenString), nil
+}
+
+// List a user's personal access tokens
+func ListPersonalAccessTokens(username string) ([]schema.PersonalAccessTokenType, error) {
+	tokens, err := common.Client.PersonalAccessToken.FindMany(
+		db.PersonalAccessToken.UserID.Equals(username),
+	).OrderBy(
+		db.PersonalAccessToken.CreatedAt.Order(db.DESC),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	tokenList := make([]schema.PersonalAccessTokenType, 0, len(tokens))
+	for i := range tokens {
+		tokenList = append(tokenList, formatPersonalAccessToken(&tokens[i], ""))
+	}
+	return tokenList, nil
+}
+
+// Revoke one of a user's personal access tokens
+func RevokePersonalAccessToken(username string, tokenID string) (bool, error) {
+	result, err := common.Client.PersonalAccessToken.FindMany(
+		db.PersonalAccessToken.DbID.Equals(tokenID),
+		db.PersonalAccessToken.UserID.Equals(username),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	if result.Count == 0 {
+		return false, apierr.NewNotFound("personal access token not found")
+	}
+	return true, nil
+}
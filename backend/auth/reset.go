@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/mailer"
+	"github.com/soumitradev/Dwitter/backend/passwords"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/util"
+)
+
+// How long a password reset link stays valid
+const passwordResetExpiry = time.Hour
+
+// Reset links are emailed, so requests are limited per email and per IP like magic link requests
+var passwordResetEmailThrottle = throttlePolicy{threshold: 3, baseLockout: time.Minute * 5, maxLockout: time.Hour}
+var passwordResetIPThrottle = throttlePolicy{threshold: 10, baseLockout: time.Minute, maxLockout: time.Hour}
+
+// A forgotPasswordType stores a request for a password reset link
+type forgotPasswordType struct {
+	Email string `json:"email"`
+}
+
+// A resetPasswordType stores a password reset token and the new password
+type resetPasswordType struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// A passwordErrorType is sent when a new password breaks the password policy, with every reason it does
+type passwordErrorType struct {
+	Error   string                `json:"error"`
+	Reasons []passwords.Violation `json:"reasons"`
+}
+
+// Send the reasons a new password was rejected, or an internal error if it couldn't be checked
+func sendPasswordError(w http.ResponseWriter, err error) {
+	policyErr, ok := err.(*passwords.PolicyError)
+	if !ok {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(passwordErrorType{
+		Error:   policyErr.Error(),
+		Reasons: policyErr.Violations,
+	})
+}
+
+// Send a user a password reset link
+func SendPasswordResetEmail(emailID string, link string) error {
+	return mailer.Send(emailID, "password_reset", map[string]interface{}{
+		"Link": link,
+	})
+}
+
+// Handles requests for a password reset link
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var forgotData forgotPasswordType
+	if !decodeJSONBody(w, r, &forgotData) {
+		return
+	}
+
+	err := common.ValidateVar("email", forgotData.Email, "required,email,lte=100")
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	// Registered and unregistered emails are limited alike, so the limit doesn't give away which is which
+	if rejectIfRequestedTooOften(w, map[string]throttlePolicy{
+		"reset_email:" + strings.ToLower(forgotData.Email): passwordResetEmailThrottle,
+		"reset_ip:" + clientIP(r):                          passwordResetIPThrottle,
+	}) {
+		return
+	}
+
+	// Always send the same response so that the endpoint can't be used to find out which emails are registered
+	msg := "If an account with that email exists, a password reset link has been sent to it"
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Email.Equals(forgotData.Email),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound || (err == nil && !user.Verified) {
+		sendMessage(w, msg)
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Only the latest reset link should work
+	_, err = common.Client.PasswordResetToken.FindMany(
+		db.PasswordResetToken.UserID.Equals(user.Username),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	token, err := util.GenSecureToken(32)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	_, err = common.Client.PasswordResetToken.CreateOne(
+		db.PasswordResetToken.TokenHash.Set(util.HashToken(token)),
+		db.PasswordResetToken.User.Link(
+			db.User.Username.Equals(user.Username),
+		),
+		db.PasswordResetToken.ExpiresAt.Set(time.Now().Add(passwordResetExpiry)),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	link := "http://localhost:5000/reset_password/" + token
+	err = SendPasswordResetEmail(user.Email, link)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "error sending email, please try again later")
+		return
+	}
+
+	sendMessage(w, msg)
+}
+
+// Handles password resets using a token from a password reset link
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var resetData resetPasswordType
+	if !decodeJSONBody(w, r, &resetData) {
+		return
+	}
+
+	err := passwords.Validate(resetData.Password)
+	if err != nil {
+		sendPasswordError(w, err)
+		return
+	}
+
+	// Delete the token before using it so that it can only ever be used once
+	resetToken, err := common.Client.PasswordResetToken.FindUnique(
+		db.PasswordResetToken.TokenHash.Equals(util.HashToken(resetData.Token)),
+	).Delete().Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		sendError(w, http.StatusBadRequest, "Invalid or expired password reset link")
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if time.Now().After(resetToken.ExpiresAt) {
+		sendError(w, http.StatusBadRequest, "Invalid or expired password reset link")
+		return
+	}
+
+	passwordHash, err := passwords.Hash(resetData.Password)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Set the new password and bump the token version so that all existing refresh tokens stop working
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(resetToken.UserID),
+	).Update(
+		db.User.PasswordHash.Set(string(passwordHash)),
+		db.User.HasPassword.Set(true),
+		db.User.TokenVersion.Increment(1),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		sendError(w, http.StatusBadRequest, "Invalid or expired password reset link")
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Invalidate any other reset links that were sent out
+	_, err = common.Client.PasswordResetToken.FindMany(
+		db.PasswordResetToken.UserID.Equals(resetToken.UserID),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	sendMessage(w, "Password reset successfully, you may sign in with your new password now")
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+)
+
+// Roles a user can have. Each role can do everything the roles before it can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles in order of how much they can do
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// Check if a role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// Check if a verified token's user has a role, or one above it.
+// Personal access tokens never have more than the user role, so bots can't moderate.
+func HasRole(claims jwt.MapClaims, role string) bool {
+	if IsPersonalAccessToken(claims) {
+		return role == RoleUser
+	}
+	granted, ok := claims["role"].(string)
+	if !ok {
+		granted = RoleUser
+	}
+	grantedRank, ok := roleRanks[granted]
+	if !ok {
+		return false
+	}
+	return grantedRank >= roleRanks[role]
+}
+
+// Return an error if a verified token's user doesn't have a role, or one above it
+func RequireRole(claims jwt.MapClaims, role string) error {
+	if !HasRole(claims, role) {
+		return apierr.NewForbidden("Forbidden: you need to be a %s to do this", role)
+	}
+	return nil
+}
+
+// Change a user's role. Admins can't change their own role, so there is always at least one admin left.
+func SetUserRole(adminUsername string, username string, role string) (string, error) {
+	if !ValidRole(role) {
+		return "", apierr.NewValidation("role", "invalid role %q, roles must be one of: %s, %s, %s", role, RoleUser, RoleModerator, RoleAdmin)
+	}
+	if adminUsername == username {
+		return "", apierr.NewForbidden("you can't change your own role")
+	}
+
+	_, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.Role.Set(role),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return "", apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return "", apierr.NewInternal(err)
+	}
+	return role, nil
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
+)
+
+// How long a session stays alive without being used. Matches the lifetime of a refresh token.
+const sessionExpiry = time.Hour * 24 * 7
+
+// The refresh token cookie is sent to the API, which is where the refresh and logout endpoints are
+const refreshCookiePath = "/api"
+
+// Refresh token cookies used to only be sent to the refresh endpoint. Browsers send the more specific cookie first,
+// so one left over there would be used instead of the current one.
+const legacyRefreshCookiePath = "/api/refresh_token"
+
+// Returned when a session doesn't exist, belongs to someone else or was already revoked
+var ErrSessionNotFound = apierr.NewNotFound("session not found")
+
+// Known browsers and operating systems, in the order they should be checked in a user agent
+var knownBrowsers = [][2]string{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+}
+var knownSystems = [][2]string{
+	{"Windows", "Windows"},
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Mac OS X", "macOS"},
+	{"CrOS", "ChromeOS"},
+	{"Linux", "Linux"},
+}
+
+// Get the IP address of the client that made a request.
+// X-Forwarded-For is only trusted if TRUST_PROXY_HEADERS is set, since clients can forge it.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Make a short human readable device label, like "Firefox on Linux", from a user agent
+func deviceLabel(userAgent string) string {
+	browser := ""
+	for _, known := range knownBrowsers {
+		if strings.Contains(userAgent, known[0]) {
+			browser = known[1]
+			break
+		}
+	}
+	system := ""
+	for _, known := range knownSystems {
+		if strings.Contains(userAgent, known[0]) {
+			system = known[1]
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
+
+// Start a new session for a user logging in with the given request
+func createSession(username string, r *http.Request) (string, error) {
+	userAgent := r.UserAgent()
+	session, err := common.Client.Session.CreateOne(
+		db.Session.User.Link(
+			db.User.Username.Equals(username),
+		),
+		db.Session.DeviceLabel.Set(deviceLabel(userAgent)),
+		db.Session.IPAddress.Set(clientIP(r)),
+		db.Session.UserAgent.Set(userAgent),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return "", apierr.NewInternal(err)
+	}
+	return session.DbID, nil
+}
+
+// Check if a session exists, belongs to the user, and has not been revoked or gone stale
+func sessionActive(sessionID string, username string) (bool, error) {
+	_, err := common.Client.Session.FindFirst(
+		db.Session.DbID.Equals(sessionID),
+		db.Session.UserID.Equals(username),
+		db.Session.Revoked.Equals(false),
+		db.Session.LastUsedAt.After(time.Now().Add(-sessionExpiry)),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	return true, nil
+}
+
+// Start a new refresh token rotation for a session, so only the token with the returned ID works
+func newRefreshTokenID(sessionID string) (string, error) {
+	tokenID, err := util.GenSecureToken(16)
+	if err != nil {
+		return "", apierr.NewInternal(err)
+	}
+	_, err = common.Client.Session.FindUnique(
+		db.Session.DbID.Equals(sessionID),
+	).Update(
+		db.Session.RefreshTokenID.Set(tokenID),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return "", apierr.NewInternal(err)
+	}
+	return tokenID, nil
+}
+
+// Swap a session's refresh token ID for a new one, as long as usedID is still the latest.
+// If it isn't, the token was already rotated and is being reused, so the whole session is revoked and false is returned.
+func rotateRefreshTokenID(sessionID string, usedID string) (string, bool, error) {
+	tokenID, err := util.GenSecureToken(16)
+	if err != nil {
+		return "", false, apierr.NewInternal(err)
+	}
+
+	// Checking and swapping in one update means two requests can't both rotate the same token
+	result, err := common.Client.Session.FindMany(
+		db.Session.DbID.Equals(sessionID),
+		db.Session.RefreshTokenID.Equals(usedID),
+		db.Session.Revoked.Equals(false),
+	).Update(
+		db.Session.RefreshTokenID.Set(tokenID),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return "", false, apierr.NewInternal(err)
+	}
+	if result.Count == 1 {
+		return tokenID, true, nil
+	}
+
+	// Either the legitimate client or whoever stole the token already rotated it, and there's no telling which, so log both out
+	_, err = common.Client.Session.FindUnique(
+		db.Session.DbID.Equals(sessionID),
+	).Update(
+		db.Session.Revoked.Set(true),
+	).Exec(common.BaseCtx)
+	if err != nil && err != db.ErrNotFound {
+		return "", false, apierr.NewInternal(err)
+	}
+	return "", false, nil
+}
+
+// Record that a session was just used from the given request
+func touchSession(sessionID string, r *http.Request) error {
+	_, err := common.Client.Session.FindUnique(
+		db.Session.DbID.Equals(sessionID),
+	).Update(
+		db.Session.LastUsedAt.Set(time.Now()),
+		db.Session.IPAddress.Set(clientIP(r)),
+		db.Session.UserAgent.Set(r.UserAgent()),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	return nil
+}
+
+// List a user's active sessions, most recently used first
+func ListSessions(username string, currentSessionID string) ([]schema.SessionType, error) {
+	sessions, err := common.Client.Session.FindMany(
+		db.Session.UserID.Equals(username),
+		db.Session.Revoked.Equals(false),
+		db.Session.LastUsedAt.After(time.Now().Add(-sessionExpiry)),
+	).OrderBy(
+		db.Session.LastUsedAt.Order(db.DESC),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	sessionList := make([]schema.SessionType, 0, len(sessions))
+	for _, session := range sessions {
+		sessionList = append(sessionList, schema.SessionType{
+			ID:          session.DbID,
+			DeviceLabel: session.DeviceLabel,
+			IPAddress:   session.IPAddress,
+			UserAgent:   session.UserAgent,
+			CreatedAt:   session.CreatedAt,
+			LastUsedAt:  session.LastUsedAt,
+			Current:     session.DbID == currentSessionID,
+		})
+	}
+	return sessionList, nil
+}
+
+// Revoke one of a user's sessions, so its refresh token can no longer be used
+func RevokeSession(username string, sessionID string) (bool, error) {
+	result, err := common.Client.Session.FindMany(
+		db.Session.DbID.Equals(sessionID),
+		db.Session.UserID.Equals(username),
+		db.Session.Revoked.Equals(false),
+	).Update(
+		db.Session.Revoked.Set(true),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	if result.Count == 0 {
+		return false, ErrSessionNotFound
+	}
+	return true, nil
+}
+
+// Revoke all of a user's sessions except keepSessionID, if given, and return how many were revoked
+func RevokeAllSessions(username string, keepSessionID string) (int, error) {
+	result, err := common.Client.Session.FindMany(
+		db.Session.UserID.Equals(username),
+		db.Session.Revoked.Equals(false),
+		db.Session.Not(
+			db.Session.DbID.Equals(keepSessionID),
+		),
+	).Update(
+		db.Session.Revoked.Set(true),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return 0, apierr.NewInternal(err)
+	}
+	return result.Count, nil
+}
+
+// Delete the refresh token cookie from the client
+func clearRefreshCookie(w http.ResponseWriter) {
+	expireRefreshCookie(w, refreshCookiePath)
+	expireRefreshCookie(w, legacyRefreshCookiePath)
+}
+
+// Delete the refresh token cookie set on a path
+func expireRefreshCookie(w http.ResponseWriter, path string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "jid",
+		Value:    "",
+		HttpOnly: true,
+		Secure:   true,
+		Path:     path,
+		MaxAge:   -1,
+	})
+}
+
+// Handle logout requests by revoking the current session and clearing the refresh token cookie
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	var token string
+	if cookie, err := r.Cookie("jid"); err == nil {
+		token = cookie.Value
+	} else if r.ContentLength != 0 {
+		var logoutData loginResponse
+		if !decodeJSONBody(w, r, &logoutData) {
+			return
+		}
+		token = logoutData.JID
+	}
+
+	// An invalid or expired token has no live session to revoke, so just clear the cookie
+	if token != "" {
+		claims, verified, err := verifyRefreshToken(token)
+		if err == nil && verified {
+			_, err = RevokeSession(claims["username"].(string), claims["session_id"].(string))
+			if err != nil && !errors.Is(err, ErrSessionNotFound) {
+				sendError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+		}
+	}
+
+	clearRefreshCookie(w)
+	sendMessage(w, "Logged out")
+}
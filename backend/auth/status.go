@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+)
+
+// Suspend a user until a time, logging them out everywhere. Their profile and posts are hidden until the suspension ends.
+func SuspendUser(adminUsername string, username string, reason string, until time.Time) (bool, error) {
+	err := common.ValidateVar("reason", reason, "required,lte=200")
+	if err != nil {
+		return false, apierr.NewValidation("reason", "the reason must be between 1 and 200 characters")
+	}
+	if !until.After(time.Now()) {
+		return false, apierr.NewValidation("until", "a suspension has to end in the future")
+	}
+	if adminUsername == username {
+		return false, apierr.NewForbidden("you can't suspend yourself")
+	}
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	if user.Role == RoleAdmin {
+		return false, apierr.NewForbidden("admins can't be suspended, change their role first")
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.Status.Set(common.AccountSuspended),
+		db.User.SuspensionReason.Set(reason),
+		db.User.SuspendedUntil.Set(until),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+
+	_, err = RevokeAllSessions(username, "")
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Lift a user's suspension early
+func UnsuspendUser(username string) (bool, error) {
+	result, err := common.Client.User.FindMany(
+		db.User.Username.Equals(username),
+		db.User.Status.Equals(common.AccountSuspended),
+	).Update(
+		db.User.Status.Set(common.AccountActive),
+		db.User.SuspensionReason.SetOptional(nil),
+		db.User.SuspendedUntil.SetOptional(nil),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	if result.Count == 0 {
+		return false, apierr.NewNotFound("user not found or not suspended")
+	}
+	return true, nil
+}
+
+// Switch off a user's account until they log in at /api/reactivate, logging them out everywhere.
+// Nothing is deleted, their profile and posts are only hidden.
+func DeactivateAccount(username string, sessionID string, password string) (bool, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+
+	err = reauthenticate(user, sessionID, password, "deactivating your account")
+	if err != nil {
+		return false, err
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.Status.Set(common.AccountDeactivated),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+
+	_, err = RevokeAllSessions(username, "")
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Lift suspensions that have run out, so those users show up again
+func LiftExpiredSuspensions() error {
+	_, err := common.Client.User.FindMany(
+		db.User.Status.Equals(common.AccountSuspended),
+		db.User.SuspendedUntil.Before(time.Now()),
+	).Update(
+		db.User.Status.Set(common.AccountActive),
+		db.User.SuspensionReason.SetOptional(nil),
+		db.User.SuspendedUntil.SetOptional(nil),
+	).Exec(common.BaseCtx)
+	return err
+}
+
+// Lift expired suspensions right away, and then again every interval
+func SweepExpiredSuspensions(interval time.Duration) {
+	for {
+		err := LiftExpiredSuspensions()
+		if err != nil {
+			fmt.Printf("Error lifting expired suspensions: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Handles logins that reactivate a deactivated account. Accounts that are already active are just logged in.
+func ReactivateHandler(w http.ResponseWriter, r *http.Request) {
+	var loginData loginType
+	if !decodeJSONBody(w, r, &loginData) {
+		return
+	}
+
+	if rejectIfThrottled(w, r, loginData.Username) {
+		return
+	}
+
+	_, err := common.CheckCreds(loginData.Username, loginData.Password)
+	if errors.Is(err, common.ErrInvalidCreds) {
+		err = recordFailedAttempt(r, loginData.Username)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		sendError(w, http.StatusUnauthorized, common.ErrInvalidCreds.Error())
+		return
+	}
+	if err != nil && err != common.ErrAccountDeactivated {
+		sendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	// The password alone isn't enough, so the account stays deactivated until any second factor has been passed too
+	startSecondFactor(w, r, loginData.Username, err == common.ErrAccountDeactivated)
+}
+
+// Switch a deactivated account back on and log them in, once every check including a second factor has been passed
+func finishReactivation(w http.ResponseWriter, r *http.Request, username string) {
+	_, err := common.Client.User.FindMany(
+		db.User.Username.Equals(username),
+		db.User.Status.Equals(common.AccountDeactivated),
+	).Update(
+		db.User.Status.Set(common.AccountActive),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	finishLogin(w, r, username)
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/mailer"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+)
+
+// A throttlePolicy decides when repeated failures lock something out, and for how long
+type throttlePolicy struct {
+	// Failures allowed before the first lockout
+	threshold int
+	// Length of the first lockout. Every failure after that doubles it.
+	baseLockout time.Duration
+	maxLockout  time.Duration
+}
+
+// Failed logins are tracked per account, and per IP so one client can't try passwords on many accounts
+var accountThrottle = throttlePolicy{threshold: 5, baseLockout: time.Minute, maxLockout: time.Hour}
+var ipThrottle = throttlePolicy{threshold: 20, baseLockout: time.Minute, maxLockout: time.Hour}
+
+// Failures are forgotten after this long without another one
+const throttleWindow = time.Hour * 24
+
+func accountThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check how much longer something is locked out for. Zero means it isn't locked.
+func throttleRemaining(key string) (time.Duration, error) {
+	throttle, err := common.Client.LoginThrottle.FindUnique(
+		db.LoginThrottle.Key.Equals(key),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, apierr.NewInternal(err)
+	}
+
+	lockedUntil, locked := throttle.LockedUntil()
+	if !locked || time.Now().After(lockedUntil) {
+		return 0, nil
+	}
+	return time.Until(lockedUntil), nil
+}
+
+// Record a failure, and lock it out with exponential backoff once there have been too many.
+// Returns how long it is now locked out for, which is zero if it isn't.
+func recordFailure(key string, policy throttlePolicy) (time.Duration, error) {
+	now := time.Now()
+	// Start counting again if the last failure was long enough ago to be forgotten
+	_, err := common.Client.LoginThrottle.FindMany(
+		db.LoginThrottle.Key.Equals(key),
+		db.LoginThrottle.LastFailureAt.Before(now.Add(-throttleWindow)),
+	).Update(
+		db.LoginThrottle.Failures.Set(0),
+		db.LoginThrottle.LockedUntil.SetOptional(nil),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return 0, apierr.NewInternal(err)
+	}
+
+	// Concurrent failures can both be the first one, so create and increment in one statement
+	throttle, err := common.Client.LoginThrottle.UpsertOne(
+		db.LoginThrottle.Key.Equals(key),
+	).Create(
+		db.LoginThrottle.Key.Set(key),
+		db.LoginThrottle.Failures.Set(1),
+		db.LoginThrottle.LastFailureAt.Set(now),
+	).Update(
+		db.LoginThrottle.Failures.Increment(1),
+		db.LoginThrottle.LastFailureAt.Set(now),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return 0, apierr.NewInternal(err)
+	}
+
+	if throttle.Failures < policy.threshold {
+		return 0, nil
+	}
+
+	lockout := time.Duration(float64(policy.baseLockout) * math.Pow(2, float64(throttle.Failures-policy.threshold)))
+	if lockout > policy.maxLockout || lockout <= 0 {
+		lockout = policy.maxLockout
+	}
+	_, err = common.Client.LoginThrottle.FindUnique(
+		db.LoginThrottle.Key.Equals(key),
+	).Update(
+		db.LoginThrottle.LockedUntil.Set(now.Add(lockout)),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return 0, apierr.NewInternal(err)
+	}
+	return lockout, nil
+}
+
+// Forget all failures, unlocking it if it was locked
+func resetThrottle(key string) error {
+	_, err := common.Client.LoginThrottle.FindMany(
+		db.LoginThrottle.Key.Equals(key),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	return nil
+}
+
+// Format a lockout for error messages, rounding up to the next second
+func formatLockout(remaining time.Duration) string {
+	return (remaining.Truncate(time.Second) + time.Second).String()
+}
+
+// Reject a request if the client's IP or the account is locked out. Returns true if the request was rejected.
+// username can be empty for requests that aren't for a specific account.
+func rejectIfThrottled(w http.ResponseWriter, r *http.Request, username string) bool {
+	remaining, err := throttleRemaining(ipThrottleKey(clientIP(r)))
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return true
+	}
+	if remaining > 0 {
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(remaining.Seconds()))))
+		sendError(w, http.StatusTooManyRequests, "too many failed attempts, try again in "+formatLockout(remaining))
+		return true
+	}
+
+	if username == "" {
+		return false
+	}
+	remaining, err = throttleRemaining(accountThrottleKey(username))
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return true
+	}
+	if remaining > 0 {
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(remaining.Seconds()))))
+		sendError(w, http.StatusLocked, "account locked after too many failed login attempts, try again in "+formatLockout(remaining))
+		return true
+	}
+	return false
+}
+
+// Count a request that is limited whether or not it succeeds, like one that sends an email, against each of its keys.
+// The request is rejected if any of the keys is locked out. Returns true if the request was rejected.
+func rejectIfRequestedTooOften(w http.ResponseWriter, limits map[string]throttlePolicy) bool {
+	for key := range limits {
+		remaining, err := throttleRemaining(key)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return true
+		}
+		if remaining > 0 {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(remaining.Seconds()))))
+			sendError(w, http.StatusTooManyRequests, "too many requests, try again in "+formatLockout(remaining))
+			return true
+		}
+	}
+
+	for key, policy := range limits {
+		_, err := recordFailure(key, policy)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return true
+		}
+	}
+	return false
+}
+
+// Record a failed attempt against the client's IP and the account, and tell the owner if the account gets locked.
+// username can be empty for requests that aren't for a specific account.
+func recordFailedAttempt(r *http.Request, username string) error {
+	_, err := recordFailure(ipThrottleKey(clientIP(r)), ipThrottle)
+	if err != nil {
+		return err
+	}
+
+	if username == "" {
+		return nil
+	}
+	lockout, err := recordFailure(accountThrottleKey(username), accountThrottle)
+	if err != nil {
+		return err
+	}
+
+	// Only email on the first lockout, not on every failure after it
+	if lockout == accountThrottle.baseLockout {
+		user, err := common.Client.User.FindUnique(
+			db.User.Username.Equals(username),
+		).Exec(common.BaseCtx)
+		if err == nil {
+			err = SendLockoutEmail(user.Email, clientIP(r))
+			if err != nil {
+				fmt.Printf("Error sending lockout email: %v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Tell a user their account was locked after too many failed logins
+func SendLockoutEmail(emailID string, ip string) error {
+	return mailer.Send(emailID, "lockout", map[string]interface{}{
+		"IP":   ip,
+		"Link": "http://localhost:5000/forgot_password",
+	})
+}
+
+// Delete failures that are old enough to have been forgotten
+func DeleteStaleLoginThrottles() error {
+	_, err := common.Client.LoginThrottle.FindMany(
+		db.LoginThrottle.LastFailureAt.Before(time.Now().Add(-throttleWindow)),
+	).Delete().Exec(common.BaseCtx)
+	return err
+}
+
+// Delete stale failures periodically
+func SweepLoginThrottles(interval time.Duration) {
+	for {
+		err := DeleteStaleLoginThrottles()
+		if err != nil {
+			fmt.Printf("Error sweeping login throttles: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Unlock an account locked after too many failed logins
+func UnlockAccount(username string) error {
+	return resetThrottle(accountThrottleKey(username))
+}
+
+// Unlock an IP locked out after too many failed logins
+func UnlockIP(ip string) error {
+	return resetThrottle(ipThrottleKey(ip))
+}
@@ -0,0 +1,425 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TOTP parameters as recommended by RFC 6238 (and the only ones most authenticator apps support)
+const totpPeriod = 30
+const totpDigits = 6
+
+// Number of time steps before and after the current one that are accepted to allow for clock drift
+const totpSkew = 1
+
+const totpIssuer = "Dwitter"
+const totpChallengeExpiry = time.Minute * 5
+const recoveryCodeCount = 10
+
+// A totpChallengeResponse is sent instead of tokens when a login needs a TOTP code to finish
+type totpChallengeResponse struct {
+	TOTPRequired   bool   `json:"totpRequired"`
+	ChallengeToken string `json:"challengeToken"`
+}
+
+// A totpLoginType stores a challenge token along with a TOTP or recovery code
+type totpLoginType struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+// A totpChallenge is a login that is waiting for a TOTP or recovery code
+type totpChallenge struct {
+	username string
+	id       string
+	// Whether a deactivated account is reactivated once the code has been checked
+	reactivate bool
+}
+
+// Generate an HOTP code (RFC 4226) for a counter
+func hotpCode(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// Check a TOTP code (RFC 6238) and return the time step it belongs to.
+// Steps at or before lastUsedStep are rejected so that a code can't be used twice.
+func validateTOTP(secret string, code string, lastUsedStep int) (int, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + int64(i)
+		if step <= int64(lastUsedStep) {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotpCode(key, uint64(step))), []byte(code)) == 1 {
+			return int(step), true
+		}
+	}
+	return 0, false
+}
+
+// Generate a random recovery code of format xxxxx-xxxxx
+func genRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(b)
+	return code[:5] + "-" + code[5:], nil
+}
+
+// Check a TOTP code or a recovery code for a user, and mark it as used if it is valid
+func checkSecondFactor(user *db.UserModel, code string) (bool, error) {
+	secret, present := user.TotpSecret()
+	if !present {
+		return false, nil
+	}
+
+	step, ok := validateTOTP(secret, code, user.TotpLastUsedStep)
+	if ok {
+		// Only move the last used step forward if nothing else has used this step yet,
+		// so two requests racing with the same code can't both succeed
+		result, err := common.Client.User.FindMany(
+			db.User.Username.Equals(user.Username),
+			db.User.TotpLastUsedStep.Lt(step),
+		).Update(
+			db.User.TotpLastUsedStep.Set(step),
+		).Exec(common.BaseCtx)
+		if err != nil {
+			return false, err
+		}
+		return result.Count == 1, nil
+	}
+
+	// Recovery codes can only be used once, so remove the code if it matches
+	codeHash := util.HashToken(strings.ToLower(strings.TrimSpace(code)))
+	for index, recoveryCode := range user.TotpRecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(recoveryCode), []byte(codeHash)) == 1 {
+			remaining := append([]string{}, user.TotpRecoveryCodes[:index]...)
+			remaining = append(remaining, user.TotpRecoveryCodes[index+1:]...)
+			// The codes are only replaced if they haven't changed since they were read, so a code can't be used twice at once
+			result, err := common.Client.User.FindMany(
+				db.User.Username.Equals(user.Username),
+				db.User.TotpRecoveryCodes.Equals(user.TotpRecoveryCodes),
+			).Update(
+				db.User.TotpRecoveryCodes.Set(remaining),
+			).Exec(common.BaseCtx)
+			if err != nil {
+				return false, err
+			}
+			return result.Count == 1, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Start TOTP enrollment for a user. TOTP is only enabled once a code is confirmed using ConfirmTOTP.
+// Turning on 2FA can lock the owner out, so whoever does it has to reauthenticate first.
+func EnrollTOTP(username string, sessionID string, password string) (schema.TOTPEnrollmentType, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return schema.TOTPEnrollmentType{}, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return schema.TOTPEnrollmentType{}, apierr.NewInternal(err)
+	}
+
+	if user.TotpEnabled {
+		return schema.TOTPEnrollmentType{}, apierr.NewConflict("two-factor authentication is already enabled")
+	}
+
+	err = reauthenticate(user, sessionID, password, "setting up two-factor authentication")
+	if err != nil {
+		return schema.TOTPEnrollmentType{}, err
+	}
+
+	secretBytes := make([]byte, 20)
+	_, err = rand.Read(secretBytes)
+	if err != nil {
+		return schema.TOTPEnrollmentType{}, apierr.NewInternal(err)
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secretBytes)
+
+	recoveryCodes := make([]string, recoveryCodeCount)
+	recoveryCodeHashes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		recoveryCodes[i], err = genRecoveryCode()
+		if err != nil {
+			return schema.TOTPEnrollmentType{}, apierr.NewInternal(err)
+		}
+		recoveryCodeHashes[i] = util.HashToken(recoveryCodes[i])
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.TotpSecret.Set(secret),
+		db.User.TotpRecoveryCodes.Set(recoveryCodeHashes),
+		db.User.TotpLastUsedStep.Set(0),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.TOTPEnrollmentType{}, apierr.NewInternal(err)
+	}
+
+	uri := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + totpIssuer + ":" + username,
+		RawQuery: url.Values{
+			"secret":    {secret},
+			"issuer":    {totpIssuer},
+			"algorithm": {"SHA1"},
+			"digits":    {fmt.Sprint(totpDigits)},
+			"period":    {fmt.Sprint(totpPeriod)},
+		}.Encode(),
+	}
+
+	return schema.TOTPEnrollmentType{
+		Secret:        secret,
+		URI:           uri.String(),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// Finish TOTP enrollment by checking a code from the authenticator app, after reauthenticating like EnrollTOTP
+func ConfirmTOTP(username string, sessionID string, password string, code string) (bool, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+
+	if user.TotpEnabled {
+		return false, apierr.NewConflict("two-factor authentication is already enabled")
+	}
+
+	err = reauthenticate(user, sessionID, password, "setting up two-factor authentication")
+	if err != nil {
+		return false, err
+	}
+
+	secret, present := user.TotpSecret()
+	if !present {
+		return false, apierr.NewConflict("two-factor authentication enrollment has not been started")
+	}
+
+	step, ok := validateTOTP(secret, code, user.TotpLastUsedStep)
+	if !ok {
+		return false, apierr.NewValidation("code", "invalid code")
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.TotpEnabled.Set(true),
+		db.User.TotpLastUsedStep.Set(step),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	return true, nil
+}
+
+// Turn off TOTP for a user, given a valid TOTP or recovery code
+func DisableTOTP(username string, code string) (bool, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+
+	if !user.TotpEnabled {
+		return false, apierr.NewConflict("two-factor authentication is not enabled")
+	}
+
+	ok, err := checkSecondFactor(user, code)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	if !ok {
+		return false, apierr.NewValidation("code", "invalid code")
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.TotpEnabled.Set(false),
+		db.User.TotpSecret.SetOptional(nil),
+		db.User.TotpRecoveryCodes.Set([]string{}),
+		db.User.TotpLastUsedStep.Set(0),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	return true, nil
+}
+
+// Generate a short-lived token that proves the password step of a login was completed.
+// Only the latest challenge token of a user works, and only until it is used.
+func generateTOTPChallengeToken(username string, reactivate bool) (string, error) {
+	challengeID, err := util.GenSecureToken(16)
+	if err != nil {
+		return "", err
+	}
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.TotpChallengeID.Set(challengeID),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return "", err
+	}
+	return generatePurposeToken(username, "totp_challenge", totpChallengeExpiry, jwt.MapClaims{
+		"jti":        challengeID,
+		"reactivate": reactivate,
+	})
+}
+
+// Verify a TOTP challenge token and return the username and challenge ID it was issued for
+func verifyTOTPChallengeToken(tokenString string) (totpChallenge, error) {
+	claims, err := verifyPurposeToken(tokenString, "totp_challenge")
+	if err != nil {
+		return totpChallenge{}, err
+	}
+	challengeID, ok := claims["jti"].(string)
+	if !ok {
+		return totpChallenge{}, apierr.NewUnauthenticated("invalid token")
+	}
+	reactivate, _ := claims["reactivate"].(bool)
+	return totpChallenge{
+		username:   claims["username"].(string),
+		id:         challengeID,
+		reactivate: reactivate,
+	}, nil
+}
+
+// Use up a TOTP challenge, returning false if it was already used or a newer one was issued
+func consumeTOTPChallenge(username string, challengeID string) (bool, error) {
+	result, err := common.Client.User.FindMany(
+		db.User.Username.Equals(username),
+		db.User.TotpChallengeID.Equals(challengeID),
+	).Update(
+		db.User.TotpChallengeID.SetOptional(nil),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return false, err
+	}
+	return result.Count == 1, nil
+}
+
+// Handles the second step of a login for users with TOTP enabled
+func TOTPLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var loginData totpLoginType
+	if !decodeJSONBody(w, r, &loginData) {
+		return
+	}
+
+	challenge, err := verifyTOTPChallengeToken(loginData.ChallengeToken)
+	if err != nil {
+		sendError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	username := challenge.username
+
+	// Codes are short, so guessing them is throttled like guessing passwords
+	if rejectIfThrottled(w, r, username) {
+		return
+	}
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		sendError(w, http.StatusUnauthorized, "user doesn't exist")
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	ok, err := checkSecondFactor(user, loginData.Code)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !ok {
+		err = recordFailedAttempt(r, username)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		sendError(w, http.StatusUnauthorized, "invalid code")
+		return
+	}
+
+	consumed, err := consumeTOTPChallenge(username, challenge.id)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if !consumed {
+		sendError(w, http.StatusUnauthorized, "challenge token was already used, please log in again")
+		return
+	}
+
+	if challenge.reactivate {
+		finishReactivation(w, r, username)
+		return
+	}
+	finishLogin(w, r, username)
+}
@@ -4,36 +4,108 @@ package auth
 import (
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/sendgrid/rest"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/mailer"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/util"
 )
 
-// TODO: Make the email look better
-func SendVerificationEmail(emailID string, link string) (*rest.Response, error) {
-	from := mail.NewEmail("Dwitter", os.Getenv("SENDGRID_SENDER_EMAIL_ADDR"))
-	subject := "Dwitter account verification"
-	to := mail.NewEmail("Recipient", emailID)
-	plainTextContent := "Your verification link for Dwitter is: " + link + ".\nClick the link to verify your account.\nUnverified accounts are deleted after 1 hour."
-	htmlContent := "Your verification link for Dwitter is: " + "<a href=\"" + link + "\">" + link + "</a>\r\n" + "Click the link to verify your account.\r\n" + "<strong>Unverified accounts are deleted after 1 hour.</strong>"
-	message := mail.NewSingleEmail(from, subject, to, plainTextContent, htmlContent)
-	response, err := common.SendgridClient.Send(message)
-	return response, err
+// How long a verification link stays valid. Unverified accounts without a valid link are deleted.
+const verificationExpiry = time.Hour
+
+// Verification links are emailed, so resend requests are limited per email and per IP like magic link requests
+var verificationEmailThrottle = throttlePolicy{threshold: 3, baseLockout: time.Minute * 5, maxLockout: time.Hour}
+var verificationIPThrottle = throttlePolicy{threshold: 10, baseLockout: time.Minute, maxLockout: time.Hour}
+
+// A resendVerificationType stores a request for a new verification link
+type resendVerificationType struct {
+	Email string `json:"email"`
+}
+
+// Send a user the link that verifies their account
+func SendVerificationEmail(emailID string, link string) error {
+	return mailer.Send(emailID, "verification", map[string]interface{}{
+		"Link": link,
+	})
+}
+
+// Create a new verification token for a user, replacing any older ones, and email a link with it
+func SendVerificationLink(username string, email string) error {
+	// Only the latest verification link should work
+	_, err := common.Client.VerificationToken.FindMany(
+		db.VerificationToken.UserID.Equals(username),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		return err
+	}
+
+	token, err := util.GenSecureToken(32)
+	if err != nil {
+		return err
+	}
+
+	_, err = common.Client.VerificationToken.CreateOne(
+		db.VerificationToken.TokenHash.Set(util.HashToken(token)),
+		db.VerificationToken.User.Link(
+			db.User.Username.Equals(username),
+		),
+		db.VerificationToken.ExpiresAt.Set(time.Now().Add(verificationExpiry)),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return err
+	}
+
+	link := "http://localhost:5000/api/verify/" + token
+	err = SendVerificationEmail(email, link)
+	return err
 }
 
-func DeleteUserAfterExpire(minutes int, token string) {
-	time.Sleep(time.Minute * time.Duration(minutes))
-	if username, found := common.AccountCreatedButNotVerified[token]; found {
-		_, err := common.InternalDeleteUser(username)
+// Delete unverified accounts that don't have a valid verification link anymore, and any expired links
+func DeleteExpiredUnverifiedUsers() error {
+	now := time.Now()
+
+	users, err := common.Client.User.FindMany(
+		db.User.Verified.Equals(false),
+		db.User.CreatedAt.Before(now.Add(-verificationExpiry)),
+	).With(
+		db.User.VerificationTokens.Fetch(
+			db.VerificationToken.ExpiresAt.After(now),
+		),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if len(user.VerificationTokens()) > 0 {
+			continue
+		}
+		_, err := common.InternalDeleteUser(user.Username)
 		if err != nil {
-			fmt.Printf("Error deleting user: %v", err)
+			fmt.Printf("Error deleting user: %v\n", err)
 		}
 	}
+
+	_, err = common.Client.VerificationToken.FindMany(
+		db.VerificationToken.ExpiresAt.Before(now),
+	).Delete().Exec(common.BaseCtx)
+	return err
+}
+
+// Delete expired unverified accounts right away, and then again every interval
+func SweepUnverifiedUsers(interval time.Duration) {
+	for {
+		err := DeleteExpiredUnverifiedUsers()
+		if err != nil {
+			fmt.Printf("Error sweeping unverified users: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
 }
 
 // TODO: Make the thing actually send the emoji
@@ -41,38 +113,103 @@ func VerifyHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	token := vars["token"]
 
-	if username, found := common.AccountCreatedButNotVerified[token]; found {
-		delete(common.AccountCreatedButNotVerified, token)
+	verificationToken, err := common.Client.VerificationToken.FindUnique(
+		db.VerificationToken.TokenHash.Equals(util.HashToken(token)),
+	).Exec(common.BaseCtx)
+	if err != nil && err != db.ErrNotFound {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		res := apierr.NewInternal(err).Error()
+		w.Write([]byte(res))
+		return
+	}
 
-		_, err := common.Client.User.FindUnique(
-			db.User.Username.Equals(username),
-		).Update(
-			db.User.Verified.Set(true),
-		).Exec(common.BaseCtx)
-		if err == db.ErrNotFound {
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			w.WriteHeader(http.StatusNotFound)
-			res := fmt.Sprintf("user not found: %v", err)
-			w.Write([]byte(res))
-		}
-		if err != nil {
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			w.WriteHeader(http.StatusInternalServerError)
-			res := fmt.Sprintf("internal server error: %v", err)
-			w.Write([]byte(res))
-		}
+	if err == db.ErrNotFound || time.Now().After(verificationToken.ExpiresAt) {
+		unknownHTML := "Unrecognized or expired verification link\nIs your account already verified? 🤔"
 
-		verifiedHTML := "Account verified!\nYou may close this tab and sign in now."
 		// Set the response headers
-		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Write([]byte(verifiedHTML))
-	} else {
-		unknownHTML := "Unrecognized verification link\nIs your account already verified? 🤔"
-
-		// Set the response headers
 		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Write([]byte(unknownHTML))
+		return
+	}
+
+	_, err = common.Client.User.FindUnique(
+		db.User.Username.Equals(verificationToken.UserID),
+	).Update(
+		db.User.Verified.Set(true),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		res := "user not found"
+		w.Write([]byte(res))
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		res := apierr.NewInternal(err).Error()
+		w.Write([]byte(res))
+		return
+	}
+
+	// The account is verified, so none of its links are needed anymore
+	_, err = common.Client.VerificationToken.FindMany(
+		db.VerificationToken.UserID.Equals(verificationToken.UserID),
+	).Delete().Exec(common.BaseCtx)
+	if err != nil {
+		fmt.Printf("Error deleting verification tokens: %v\n", err)
+	}
+
+	verifiedHTML := "Account verified!\nYou may close this tab and sign in now."
+	// Set the response headers
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(verifiedHTML))
+}
+
+// Handles requests for a new verification link
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var resendData resendVerificationType
+	if !decodeJSONBody(w, r, &resendData) {
+		return
+	}
+
+	err := common.ValidateVar("email", resendData.Email, "required,email,lte=100")
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid email address")
+		return
 	}
+
+	// Registered and unregistered emails are limited alike, so the limit doesn't give away which is which
+	if rejectIfRequestedTooOften(w, map[string]throttlePolicy{
+		"verify_email:" + strings.ToLower(resendData.Email): verificationEmailThrottle,
+		"verify_ip:" + clientIP(r):                          verificationIPThrottle,
+	}) {
+		return
+	}
+
+	// Always send the same response so that the endpoint can't be used to find out which emails are registered
+	msg := "If an unverified account with that email exists, a new verification link has been sent to it"
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Email.Equals(resendData.Email),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound || (err == nil && user.Verified) {
+		sendMessage(w, msg)
+		return
+	}
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	err = SendVerificationLink(user.Username, user.Email)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, "error sending email, please try again later")
+		return
+	}
+
+	sendMessage(w, msg)
 }
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+)
+
+// The user making a request, found from its access token once per request.
+// Viewers of requests without a token aren't logged in, and have an empty username.
+type Viewer struct {
+	Username  string
+	SessionID string
+	Claims    jwt.MapClaims
+	// Why the token was rejected, if it was
+	Err error
+
+	// What the viewer was let in with, kept by NewConnectionViewer so Revalidate can check it again
+	token        string
+	tokenVersion int
+}
+
+type viewerKey struct{}
+
+// Verify an access token, and return who it belongs to
+func NewViewer(tokenString string) *Viewer {
+	claims, isAuth, err := VerifyAccessToken(tokenString)
+	if err != nil {
+		return &Viewer{Err: err}
+	}
+	if !isAuth {
+		return &Viewer{}
+	}
+
+	// Personal access tokens don't belong to a session
+	sessionID, _ := claims["session_id"].(string)
+	return &Viewer{
+		Username:  claims["username"].(string),
+		SessionID: sessionID,
+		Claims:    claims,
+	}
+}
+
+// Verify an access token for a connection that outlives it, like a WebSocket carrying subscriptions.
+// The viewer has to be checked again with Revalidate for as long as the connection is open.
+func NewConnectionViewer(tokenString string) *Viewer {
+	viewer := NewViewer(tokenString)
+	if !viewer.LoggedIn() {
+		return viewer
+	}
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(viewer.Username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return &Viewer{Err: apierr.NewUnauthenticated("user doesn't exist")}
+	}
+	if err != nil {
+		return &Viewer{Err: apierr.NewInternal(err)}
+	}
+	viewer.token = tokenString
+	viewer.tokenVersion = user.TokenVersion
+	return viewer
+}
+
+// Check that a viewer from NewConnectionViewer would still be let in. This fails once the token has expired,
+// its session was revoked, the user changed or reset their password, or the account can't log in anymore.
+func (v *Viewer) Revalidate() error {
+	if !v.LoggedIn() {
+		return nil
+	}
+
+	// This checks expiry, revoked personal access tokens and the account's status
+	current := NewViewer(v.token)
+	if current.Err != nil {
+		return current.Err
+	}
+	if current.Username != v.Username {
+		return apierr.NewUnauthenticated("authentication error: token is no longer valid")
+	}
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(v.Username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return apierr.NewUnauthenticated("user doesn't exist")
+	}
+	if err != nil {
+		return apierr.NewInternal(err)
+	}
+	if user.TokenVersion != v.tokenVersion {
+		return apierr.NewUnauthenticated("authentication error: password was changed, please log in again")
+	}
+
+	// Personal access tokens don't belong to a session
+	if v.SessionID != "" {
+		active, err := sessionActive(v.SessionID, v.Username)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return apierr.NewUnauthenticated("authentication error: session was revoked")
+		}
+	}
+	return nil
+}
+
+// Check if the viewer is logged in
+func (v *Viewer) LoggedIn() bool {
+	return v.Err == nil && v.Username != ""
+}
+
+// Return a copy of ctx that carries a viewer
+func WithViewer(ctx context.Context, viewer *Viewer) context.Context {
+	return context.WithValue(ctx, viewerKey{}, viewer)
+}
+
+// Get the viewer ctx carries, or a viewer that isn't logged in if it carries none
+func ViewerFrom(ctx context.Context) *Viewer {
+	if ctx != nil {
+		if viewer, ok := ctx.Value(viewerKey{}).(*Viewer); ok {
+			return viewer
+		}
+	}
+	return &Viewer{}
+}
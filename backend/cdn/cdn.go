@@ -6,7 +6,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"image"
 	"image/png"
@@ -18,6 +17,7 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/auth"
 	"github.com/soumitradev/Dwitter/backend/common"
 	"github.com/soumitradev/Dwitter/backend/util"
@@ -30,8 +30,8 @@ import (
 	"google.golang.org/api/option"
 )
 
-func init() {
-	// Connect to bucket
+// Connect to the bucket. main does this on startup rather than on import, so packages that use cdn can be tested without credentials.
+func InitBucket() {
 	opt := option.WithCredentialsFile("backend/cdn_key.json")
 	config := &firebase.Config{
 		StorageBucket: "dwitter-72e9d.appspot.com",
@@ -82,7 +82,7 @@ func LinkToLocation(link string) (string, error) {
 
 		return finalLink, nil
 	} else {
-		return "", errors.New("media link invalid")
+		return "", apierr.NewValidation("media", "media link invalid")
 	}
 }
 
@@ -105,7 +105,7 @@ func DeleteLocation(location string, deleteThumb bool) error {
 		o := common.Bucket.Object(thumbLoc)
 		if err := o.Delete(common.BaseCtx); err != nil {
 			if err.Error() == "storage: object doesn't exist" {
-				return errors.New("thumbnail for media not found")
+				return apierr.NewNotFound("thumbnail for media not found")
 			}
 			return fmt.Errorf("Object(%q).Delete: %v", location, err)
 		}
@@ -115,7 +115,7 @@ func DeleteLocation(location string, deleteThumb bool) error {
 	o := common.Bucket.Object(location)
 	if err := o.Delete(common.BaseCtx); err != nil {
 		if err.Error() == "storage: object doesn't exist" {
-			return errors.New("media not found")
+			return apierr.NewNotFound("media not found")
 		}
 		return fmt.Errorf("Object(%q).Delete: %v", location, err)
 	}
@@ -126,7 +126,7 @@ func DeleteLocation(location string, deleteThumb bool) error {
 func UploadMediaHandler(w http.ResponseWriter, r *http.Request) {
 	// Check authentication
 	authHeader := r.Header.Get("authorization")
-	_, err := auth.Authenticate(authHeader)
+	_, err := auth.Authenticate(authHeader, auth.ScopeWriteDweets)
 
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
@@ -387,7 +387,7 @@ func UploadMediaHandler(w http.ResponseWriter, r *http.Request) {
 func UploadPFPHandler(w http.ResponseWriter, r *http.Request) {
 	// Check authentication
 	authHeader := r.Header.Get("authorization")
-	username, err := auth.Authenticate(authHeader)
+	username, err := auth.Authenticate(authHeader, auth.ScopeWriteProfile)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
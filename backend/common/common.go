@@ -6,16 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"time"
 
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/passwords"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
 
 	"cloud.google.com/go/storage"
 	"github.com/functionalfoundry/graphqlws"
 	"github.com/go-playground/validator/v10"
-	"golang.org/x/crypto/bcrypt"
-
-	"github.com/sendgrid/sendgrid-go"
 )
 
 const DefaultPFPURL = "https://storage.googleapis.com/download/storage/v1/b/dwitter-72e9d.appspot.com/o/pfp%2Fdefault.jpg?alt=media"
@@ -24,24 +23,47 @@ var Client *db.PrismaClient
 var BaseCtx context.Context
 var Bucket *storage.BucketHandle
 var MediaCreatedButNotUsed map[string]bool
-var AccountCreatedButNotVerified map[string]string
 var SubscriptionManager graphqlws.SubscriptionManager
 var GraphqlwsHandler http.Handler
 var Validate *validator.Validate
-var SendgridClient *sendgrid.Client
+
+// Returned by CheckCreds when the username or password is wrong
+var ErrInvalidCreds = apierr.NewUnauthenticated("username/password error")
+
+// Returned when a deactivated user tries to log in
+var ErrAccountDeactivated = apierr.NewForbidden("account deactivated: log in at /api/reactivate to reactivate it")
+
+// States an account can be in
+const (
+	AccountActive      = "active"
+	AccountSuspended   = "suspended"
+	AccountDeactivated = "deactivated"
+)
+
+// Validate an argument, and return a validation error naming field if it is invalid
+func ValidateVar(field string, value interface{}, tag string) error {
+	err := Validate.Var(value, tag)
+	if err == nil {
+		return nil
+	}
+	var fieldErrs validator.ValidationErrors
+	if errors.As(err, &fieldErrs) && len(fieldErrs) > 0 {
+		return apierr.NewValidation(field, "invalid %s: failed the %s check", field, fieldErrs[0].Tag())
+	}
+	return apierr.NewValidation(field, "invalid %s: %v", field, err)
+}
 
 type HTTPError struct {
 	Error string `json:"error"`
 }
 
+type HTTPMessage struct {
+	Message string `json:"message"`
+}
+
 func init() {
 	BaseCtx = context.Background()
 	MediaCreatedButNotUsed = make(map[string]bool)
-	AccountCreatedButNotVerified = make(map[string]string)
-}
-
-func InitSendgrid() {
-	SendgridClient = sendgrid.NewSendClient(os.Getenv("SENDGRID_API_KEY"))
 }
 
 // Check given credentials and return true if valid
@@ -50,28 +72,79 @@ func CheckCreds(username string, password string) (bool, error) {
 		db.User.Username.Equals(username),
 	).Exec(BaseCtx)
 	if err != nil {
-		return false, errors.New("username/password error")
+		return false, ErrInvalidCreds
 	}
 
 	if !user.Verified {
-		return false, errors.New("account not verified: please check your email for a verification link")
+		return false, apierr.NewForbidden("account not verified: please check your email for a verification link")
+	}
+
+	matches, needsRehash, err := passwords.Verify(password, user.PasswordHash)
+	if err != nil || !matches {
+		return false, ErrInvalidCreds
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	// Only say the account is suspended or deactivated to someone who knows the password
+	err = CheckAccountStatus(user)
 	if err != nil {
-		return false, errors.New("username/password error")
+		return false, err
+	}
+
+	// Old bcrypt hashes can only be upgraded while the password is known, which is now
+	if needsRehash {
+		passwordHash, err := passwords.Hash(password)
+		if err == nil {
+			_, err = Client.User.FindUnique(
+				db.User.Username.Equals(username),
+			).Update(
+				db.User.PasswordHash.Set(passwordHash),
+			).Exec(BaseCtx)
+		}
+		if err != nil {
+			fmt.Printf("Error upgrading password hash of %s: %v\n", username, err)
+		}
 	}
 	return true, nil
 }
 
+// Return an error if an account is suspended or deactivated.
+// Suspensions that have run out are lifted here, so a suspended user can log in again as soon as it ends.
+func CheckAccountStatus(user *db.UserModel) error {
+	switch user.Status {
+	case AccountSuspended:
+		suspendedUntil, _ := user.SuspendedUntil()
+		if time.Now().Before(suspendedUntil) {
+			reason, _ := user.SuspensionReason()
+			return apierr.NewForbidden("account suspended until %s: %s", suspendedUntil.UTC().Format(time.RFC1123), reason)
+		}
+		_, err := Client.User.FindUnique(
+			db.User.Username.Equals(user.Username),
+		).Update(
+			db.User.Status.Set(AccountActive),
+			db.User.SuspensionReason.SetOptional(nil),
+			db.User.SuspendedUntil.SetOptional(nil),
+		).Exec(BaseCtx)
+		if err != nil {
+			return apierr.NewInternal(err)
+		}
+		user.Status = AccountActive
+	case AccountDeactivated:
+		return ErrAccountDeactivated
+	}
+	return nil
+}
+
 // Delete a Dweet
 func InternalDeleteDweet(postID string) (*db.DweetModel, error) {
 	// Get all the replies to the post (these need to be deleted first since they depend on the root Dweet)
 	post, err := Client.Dweet.FindUnique(
 		db.Dweet.ID.Equals(postID),
 	).Exec(BaseCtx)
+	if err == db.ErrNotFound {
+		return nil, apierr.NewNotFound("dweet not found")
+	}
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	// If the Dweet itself is a reply, remove the reply from the original post
@@ -79,7 +152,7 @@ func InternalDeleteDweet(postID string) (*db.DweetModel, error) {
 		// Find the dweet that was replied to
 		id, exist := post.OriginalReplyID()
 		if !exist {
-			return nil, errors.New("original Dweet not found")
+			return nil, apierr.NewNotFound("original Dweet not found")
 		}
 
 		// Remove the Reply from the post
@@ -92,7 +165,7 @@ func InternalDeleteDweet(postID string) (*db.DweetModel, error) {
 			),
 		).Exec(BaseCtx)
 		if err != nil {
-			return nil, err
+			return nil, apierr.NewInternal(err)
 		}
 	}
 
@@ -106,7 +179,7 @@ func InternalDeleteDweet(postID string) (*db.DweetModel, error) {
 		),
 	).Exec(BaseCtx)
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	for _, redweet := range dweet.RedweetDweets() {
@@ -121,7 +194,7 @@ func InternalDeleteDweet(postID string) (*db.DweetModel, error) {
 		),
 	).Exec(BaseCtx)
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 	for _, daughterDweet := range dweet.ReplyDweets() {
 		InternalDeleteDweet(daughterDweet.ID)
@@ -131,7 +204,7 @@ func InternalDeleteDweet(postID string) (*db.DweetModel, error) {
 		db.Dweet.ID.Equals(postID),
 	).Delete().Exec(BaseCtx)
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	// The following comment block is kept as a homage to the great recursive SQL function that once resided here.
@@ -153,8 +226,11 @@ func InternalDeleteUser(username string) (*db.UserModel, error) {
 	basicUser, err := Client.User.FindUnique(
 		db.User.Username.Equals(username),
 	).Exec(BaseCtx)
+	if err == db.ErrNotFound {
+		return nil, apierr.NewNotFound("user not found")
+	}
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	user, err := Client.User.FindUnique(
@@ -168,7 +244,7 @@ func InternalDeleteUser(username string) (*db.UserModel, error) {
 		db.User.Following.Fetch(),
 	).Exec(BaseCtx)
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	// Delete all dependent objects, and adjust all relations
@@ -206,7 +282,7 @@ func InternalDeleteUser(username string) (*db.UserModel, error) {
 		db.User.Username.Equals(username),
 	).Delete().Exec(BaseCtx)
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	return basicUser, err
@@ -227,13 +303,16 @@ func InternalDeleteRedweet(postID string, username string) (*db.RedweetModel, er
 			),
 		),
 	).Exec(BaseCtx)
+	if err == db.ErrNotFound {
+		return nil, apierr.NewNotFound("user not found")
+	}
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	// If no such redweet exists, return
 	if len(user.Redweets()) == 0 {
-		return nil, db.ErrNotFound
+		return nil, apierr.NewNotFound("redweet not found")
 	}
 
 	// Remove the Redweet from the post
@@ -243,14 +322,14 @@ func InternalDeleteRedweet(postID string, username string) (*db.RedweetModel, er
 		db.Dweet.RedweetCount.Decrement(1),
 	).Exec(BaseCtx)
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	_, err = Client.Redweet.FindUnique(
 		db.Redweet.DbID.Equals(user.Redweets()[0].DbID),
 	).Delete().Exec(BaseCtx)
 	if err != nil {
-		return nil, err
+		return nil, apierr.NewInternal(err)
 	}
 
 	return &user.Redweets()[0], err
@@ -259,12 +338,12 @@ func InternalDeleteRedweet(postID string, username string) (*db.RedweetModel, er
 // Remove a like from a dweet
 func InternalUnlike(postID string, userID string) (*db.DweetModel, error) {
 	// Validate params
-	err := Validate.Var(postID, "required,alphanum,len=10")
+	err := ValidateVar("id", postID, "required,alphanum,len=10")
 	if err != nil {
 		return nil, err
 	}
 
-	err = Validate.Var(userID, "required,alphanum,lte=20,gt=0")
+	err = ValidateVar("username", userID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return nil, err
 	}
@@ -274,10 +353,10 @@ func InternalUnlike(postID string, userID string) (*db.DweetModel, error) {
 		db.Dweet.ID.Equals(postID),
 	).Exec(BaseCtx)
 	if err == db.ErrNotFound {
-		return nil, fmt.Errorf("dweet not found: %v", err)
+		return nil, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return nil, fmt.Errorf("internal server error: %v", err)
+		return nil, apierr.NewInternal(err)
 	}
 
 	// Check if user liked the dweet or not
@@ -289,10 +368,10 @@ func InternalUnlike(postID string, userID string) (*db.DweetModel, error) {
 		),
 	).Exec(BaseCtx)
 	if err == db.ErrNotFound {
-		return nil, fmt.Errorf("dweet not found: %v", err)
+		return nil, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return nil, fmt.Errorf("internal server error: %v", err)
+		return nil, apierr.NewInternal(err)
 	}
 
 	// If not, then skip unliking the dweet
@@ -314,10 +393,10 @@ func InternalUnlike(postID string, userID string) (*db.DweetModel, error) {
 		),
 	).Exec(BaseCtx)
 	if err == db.ErrNotFound {
-		return nil, fmt.Errorf("dweet not found: %v", err)
+		return nil, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return nil, fmt.Errorf("internal server error: %v", err)
+		return nil, apierr.NewInternal(err)
 	}
 
 	return basicPost, nil
@@ -326,12 +405,12 @@ func InternalUnlike(postID string, userID string) (*db.DweetModel, error) {
 // Delete a follower relation
 func InternalUnfollow(followedID string, followerID string) (*db.UserModel, error) {
 	// Validate params
-	err := Validate.Var(followedID, "required,alphanum,lte=20,gt=0")
+	err := ValidateVar("username", followedID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return nil, err
 	}
 
-	err = Validate.Var(followerID, "required,alphanum,lte=20,gt=0")
+	err = ValidateVar("username", followerID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return nil, err
 	}
@@ -341,10 +420,10 @@ func InternalUnfollow(followedID string, followerID string) (*db.UserModel, erro
 		db.User.Username.Equals(followedID),
 	).Exec(BaseCtx)
 	if err == db.ErrNotFound {
-		return nil, fmt.Errorf("user not found: %v", err)
+		return nil, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return nil, fmt.Errorf("internal server error: %v", err)
+		return nil, apierr.NewInternal(err)
 	}
 
 	// Check if user doesn't follow this user in the first place
@@ -356,10 +435,10 @@ func InternalUnfollow(followedID string, followerID string) (*db.UserModel, erro
 		),
 	).Exec(BaseCtx)
 	if err == db.ErrNotFound {
-		return nil, fmt.Errorf("user not found: %v", err)
+		return nil, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return nil, fmt.Errorf("internal server error: %v", err)
+		return nil, apierr.NewInternal(err)
 	}
 
 	// If yes, then skip unfollowing the user
@@ -380,7 +459,7 @@ func InternalUnfollow(followedID string, followerID string) (*db.UserModel, erro
 		),
 	).Exec(BaseCtx)
 	if err == db.ErrNotFound {
-		return nil, fmt.Errorf("user not found: %v", err)
+		return nil, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
 		return nil, err
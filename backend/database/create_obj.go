@@ -1,50 +1,49 @@
 package database
 
 import (
-	"errors"
-	"fmt"
 	"math/rand"
 	"time"
 
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/auth"
 	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/passwords"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
 	"github.com/soumitradev/Dwitter/backend/schema"
 	"github.com/soumitradev/Dwitter/backend/util"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Create a User
 func SignUpUser(username string, password string, name string, bio string, email string) (schema.UserType, error) {
 	// Validate params
-	err := common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(password, "required,lte=128,gte=8,containsany=ABCDEFGHIJKLMNOPQRSTUVWXYZ,containsany=abcdefghijklmnopqrstuvwxyz,containsany=1234567890,containsany=!@#$%^&*`~-_=+/?.")
+	err = passwords.Validate(password)
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("password must be minimum eight characters, maximum 128 characters, have at least one uppercase letter, one lowercase letter, one number and one special character : %v", err)
+		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(name, "required,lte=80")
+	err = common.ValidateVar("name", name, "required,lte=80")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(bio, "lte=160")
+	err = common.ValidateVar("bio", bio, "lte=160")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(email, "required,email,lte=100")
+	err = common.ValidateVar("email", email, "required,email,lte=100")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	passwordHash, err := passwords.Hash(password)
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.UserType{}, apierr.NewInternal(err)
 	}
 
 	// Check if user with username or email already exists
@@ -55,19 +54,6 @@ func SignUpUser(username string, password string, name string, bio string, email
 		db.User.Email.Equals(email),
 	).Exec(common.BaseCtx)
 	if (err1 == db.ErrNotFound) && (err2 == db.ErrNotFound) {
-		// Send verification email
-		token := util.GenToken(5)
-
-		common.AccountCreatedButNotVerified[token] = username
-		link := "http://localhost:5000/api/verify/" + token
-		_, err := auth.SendVerificationEmail(email, link)
-
-		go auth.DeleteUserAfterExpire(60, token)
-
-		if err != nil {
-			return schema.UserType{}, errors.New("error verifying email, please try again later")
-		}
-
 		// Create user if no such user exists
 		createdUser, err := common.Client.User.CreateOne(
 			db.User.Username.Set(username),
@@ -78,7 +64,6 @@ func SignUpUser(username string, password string, name string, bio string, email
 			db.User.ProfilePicURL.Set(common.DefaultPFPURL),
 			db.User.TokenVersion.Set(rand.Intn(10000)),
 			db.User.CreatedAt.Set(time.Now()),
-			db.User.OAuthProvider.Set("None"),
 			db.User.Verified.Set(false),
 		).With(
 			db.User.Dweets.Fetch().With(
@@ -89,33 +74,58 @@ func SignUpUser(username string, password string, name string, bio string, email
 		).Exec(common.BaseCtx)
 
 		if err != nil {
-			return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.UserType{}, apierr.NewInternal(err)
+		}
+
+		// Send verification email
+		err = auth.SendVerificationLink(username, email)
+		if err != nil {
+			// Don't keep an account around that can never be verified
+			common.InternalDeleteUser(username)
+			return schema.UserType{}, apierr.NewInternal(err)
 		}
 
 		nuser, err := schema.FormatAsUserType(createdUser, []db.UserModel{}, []db.UserModel{}, "", []interface{}{}, true)
 		return nuser, err
 	} else {
-		return schema.UserType{}, errors.New("username/email already taken")
+		return schema.UserType{}, apierr.NewConflict("username/email already taken")
+	}
+}
+
+// Check if a post by a user should be marked as automated
+func isAutomated(username string, automated bool) (bool, error) {
+	if automated {
+		return true, nil
 	}
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	return user.IsBot, nil
 }
 
-// Create a Post
-func NewDweet(body, username string, mediaLinks []string) (schema.DweetType, error) {
+// Create a Post. Posts made through a personal access token, or by a bot, are marked as automated.
+func NewDweet(body, username string, mediaLinks []string, automated bool) (schema.DweetType, error) {
 	// Validate params
-	err := common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(mediaLinks, "lte=8,dive,required,url")
+	err = common.ValidateVar("media", mediaLinks, "lte=8,dive,required,url")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(body, "required,lte=240,gt=0")
+	err = common.ValidateVar("body", body, "required,lte=240,gt=0")
 	if err != nil {
 		if body == "" {
-			err = common.Validate.Var(mediaLinks, "required,gte=1,lte=8,dive,required,url,gt=1")
+			err = common.ValidateVar("media", mediaLinks, "required,gte=1,lte=8,dive,required,url,gt=1")
 			if err != nil {
 				return schema.DweetType{}, err
 			}
@@ -138,6 +148,11 @@ func NewDweet(body, username string, mediaLinks []string) (schema.DweetType, err
 		).Exec(common.BaseCtx)
 	}
 
+	automated, err = isAutomated(username, automated)
+	if err != nil {
+		return schema.DweetType{}, err
+	}
+
 	now := time.Now()
 	createdPost, err := common.Client.Dweet.CreateOne(
 		db.Dweet.DweetBody.Set(body),
@@ -146,6 +161,7 @@ func NewDweet(body, username string, mediaLinks []string) (schema.DweetType, err
 		db.Dweet.Media.Set(mediaLinks),
 		db.Dweet.PostedAt.Set(now),
 		db.Dweet.LastUpdatedAt.Set(now),
+		db.Dweet.Automated.Set(automated),
 	).With(
 		db.Dweet.Author.Fetch(),
 		db.Dweet.ReplyTo.Fetch().With(
@@ -158,7 +174,7 @@ func NewDweet(body, username string, mediaLinks []string) (schema.DweetType, err
 		),
 	).Exec(common.BaseCtx)
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	// Mark media as used to prevent deletion on expiry
@@ -172,28 +188,28 @@ func NewDweet(body, username string, mediaLinks []string) (schema.DweetType, err
 	return post, err
 }
 
-// Create a Reply
-func NewReply(originalPostID string, body string, authorUsername string, mediaLinks []string) (schema.DweetType, error) {
+// Create a Reply. Replies made through a personal access token, or by a bot, are marked as automated.
+func NewReply(originalPostID string, body string, authorUsername string, mediaLinks []string, automated bool) (schema.DweetType, error) {
 	// Validate params
-	err := common.Validate.Var(originalPostID, "required,alphanum,len=10")
+	err := common.ValidateVar("id", originalPostID, "required,alphanum,len=10")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(authorUsername, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", authorUsername, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(mediaLinks, "lte=8,dive,required,url")
+	err = common.ValidateVar("media", mediaLinks, "lte=8,dive,required,url")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(body, "required,lte=240,gt=0")
+	err = common.ValidateVar("body", body, "required,lte=240,gt=0")
 	if err != nil {
 		if body == "" {
-			err = common.Validate.Var(mediaLinks, "required,gte=1,lte=8,dive,required,url,gt=1")
+			err = common.ValidateVar("media", mediaLinks, "required,gte=1,lte=8,dive,required,url,gt=1")
 			if err != nil {
 				return schema.DweetType{}, err
 			}
@@ -216,6 +232,11 @@ func NewReply(originalPostID string, body string, authorUsername string, mediaLi
 		).Exec(common.BaseCtx)
 	}
 
+	automated, err = isAutomated(authorUsername, automated)
+	if err != nil {
+		return schema.DweetType{}, err
+	}
+
 	now := time.Now()
 	// Create a Reply
 	createdReply, err := common.Client.Dweet.CreateOne(
@@ -229,6 +250,7 @@ func NewReply(originalPostID string, body string, authorUsername string, mediaLi
 		),
 		db.Dweet.PostedAt.Set(now),
 		db.Dweet.LastUpdatedAt.Set(now),
+		db.Dweet.Automated.Set(automated),
 	).With(
 		db.Dweet.Author.Fetch(),
 		db.Dweet.ReplyTo.Fetch().With(
@@ -241,7 +263,7 @@ func NewReply(originalPostID string, body string, authorUsername string, mediaLi
 		),
 	).Exec(common.BaseCtx)
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 	for _, link := range mediaLinks {
 		delete(common.MediaCreatedButNotUsed, link)
@@ -257,10 +279,10 @@ func NewReply(originalPostID string, body string, authorUsername string, mediaLi
 		db.Dweet.ReplyCount.Increment(1),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("original dweet not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("original dweet not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	post := schema.FormatAsDweetType(createdReply, []db.UserModel{}, []db.UserModel{})
@@ -270,12 +292,12 @@ func NewReply(originalPostID string, body string, authorUsername string, mediaLi
 // Create a new Redweet of a Dweet
 func Redweet(originalPostID, username string) (schema.RedweetType, error) {
 	// Validate params
-	err := common.Validate.Var(originalPostID, "required,alphanum,len=10")
+	err := common.ValidateVar("id", originalPostID, "required,alphanum,len=10")
 	if err != nil {
 		return schema.RedweetType{}, err
 	}
 
-	err = common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.RedweetType{}, err
 	}
@@ -289,10 +311,10 @@ func Redweet(originalPostID, username string) (schema.RedweetType, error) {
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.RedweetType{}, fmt.Errorf("original dweet not found: %v", err)
+		return schema.RedweetType{}, apierr.NewNotFound("original dweet not found")
 	}
 	if err != nil {
-		return schema.RedweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.RedweetType{}, apierr.NewInternal(err)
 	}
 
 	// If already redweeted, return redweet
@@ -324,7 +346,7 @@ func Redweet(originalPostID, username string) (schema.RedweetType, error) {
 		),
 	).Exec(common.BaseCtx)
 	if err != nil {
-		return schema.RedweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.RedweetType{}, apierr.NewInternal(err)
 	}
 
 	// Update original Dweet to show redweet
@@ -337,10 +359,10 @@ func Redweet(originalPostID, username string) (schema.RedweetType, error) {
 		db.Dweet.RedweetCount.Increment(1),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.RedweetType{}, fmt.Errorf("original dweet not found: %v", err)
+		return schema.RedweetType{}, apierr.NewNotFound("original dweet not found")
 	}
 	if err != nil {
-		return schema.RedweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.RedweetType{}, apierr.NewInternal(err)
 	}
 
 	return schema.FormatAsRedweetType(createdRedweet), err
@@ -6,11 +6,7 @@ import (
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
 )
 
-func init() {
-	ConnectDB()
-}
-
-// Connect to the database using prisma
+// Connect to the database using prisma. main does this on startup rather than on import, so the package can be tested without a database.
 func ConnectDB() {
 	common.Client = db.NewClient()
 	if err := common.Client.Prisma.Connect(); err != nil {
@@ -24,3 +20,17 @@ func DisconnectDB() {
 		panic(err)
 	}
 }
+
+// Filter for users who can be seen, which leaves out suspended, deactivated and soon to be deleted accounts
+func visibleUser() db.UserWhereParam {
+	return db.User.And(
+		db.User.DeleteAfter.IsNull(),
+		db.User.Status.Equals(common.AccountActive),
+	)
+}
+
+// Check if a fetched user can be seen, the same way visibleUser does
+func userVisible(user *db.UserModel) bool {
+	_, pendingDeletion := user.DeleteAfter()
+	return !pendingDeletion && user.Status == common.AccountActive
+}
@@ -1,9 +1,11 @@
 package database
 
 import (
-	"errors"
 	"fmt"
+	"time"
 
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/auth"
 	"github.com/soumitradev/Dwitter/backend/cdn"
 	"github.com/soumitradev/Dwitter/backend/common"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
@@ -11,20 +13,20 @@ import (
 	"github.com/soumitradev/Dwitter/backend/util"
 )
 
-// Delete a dweet
-func DeleteDweet(postID string, username string, repliesToFetch int, replyOffset int) (schema.DweetType, error) {
+// Delete a dweet. Moderators can delete dweets by anyone.
+func DeleteDweet(postID string, username string, isModerator bool, repliesToFetch int, replyOffset int) (schema.DweetType, error) {
 	// Validate params
-	err := common.Validate.Var(postID, "required,alphanum,len=10")
+	err := common.ValidateVar("id", postID, "required,alphanum,len=10")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(replyOffset, "gte=0")
+	err = common.ValidateVar("repliesOffset", replyOffset, "gte=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
@@ -82,14 +84,14 @@ func DeleteDweet(postID string, username string, repliesToFetch int, replyOffset
 		).Exec(common.BaseCtx)
 	}
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	// Check if authorized to delete dweet
-	if deleted.Author().Username == username {
+	if deleted.Author().Username == username || isModerator {
 		_, err := common.InternalDeleteDweet(postID)
 
 		// Delete the media that isn't used anymore
@@ -97,16 +99,16 @@ func DeleteDweet(postID string, username string, repliesToFetch int, replyOffset
 		for _, mediaLink := range oldMedia {
 			loc, err := cdn.LinkToLocation(mediaLink)
 			if err != nil {
-				return schema.DweetType{}, err
+				return schema.DweetType{}, apierr.NewInternal(err)
 			}
 			err = cdn.DeleteLocation(loc, true)
 			if err != nil {
-				return schema.DweetType{}, err
+				return schema.DweetType{}, apierr.NewInternal(err)
 			}
 		}
 
 		if err != nil {
-			return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.DweetType{}, apierr.NewInternal(err)
 		}
 
 		// Format and return with common likes
@@ -120,23 +122,23 @@ func DeleteDweet(postID string, username string, repliesToFetch int, replyOffset
 		return formatted, err
 	}
 
-	return schema.DweetType{}, fmt.Errorf("internal server error: %v", errors.New("Unauthorized"))
+	return schema.DweetType{}, apierr.NewForbidden("not authorized to delete dweet")
 }
 
 // Delete User
 func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int, feedObjectsOffset int) (schema.UserType, error) {
 	// Validate params
-	err := common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(objectsToFetch, "required,alpha,gt=0,oneof=feed dweet redweet redweetedDweet liked")
+	err = common.ValidateVar("objectsToFetch", objectsToFetch, "required,alpha,gt=0,oneof=feed dweet redweet redweetedDweet liked")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(feedObjectsOffset, "gte=0")
+	err = common.ValidateVar("feedObjectsOffset", feedObjectsOffset, "gte=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
@@ -173,10 +175,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -199,10 +201,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			dweets := user.Dweets()
@@ -229,10 +231,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			redweets := user.Redweets()
@@ -256,10 +258,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			redweetedDweets := user.RedweetedDweets()
@@ -283,10 +285,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			likes := user.LikedDweets()
@@ -323,10 +325,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -351,10 +353,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			dweets := user.Dweets()
@@ -381,10 +383,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			redweets := user.Redweets()
@@ -408,10 +410,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			redweetedDweets := user.RedweetedDweets()
@@ -435,10 +437,10 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+				return schema.UserType{}, apierr.NewNotFound("user not found")
 			}
 			if err != nil {
-				return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.UserType{}, apierr.NewInternal(err)
 			}
 
 			likes := user.LikedDweets()
@@ -458,13 +460,13 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 	// Send back the user requested, along with mutuals in the followers field
 	nuser, err := schema.FormatAsUserType(user, alsoFollowedBy, alsoFollowing, objectsToFetch, feedObjectList, showEmail)
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.UserType{}, apierr.NewInternal(err)
 	}
 
 	// Delete the user
 	_, err = common.InternalDeleteUser(username)
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("error deleting user: %v", err)
+		return schema.UserType{}, err
 	}
 	return nuser, err
 }
@@ -472,26 +474,114 @@ func DeleteUser(username string, objectsToFetch string, feedObjectsToFetch int,
 // Delete a redweet
 func DeleteRedweet(postID string, username string) (schema.RedweetType, error) {
 	// Validate params
-	err := common.Validate.Var(postID, "required,alphanum,len=10")
+	err := common.ValidateVar("id", postID, "required,alphanum,len=10")
 	if err != nil {
 		return schema.RedweetType{}, err
 	}
 
-	err = common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.RedweetType{}, err
 	}
 
 	redweet, err := common.InternalDeleteRedweet(postID, username)
-	if err == db.ErrNotFound {
-		return schema.RedweetType{}, fmt.Errorf("redweet not found: %v", err)
-	}
 	if err != nil {
-		return schema.RedweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.RedweetType{}, err
 	}
 
 	formatted := schema.FormatAsRedweetType(redweet)
 	return formatted, err
 }
 
-// TODO: DeleteUser
+// Permanently delete an account along with its media, like when its deletion grace period is over
+func PurgeAccount(username string) error {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).With(
+		db.User.Dweets.Fetch(),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return err
+	}
+
+	_, err = common.InternalDeleteUser(username)
+	if err != nil {
+		return err
+	}
+
+	// Delete the media only once nothing points at it anymore. Links that aren't ours, like avatars from OAuth providers, are skipped.
+	for _, dweet := range user.Dweets() {
+		for _, mediaLink := range dweet.Media {
+			loc, err := cdn.LinkToLocation(mediaLink)
+			if err != nil {
+				continue
+			}
+			err = cdn.DeleteLocation(loc, true)
+			if err != nil {
+				fmt.Printf("Error deleting media: %v\n", err)
+			}
+		}
+	}
+	if user.ProfilePicURL != common.DefaultPFPURL {
+		loc, err := cdn.LinkToLocation(user.ProfilePicURL)
+		if err == nil {
+			err = cdn.DeleteLocation(loc, false)
+			if err != nil {
+				fmt.Printf("Error deleting profile picture: %v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Permanently delete a user right away, for admins removing abusive accounts.
+// Admins can't be deleted, so they have to be demoted first.
+func AdminDeleteUser(username string) (bool, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	if user.Role == auth.RoleAdmin {
+		return false, apierr.NewForbidden("admins can't be deleted, change their role first")
+	}
+
+	err = PurgeAccount(username)
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	return true, nil
+}
+
+// Permanently delete all accounts whose deletion grace period is over
+func PurgeDeletedAccounts() error {
+	users, err := common.Client.User.FindMany(
+		db.User.DeleteAfter.Before(time.Now()),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		err := PurgeAccount(user.Username)
+		if err != nil {
+			fmt.Printf("Error purging user: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// Purge deleted accounts right away, and then again every interval
+func SweepDeletedAccounts(interval time.Duration) {
+	for {
+		err := PurgeDeletedAccounts()
+		if err != nil {
+			fmt.Printf("Error sweeping deleted accounts: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
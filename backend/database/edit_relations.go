@@ -1,8 +1,7 @@
 package database
 
 import (
-	"fmt"
-
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/common"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
 	"github.com/soumitradev/Dwitter/backend/schema"
@@ -12,22 +11,22 @@ import (
 // Create a follower relation
 func Follow(followedID string, followerID string, objectsToFetch string, feedObjectsToFetch int, feedObjectsOffset int) (schema.UserType, error) {
 	// Validate params
-	err := common.Validate.Var(followedID, "required,alphanum,lte=20,gt=0")
+	err := common.ValidateVar("username", followedID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(followerID, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", followerID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(objectsToFetch, "required,alpha,gt=0,oneof=feed dweet redweet redweetedDweet")
+	err = common.ValidateVar("objectsToFetch", objectsToFetch, "required,alpha,gt=0,oneof=feed dweet redweet redweetedDweet")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(feedObjectsOffset, "gte=0")
+	err = common.ValidateVar("feedObjectsOffset", feedObjectsOffset, "gte=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
@@ -41,10 +40,10 @@ func Follow(followedID string, followerID string, objectsToFetch string, feedObj
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+		return schema.UserType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.UserType{}, apierr.NewInternal(err)
 	}
 
 	var user *db.UserModel
@@ -248,10 +247,10 @@ func Follow(followedID string, followerID string, objectsToFetch string, feedObj
 		}
 
 		if err == db.ErrNotFound {
-			return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+			return schema.UserType{}, apierr.NewNotFound("user not found")
 		}
 		if err != nil {
-			return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.UserType{}, apierr.NewInternal(err)
 		}
 
 		authenticatedUser, err := common.Client.User.FindUnique(
@@ -262,10 +261,10 @@ func Follow(followedID string, followerID string, objectsToFetch string, feedObj
 			),
 		).Exec(common.BaseCtx)
 		if err == db.ErrNotFound {
-			return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+			return schema.UserType{}, apierr.NewNotFound("user not found")
 		}
 		if err != nil {
-			return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.UserType{}, apierr.NewInternal(err)
 		}
 
 		knownUsers := authenticatedUser.Following()
@@ -526,10 +525,10 @@ func Follow(followedID string, followerID string, objectsToFetch string, feedObj
 		}
 	}
 	if err == db.ErrNotFound {
-		return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+		return schema.UserType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.UserType{}, apierr.NewInternal(err)
 	}
 
 	// Add followed to follower's following list
@@ -546,10 +545,10 @@ func Follow(followedID string, followerID string, objectsToFetch string, feedObj
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+		return schema.UserType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.UserType{}, apierr.NewInternal(err)
 	}
 
 	knownUsers := authenticatedUser.Following()
@@ -582,17 +581,17 @@ func Follow(followedID string, followerID string, objectsToFetch string, feedObj
 // Add a like to a dweet
 func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int) (schema.DweetType, error) {
 	// Validate params
-	err := common.Validate.Var(likedPostID, "required,alphanum,len=10")
+	err := common.ValidateVar("id", likedPostID, "required,alphanum,len=10")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(userID, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", userID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(replyOffset, "gte=0")
+	err = common.ValidateVar("repliesOffset", replyOffset, "gte=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
@@ -606,10 +605,10 @@ func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	// If yes, then skip liking the dweet
@@ -635,10 +634,10 @@ func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+				return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 			}
 			if err != nil {
-				return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.DweetType{}, apierr.NewInternal(err)
 			}
 		} else {
 			likedPost, err = common.Client.Dweet.FindUnique(
@@ -661,10 +660,10 @@ func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+				return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 			}
 			if err != nil {
-				return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.DweetType{}, apierr.NewInternal(err)
 			}
 		}
 
@@ -676,10 +675,10 @@ func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int
 			),
 		).Exec(common.BaseCtx)
 		if err == db.ErrNotFound {
-			return schema.DweetType{}, fmt.Errorf("user not found: %v", err)
+			return schema.DweetType{}, apierr.NewNotFound("user not found")
 		}
 		if err != nil {
-			return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.DweetType{}, apierr.NewInternal(err)
 		}
 
 		// Find known people that liked the dweet
@@ -722,10 +721,10 @@ func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int
 			),
 		).Exec(common.BaseCtx)
 		if err == db.ErrNotFound {
-			return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+			return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 		}
 		if err != nil {
-			return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.DweetType{}, apierr.NewInternal(err)
 		}
 	} else {
 		like, err = common.Client.Dweet.FindUnique(
@@ -753,10 +752,10 @@ func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int
 			),
 		).Exec(common.BaseCtx)
 		if err == db.ErrNotFound {
-			return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+			return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 		}
 		if err != nil {
-			return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.DweetType{}, apierr.NewInternal(err)
 		}
 	}
 
@@ -773,10 +772,10 @@ func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("user not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	// Find known people that liked thw dweet
@@ -800,17 +799,17 @@ func Like(likedPostID string, userID string, repliesToFetch int, replyOffset int
 // Remove a like from a dweet
 func Unlike(postID string, userID string, repliesToFetch int, replyOffset int) (schema.DweetType, error) {
 	// Validate params
-	err := common.Validate.Var(postID, "required,alphanum,len=10")
+	err := common.ValidateVar("id", postID, "required,alphanum,len=10")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(userID, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", userID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(replyOffset, "gte=0")
+	err = common.ValidateVar("repliesOffset", replyOffset, "gte=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
@@ -823,10 +822,10 @@ func Unlike(postID string, userID string, repliesToFetch int, replyOffset int) (
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	// If yes, then skip unliking the dweet
@@ -853,10 +852,10 @@ func Unlike(postID string, userID string, repliesToFetch int, replyOffset int) (
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+				return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 			}
 			if err != nil {
-				return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.DweetType{}, apierr.NewInternal(err)
 			}
 		} else {
 			post, err = common.Client.Dweet.FindUnique(
@@ -879,10 +878,10 @@ func Unlike(postID string, userID string, repliesToFetch int, replyOffset int) (
 				),
 			).Exec(common.BaseCtx)
 			if err == db.ErrNotFound {
-				return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+				return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 			}
 			if err != nil {
-				return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+				return schema.DweetType{}, apierr.NewInternal(err)
 			}
 		}
 
@@ -894,10 +893,10 @@ func Unlike(postID string, userID string, repliesToFetch int, replyOffset int) (
 			),
 		).Exec(common.BaseCtx)
 		if err == db.ErrNotFound {
-			return schema.DweetType{}, fmt.Errorf("user not found: %v", err)
+			return schema.DweetType{}, apierr.NewNotFound("user not found")
 		}
 		if err != nil {
-			return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.DweetType{}, apierr.NewInternal(err)
 		}
 
 		knownUsers := user.Following()
@@ -966,10 +965,10 @@ func Unlike(postID string, userID string, repliesToFetch int, replyOffset int) (
 		).Exec(common.BaseCtx)
 	}
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	user, err := common.Client.User.FindUnique(
@@ -980,10 +979,10 @@ func Unlike(postID string, userID string, repliesToFetch int, replyOffset int) (
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("user not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	knownUsers := user.Following()
@@ -1009,22 +1008,22 @@ func Unlike(postID string, userID string, repliesToFetch int, replyOffset int) (
 // Delete a follower relation
 func Unfollow(followedID string, followerID string, objectsToFetch string, feedObjectsToFetch int, feedObjectsOffset int) (schema.UserType, error) {
 	// Validate params
-	err := common.Validate.Var(followedID, "required,alphanum,lte=20,gt=0")
+	err := common.ValidateVar("username", followedID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(followerID, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", followerID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(objectsToFetch, "required,alpha,gt=0,oneof=feed dweet redweet redweetedDweet")
+	err = common.ValidateVar("objectsToFetch", objectsToFetch, "required,alpha,gt=0,oneof=feed dweet redweet redweetedDweet")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(feedObjectsOffset, "gte=0")
+	err = common.ValidateVar("feedObjectsOffset", feedObjectsOffset, "gte=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
@@ -1038,10 +1037,10 @@ func Unfollow(followedID string, followerID string, objectsToFetch string, feedO
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+		return schema.UserType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.UserType{}, apierr.NewInternal(err)
 	}
 
 	var user *db.UserModel
@@ -1243,10 +1242,10 @@ func Unfollow(followedID string, followerID string, objectsToFetch string, feedO
 			}
 		}
 		if err == db.ErrNotFound {
-			return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+			return schema.UserType{}, apierr.NewNotFound("user not found")
 		}
 		if err != nil {
-			return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.UserType{}, apierr.NewInternal(err)
 		}
 
 		authenticatedUser, err := common.Client.User.FindUnique(
@@ -1257,10 +1256,10 @@ func Unfollow(followedID string, followerID string, objectsToFetch string, feedO
 			),
 		).Exec(common.BaseCtx)
 		if err == db.ErrNotFound {
-			return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+			return schema.UserType{}, apierr.NewNotFound("user not found")
 		}
 		if err != nil {
-			return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+			return schema.UserType{}, apierr.NewInternal(err)
 		}
 
 		knownUsers := authenticatedUser.Following()
@@ -1518,10 +1517,10 @@ func Unfollow(followedID string, followerID string, objectsToFetch string, feedO
 		}
 	}
 	if err == db.ErrNotFound {
-		return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+		return schema.UserType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.UserType{}, apierr.NewInternal(err)
 	}
 
 	// Add followed to follower's following list
@@ -1538,10 +1537,10 @@ func Unfollow(followedID string, followerID string, objectsToFetch string, feedO
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+		return schema.UserType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.UserType{}, apierr.NewInternal(err)
 	}
 
 	knownUsers := authenticatedUser.Following()
@@ -1,171 +1,61 @@
 package database
 
 import (
-	"fmt"
-
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/common"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
 	"github.com/soumitradev/Dwitter/backend/schema"
 	"github.com/soumitradev/Dwitter/backend/util"
 )
 
-// Get User's liked dweets
-func GetLikedDweets(userID string, numberToFetch int, numOffset int, repliesToFetch int, replyOffset int) ([]schema.DweetType, error) {
+// Get User's liked dweets. The like and redweet users they know about are left to Loaders.
+func GetLikedDweets(userID string, numberToFetch int, numOffset int, repliesToFetch int, replyOffset int, sel Selection) ([]schema.DweetType, error) {
 	// Validate params
-	err := common.Validate.Var(userID, "required,alphanum,lte=20,gt=0")
+	err := common.ValidateVar("username", userID, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return []schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(numOffset, "gte=0")
+	err = common.ValidateVar("numberOffset", numOffset, "gte=0")
 	if err != nil {
 		return []schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(replyOffset, "gte=0")
+	err = common.ValidateVar("repliesOffset", replyOffset, "gte=0")
 	if err != nil {
 		return []schema.DweetType{}, err
 	}
 
-	var user *db.UserModel
-
-	// Check params and return data accordingly
-	if numberToFetch < 0 {
-		if repliesToFetch < 0 {
-			user, err = common.Client.User.FindUnique(
-				db.User.Username.Equals(userID),
-			).With(
-				db.User.LikedDweets.Fetch().With(
-					db.Dweet.Author.Fetch(),
-					db.Dweet.ReplyTo.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					),
-					db.Dweet.ReplyDweets.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					).OrderBy(
-						db.Dweet.LikeCount.Order(db.DESC),
-					),
-					db.Dweet.LikeUsers.Fetch().OrderBy(
-						db.User.FollowerCount.Order(db.DESC),
-					),
-					db.Dweet.RedweetUsers.Fetch().OrderBy(
-						db.User.FollowerCount.Order(db.DESC),
-					),
-				).OrderBy(
-					db.Dweet.PostedAt.Order(db.DESC),
-				),
-				db.User.Following.Fetch().OrderBy(
-					db.User.FollowerCount.Order(db.DESC),
-				),
-			).Exec(common.BaseCtx)
-		} else {
-			user, err = common.Client.User.FindUnique(
-				db.User.Username.Equals(userID),
-			).With(
-				db.User.LikedDweets.Fetch().With(
-					db.Dweet.Author.Fetch(),
-					db.Dweet.ReplyTo.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					),
-					db.Dweet.ReplyDweets.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					).OrderBy(
-						db.Dweet.LikeCount.Order(db.DESC),
-					).Take(repliesToFetch).Skip(replyOffset),
-					db.Dweet.LikeUsers.Fetch().OrderBy(
-						db.User.FollowerCount.Order(db.DESC),
-					),
-					db.Dweet.RedweetUsers.Fetch().OrderBy(
-						db.User.FollowerCount.Order(db.DESC),
-					),
-				).OrderBy(
-					db.Dweet.PostedAt.Order(db.DESC),
-				),
-				db.User.Following.Fetch().OrderBy(
-					db.User.FollowerCount.Order(db.DESC),
-				),
-			).Exec(common.BaseCtx)
-		}
-	} else {
-		if repliesToFetch < 0 {
-			user, err = common.Client.User.FindUnique(
-				db.User.Username.Equals(userID),
-			).With(
-				db.User.LikedDweets.Fetch().With(
-					db.Dweet.Author.Fetch(),
-					db.Dweet.ReplyTo.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					),
-					db.Dweet.ReplyDweets.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					).OrderBy(
-						db.Dweet.LikeCount.Order(db.DESC),
-					),
-					db.Dweet.LikeUsers.Fetch().OrderBy(
-						db.User.FollowerCount.Order(db.DESC),
-					),
-					db.Dweet.RedweetUsers.Fetch().OrderBy(
-						db.User.FollowerCount.Order(db.DESC),
-					),
-				).OrderBy(
-					db.Dweet.PostedAt.Order(db.DESC),
-				).Take(numberToFetch).Skip(numOffset),
-				db.User.Following.Fetch().OrderBy(
-					db.User.FollowerCount.Order(db.DESC),
-				),
-			).Exec(common.BaseCtx)
-		} else {
-			user, err = common.Client.User.FindUnique(
-				db.User.Username.Equals(userID),
-			).With(
-				db.User.LikedDweets.Fetch().With(
-					db.Dweet.Author.Fetch(),
-					db.Dweet.ReplyTo.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					),
-					db.Dweet.ReplyDweets.Fetch().With(
-						db.Dweet.Author.Fetch(),
-					).OrderBy(
-						db.Dweet.LikeCount.Order(db.DESC),
-					).Take(repliesToFetch).Skip(replyOffset),
-					db.Dweet.LikeUsers.Fetch().OrderBy(
-						db.User.FollowerCount.Order(db.DESC),
-					),
-					db.Dweet.RedweetUsers.Fetch().OrderBy(
-						db.User.FollowerCount.Order(db.DESC),
-					),
-				).OrderBy(
-					db.Dweet.PostedAt.Order(db.DESC),
-				).Take(numberToFetch).Skip(numOffset),
-				db.User.Following.Fetch().OrderBy(
-					db.User.FollowerCount.Order(db.DESC),
-				),
-			).Exec(common.BaseCtx)
-		}
+	likes := db.User.LikedDweets.Fetch(
+		db.Dweet.Author.Where(
+			visibleUser(),
+		),
+	).With(
+		replyRelations(sel, repliesToFetch, replyOffset)...,
+	).OrderBy(
+		db.Dweet.PostedAt.Order(db.DESC),
+	)
+	if numberToFetch >= 0 {
+		likes = likes.Take(numberToFetch).Skip(numOffset)
 	}
+
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(userID),
+	).With(
+		likes,
+	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return []schema.DweetType{}, fmt.Errorf("user not found: %v", err)
+		return []schema.DweetType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return []schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return []schema.DweetType{}, apierr.NewInternal(err)
 	}
 
-	knownUsers := user.Following()
-	knownUsers = append(knownUsers, *user)
-
-	// Add common likes and return formatted
 	var liked []schema.DweetType
 	for _, dweet := range user.LikedDweets() {
-		likes := dweet.LikeUsers()
-		redweetUsers := dweet.RedweetUsers()
-
-		// Find known people that liked thw dweet
-		mutualLikes := util.HashIntersectUsers(likes, knownUsers)
-		mutualRedweets := util.HashIntersectUsers(redweetUsers, knownUsers)
-
-		liked = append(liked, schema.FormatAsDweetType(&dweet, mutualLikes, mutualRedweets))
+		liked = append(liked, schema.FormatAsDweetType(&dweet, nil, nil))
 	}
-	return liked, err
+	return liked, nil
 }
 
 // TODO: GetDweets, GetRedweets, GetRedweetedDweets, GetFeedObjects
@@ -173,17 +63,19 @@ func GetLikedDweets(userID string, numberToFetch int, numOffset int, repliesToFe
 // Get feed for authenticated user
 func GetFeed(username string) ([]interface{}, error) {
 	// Validate params
-	err := common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return []interface{}{}, err
 	}
 
-	// grab followed users by username
+	// grab followed users by username, leaving out accounts that are hidden
 	// Grab their dweets and redweets
 	user, err := common.Client.User.FindUnique(
 		db.User.Username.Equals(username),
 	).With(
-		db.User.Following.Fetch().With(
+		db.User.Following.Fetch(
+			visibleUser(),
+		).With(
 			db.User.Dweets.Fetch().With(
 				db.Dweet.Author.Fetch(),
 				db.Dweet.ReplyDweets.Fetch().With(
@@ -225,10 +117,10 @@ func GetFeed(username string) ([]interface{}, error) {
 	).Exec(common.BaseCtx)
 
 	if err == db.ErrNotFound {
-		return []interface{}{}, fmt.Errorf("user not found: %v", err)
+		return []interface{}{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return []interface{}{}, fmt.Errorf("internal server error: %v", err)
+		return []interface{}{}, apierr.NewInternal(err)
 	}
 
 	following := user.Following()
@@ -239,7 +131,15 @@ func GetFeed(username string) ([]interface{}, error) {
 
 	for _, feedUser := range following {
 		posts = util.MergeDweetLists(posts, feedUser.Dweets())
-		redweets = util.MergeRedweetLists(redweets, feedUser.Redweets())
+
+		// Hide redweets of dweets by accounts that are hidden
+		var visibleRedweets []db.RedweetModel
+		for _, redweet := range feedUser.Redweets() {
+			if userVisible(redweet.RedweetOf().Author()) {
+				visibleRedweets = append(visibleRedweets, redweet)
+			}
+		}
+		redweets = util.MergeRedweetLists(redweets, visibleRedweets)
 	}
 
 	merged := util.MergeDweetRedweetList(posts, redweets)
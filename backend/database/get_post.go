@@ -1,185 +1,73 @@
 package database
 
 import (
-	"fmt"
-
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/common"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
 	"github.com/soumitradev/Dwitter/backend/schema"
-	"github.com/soumitradev/Dwitter/backend/util"
 )
 
-// Get dweet when not authenticated
-func GetPostUnauth(postID string, repliesToFetch int, replyOffset int) (schema.DweetType, error) {
-	// Validate params
-	err := common.Validate.Var(postID, "required,alphanum,len=10")
-	if err != nil {
-		return schema.DweetType{}, err
+// Return an error for dweets whose author is suspended, deactivated or being deleted
+func checkAuthorVisible(post *db.DweetModel) error {
+	if !userVisible(post.Author()) {
+		return apierr.NewNotFound("dweet unavailable: its author's account is not active")
 	}
+	return nil
+}
 
-	err = common.Validate.Var(replyOffset, "gte=0")
+// Get dweet as seen by viewerUsername, which is empty when not logged in.
+// The like and redweet users a logged in viewer knows about are left to Loaders.
+func GetPost(postID string, repliesToFetch int, replyOffset int, viewerUsername string, sel Selection) (schema.DweetType, error) {
+	// Validate params
+	err := common.ValidateVar("id", postID, "required,alphanum,len=10")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	// Check params and return data accordingly
-	var post *db.DweetModel
-	if repliesToFetch < 0 {
-		post, err = common.Client.Dweet.FindUnique(
-			db.Dweet.ID.Equals(postID),
-		).With(
-			db.Dweet.Author.Fetch(),
-			db.Dweet.ReplyDweets.Fetch().With(
-				db.Dweet.Author.Fetch(),
-			).OrderBy(
-				db.Dweet.LikeCount.Order(db.DESC),
-			),
-			db.Dweet.ReplyTo.Fetch().With(
-				db.Dweet.Author.Fetch(),
-			),
-		).Exec(common.BaseCtx)
-	} else {
-		post, err = common.Client.Dweet.FindUnique(
-			db.Dweet.ID.Equals(postID),
-		).With(
-			db.Dweet.Author.Fetch(),
-			db.Dweet.ReplyDweets.Fetch().With(
-				db.Dweet.Author.Fetch(),
-			).OrderBy(
-				db.Dweet.LikeCount.Order(db.DESC),
-			).Take(repliesToFetch).Skip(replyOffset),
-			db.Dweet.ReplyTo.Fetch().With(
-				db.Dweet.Author.Fetch(),
-			),
-		).Exec(common.BaseCtx)
-	}
-	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
-	}
-	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
-	}
-
-	npost := schema.FormatAsDweetType(post, []db.UserModel{}, []db.UserModel{})
-	return npost, err
-}
-
-// Get dweet when authenticated
-func GetPost(postID string, repliesToFetch int, replyOffset int, viewerUsername string) (schema.DweetType, error) {
-	// Validate params
-	err := common.Validate.Var(postID, "required,alphanum,len=10")
+	err = common.ValidateVar("repliesOffset", replyOffset, "gte=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(replyOffset, "gte=0")
+	err = common.ValidateVar("username", viewerUsername, "omitempty,alphanum,lte=20")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(viewerUsername, "required,alphanum,lte=20,gt=0")
+	post, err := getPost(postID, repliesToFetch, replyOffset, sel)
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	// Get your own following-list
-	viewUser, err := common.Client.User.FindUnique(
-		db.User.Username.Equals(viewerUsername),
-	).With(
-		db.User.Following.Fetch().OrderBy(
-			db.User.FollowerCount.Order(db.DESC),
-		),
-	).Exec(common.BaseCtx)
-	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+	if viewerUsername == "" {
+		// Nobody is known to someone who isn't logged in, so no like or redweet users are shown
+		return schema.FormatAsDweetType(post, []db.UserModel{}, []db.UserModel{}), nil
 	}
+	return schema.FormatAsDweetType(post, nil, nil), nil
+}
 
-	following := viewUser.Following()
+// Fetch a dweet along with its replies if they were selected
+func getPost(postID string, repliesToFetch int, replyOffset int, sel Selection) (*db.DweetModel, error) {
 
-	var post *db.DweetModel
+	// The author is always fetched to check that they can be seen
+	relations := append([]db.DweetRelationWith{
+		db.Dweet.Author.Fetch(),
+	}, replyRelations(sel, repliesToFetch, replyOffset)...)
 
-	// Check params and return data accordingly
-	if repliesToFetch < 0 {
-		post, err = common.Client.Dweet.FindUnique(
-			db.Dweet.ID.Equals(postID),
-		).With(
-			db.Dweet.Author.Fetch(),
-			db.Dweet.ReplyDweets.Fetch().With(
-				db.Dweet.Author.Fetch(),
-			).OrderBy(
-				db.Dweet.LikeCount.Order(db.DESC),
-			),
-			db.Dweet.ReplyTo.Fetch().With(
-				db.Dweet.Author.Fetch(),
-			),
-			db.Dweet.LikeUsers.Fetch().OrderBy(
-				db.User.FollowerCount.Order(db.DESC),
-			),
-			db.Dweet.RedweetUsers.Fetch().OrderBy(
-				db.User.FollowerCount.Order(db.DESC),
-			),
-		).Exec(common.BaseCtx)
-	} else {
-		post, err = common.Client.Dweet.FindUnique(
-			db.Dweet.ID.Equals(postID),
-		).With(
-			db.Dweet.Author.Fetch(),
-			db.Dweet.ReplyDweets.Fetch().With(
-				db.Dweet.Author.Fetch(),
-			).OrderBy(
-				db.Dweet.LikeCount.Order(db.DESC),
-			).Take(repliesToFetch).Skip(replyOffset),
-			db.Dweet.ReplyTo.Fetch().With(
-				db.Dweet.Author.Fetch(),
-			),
-			db.Dweet.LikeUsers.Fetch().OrderBy(
-				db.User.FollowerCount.Order(db.DESC),
-			),
-			db.Dweet.RedweetUsers.Fetch().OrderBy(
-				db.User.FollowerCount.Order(db.DESC),
-			),
-		).Exec(common.BaseCtx)
-	}
+	post, err := common.Client.Dweet.FindUnique(
+		db.Dweet.ID.Equals(postID),
+	).With(
+		relations...,
+	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+		return nil, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
-	}
-
-	// If the dweet is liked by requesting user, include the requesting user in the like_users list
-	likes := post.LikeUsers()
-	selfLike := false
-	for _, user := range likes {
-		if user.Username == viewerUsername {
-			selfLike = true
-		}
+		return nil, apierr.NewInternal(err)
 	}
-	// Find known people that liked the dweet
-	mutualLikes := util.HashIntersectUsers(likes, following)
-
-	// Add requesting user to like_users list
-	if selfLike {
-		mutualLikes = append(mutualLikes, *viewUser)
-	}
-
-	// If the dweet is redweeted by requesting user, include the requesting user in the redweet_users list
-	redweetUsers := post.RedweetUsers()
-	selfRedweet := false
-	for _, user := range redweetUsers {
-		if user.Username == viewerUsername {
-			selfRedweet = true
-		}
-	}
-	// Find known people that redweeted the dweet
-	mutualRedweets := util.HashIntersectUsers(redweetUsers, following)
-
-	// Add requesting user to redweet_users list
-	if selfRedweet {
-		mutualRedweets = append(mutualRedweets, *viewUser)
+	err = checkAuthorVisible(post)
+	if err != nil {
+		return nil, err
 	}
-
-	// Send back the dweet requested, along with like_users
-	npost := schema.FormatAsDweetType(post, mutualLikes, mutualRedweets)
-	return npost, err
+	return post, nil
 }
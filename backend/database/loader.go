@@ -0,0 +1,317 @@
+package database
+
+import (
+	"sync"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+)
+
+// A batchFunc fetches the values of many keys at once. Keys it leaves out have no value.
+type batchFunc func(keys []string) (map[string]interface{}, error)
+
+// A Loader batches the lookups made while resolving one GraphQL request, so the authors of a list of n dweets
+// take one query instead of n (the DataLoader pattern). Values are cached until the request ends.
+type Loader struct {
+	batch   batchFunc
+	mutex   sync.Mutex
+	pending []string
+	queued  map[string]bool
+	values  map[string]interface{}
+	errs    map[string]error
+}
+
+func newLoader(batch batchFunc) *Loader {
+	return &Loader{
+		batch:  batch,
+		queued: map[string]bool{},
+		values: map[string]interface{}{},
+		errs:   map[string]error{},
+	}
+}
+
+// Queue a key, and return a thunk that fetches every queued key the first time one of them is needed.
+// Resolvers return the thunk, so that GraphQL queues the keys of a whole level of the query before fetching any.
+func (l *Loader) Load(key string) func() (interface{}, error) {
+	l.mutex.Lock()
+	if !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mutex.Unlock()
+
+	return func() (interface{}, error) {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		if err, failed := l.errs[key]; failed {
+			return nil, err
+		}
+		if value, fetched := l.values[key]; fetched {
+			return value, nil
+		}
+
+		keys := l.pending
+		l.pending = nil
+		values, err := l.batch(keys)
+		for _, pendingKey := range keys {
+			if err != nil {
+				l.errs[pendingKey] = err
+			} else {
+				l.values[pendingKey] = values[pendingKey]
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return l.values[key], nil
+	}
+}
+
+// Loaders for the relations of dweets and redweets that weren't fetched along with them, for one request
+type Loaders struct {
+	viewerUsername string
+	knownMutex     sync.Mutex
+	known          []db.UserModel
+	knownFetched   bool
+
+	users        *Loader
+	dweets       *Loader
+	likeUsers    *Loader
+	redweetUsers *Loader
+	replies      *Loader
+}
+
+// Make the loaders for a request by viewerUsername, which is empty when not logged in
+func NewLoaders(viewerUsername string) *Loaders {
+	loaders := &Loaders{viewerUsername: viewerUsername}
+	loaders.users = newLoader(batchUsers)
+	loaders.dweets = newLoader(batchDweets)
+	loaders.likeUsers = newLoader(loaders.batchLikeUsers)
+	loaders.redweetUsers = newLoader(loaders.batchRedweetUsers)
+	loaders.replies = newLoader(batchReplies)
+	return loaders
+}
+
+// Load a user as a schema.BasicUserType, or nil if there is no such user
+func (l *Loaders) User(username string) func() (interface{}, error) {
+	thunk := l.users.Load(username)
+	return func() (interface{}, error) {
+		value, err := thunk()
+		if err != nil || value == nil {
+			return nil, err
+		}
+		user := value.(db.UserModel)
+		return schema.FormatAsBasicUserType(&user), nil
+	}
+}
+
+// Load a dweet as a schema.BasicDweetType, or nil if there is no such dweet or its author is hidden
+func (l *Loaders) Dweet(id string) func() (interface{}, error) {
+	thunk := l.dweets.Load(id)
+	return func() (interface{}, error) {
+		value, err := thunk()
+		if err != nil || value == nil {
+			return nil, err
+		}
+		dweet := value.(db.DweetModel)
+		return schema.FormatAsBasicDweetType(&dweet), nil
+	}
+}
+
+// Load the users who liked a dweet that the viewer knows about, as []schema.BasicUserType
+func (l *Loaders) LikeUsers(dweetID string) func() (interface{}, error) {
+	return formatUsersThunk(l.likeUsers.Load(dweetID))
+}
+
+// Load the users who redweeted a dweet that the viewer knows about, as []schema.BasicUserType
+func (l *Loaders) RedweetUsers(dweetID string) func() (interface{}, error) {
+	return formatUsersThunk(l.redweetUsers.Load(dweetID))
+}
+
+// Load the replies to a dweet by visible authors, most liked first, as []schema.BasicDweetType
+func (l *Loaders) Replies(dweetID string) func() (interface{}, error) {
+	thunk := l.replies.Load(dweetID)
+	return func() (interface{}, error) {
+		value, err := thunk()
+		if err != nil {
+			return nil, err
+		}
+		replies, _ := value.([]db.DweetModel)
+		formatted := make([]schema.BasicDweetType, 0, len(replies))
+		for i := range replies {
+			formatted = append(formatted, schema.FormatAsBasicDweetType(&replies[i]))
+		}
+		return formatted, nil
+	}
+}
+
+func formatUsersThunk(thunk func() (interface{}, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		value, err := thunk()
+		if err != nil {
+			return nil, err
+		}
+		users, _ := value.([]db.UserModel)
+		formatted := make([]schema.BasicUserType, 0, len(users))
+		for i := range users {
+			formatted = append(formatted, schema.FormatAsBasicUserType(&users[i]))
+		}
+		return formatted, nil
+	}
+}
+
+func batchUsers(usernames []string) (map[string]interface{}, error) {
+	users, err := common.Client.User.FindMany(
+		db.User.Username.In(usernames),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	values := map[string]interface{}{}
+	for _, user := range users {
+		values[user.Username] = user
+	}
+	return values, nil
+}
+
+func batchDweets(ids []string) (map[string]interface{}, error) {
+	dweets, err := common.Client.Dweet.FindMany(
+		db.Dweet.ID.In(ids),
+		db.Dweet.Author.Where(
+			visibleUser(),
+		),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	values := map[string]interface{}{}
+	for _, dweet := range dweets {
+		values[dweet.ID] = dweet
+	}
+	return values, nil
+}
+
+func batchReplies(ids []string) (map[string]interface{}, error) {
+	replies, err := common.Client.Dweet.FindMany(
+		db.Dweet.OriginalReplyID.In(ids),
+		db.Dweet.Author.Where(
+			visibleUser(),
+		),
+	).OrderBy(
+		db.Dweet.LikeCount.Order(db.DESC),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	grouped := map[string][]db.DweetModel{}
+	for _, reply := range replies {
+		originalID, _ := reply.OriginalReplyID()
+		grouped[originalID] = append(grouped[originalID], reply)
+	}
+	values := map[string]interface{}{}
+	for id, group := range grouped {
+		values[id] = group
+	}
+	return values, nil
+}
+
+// The viewer and the users they follow, who are the only like and redweet users shown. They are fetched once per request.
+func (l *Loaders) knownUsernames() ([]string, error) {
+	l.knownMutex.Lock()
+	defer l.knownMutex.Unlock()
+
+	if !l.knownFetched && l.viewerUsername != "" {
+		viewer, err := common.Client.User.FindUnique(
+			db.User.Username.Equals(l.viewerUsername),
+		).With(
+			db.User.Following.Fetch(),
+		).Exec(common.BaseCtx)
+		if err != nil {
+			return nil, apierr.NewInternal(err)
+		}
+		l.known = append(viewer.Following(), *viewer)
+	}
+	l.knownFetched = true
+
+	usernames := make([]string, 0, len(l.known))
+	for _, user := range l.known {
+		usernames = append(usernames, user.Username)
+	}
+	return usernames, nil
+}
+
+func (l *Loaders) batchLikeUsers(ids []string) (map[string]interface{}, error) {
+	known, err := l.knownUsernames()
+	if err != nil || len(known) == 0 {
+		return nil, err
+	}
+
+	users, err := common.Client.User.FindMany(
+		db.User.Username.In(known),
+		db.User.LikedDweets.Some(
+			db.Dweet.ID.In(ids),
+		),
+	).With(
+		db.User.LikedDweets.Fetch(
+			db.Dweet.ID.In(ids),
+		),
+	).OrderBy(
+		db.User.FollowerCount.Order(db.DESC),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	grouped := map[string][]db.UserModel{}
+	for _, user := range users {
+		for _, dweet := range user.LikedDweets() {
+			grouped[dweet.ID] = append(grouped[dweet.ID], user)
+		}
+	}
+	values := map[string]interface{}{}
+	for id, group := range grouped {
+		values[id] = group
+	}
+	return values, nil
+}
+
+func (l *Loaders) batchRedweetUsers(ids []string) (map[string]interface{}, error) {
+	known, err := l.knownUsernames()
+	if err != nil || len(known) == 0 {
+		return nil, err
+	}
+
+	users, err := common.Client.User.FindMany(
+		db.User.Username.In(known),
+		db.User.RedweetedDweets.Some(
+			db.Dweet.ID.In(ids),
+		),
+	).With(
+		db.User.RedweetedDweets.Fetch(
+			db.Dweet.ID.In(ids),
+		),
+	).OrderBy(
+		db.User.FollowerCount.Order(db.DESC),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	grouped := map[string][]db.UserModel{}
+	for _, user := range users {
+		for _, dweet := range user.RedweetedDweets() {
+			grouped[dweet.ID] = append(grouped[dweet.ID], user)
+		}
+	}
+	values := map[string]interface{}{}
+	for id, group := range grouped {
+		values[id] = group
+	}
+	return values, nil
+}
@@ -0,0 +1,379 @@
+package database
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+)
+
+// Connections return this many items when not asked for a number, and never more than maxPageSize
+const (
+	DefaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Cursors of feed objects say whether they point at a dweet or a redweet
+const (
+	feedDweetPrefix   = "d:"
+	feedRedweetPrefix = "r:"
+)
+
+var errInvalidCursor = apierr.NewValidation("after", "invalid cursor")
+
+// Make an opaque cursor out of the time and key an item is ordered by.
+// Lists are ordered newest first, and items with the same time by key, largest first.
+func encodeCursor(at time.Time, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(at.UTC().Format(time.RFC3339Nano) + "|" + key))
+}
+
+// Get back the time and key a cursor was made from
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return time.Time{}, "", errInvalidCursor
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+	return at, parts[1], nil
+}
+
+// Check the number of items asked for
+func checkPageSize(first int) error {
+	if first < 0 {
+		return apierr.NewValidation("first", "invalid request: first can't be negative")
+	}
+	if first > maxPageSize {
+		return apierr.NewValidation("first", "invalid request: first can't be more than %d", maxPageSize)
+	}
+	return nil
+}
+
+// Fetch a page of dweets matching filters, newest first, leaving out dweets by hidden accounts
+func dweetConnection(filters []db.DweetWhereParam, first int, after string) (schema.DweetConnectionType, error) {
+	err := checkPageSize(first)
+	if err != nil {
+		return schema.DweetConnectionType{}, err
+	}
+
+	filters = append(filters, db.Dweet.Author.Where(
+		visibleUser(),
+	))
+	if after != "" {
+		postedAt, id, err := decodeCursor(after)
+		if err != nil {
+			return schema.DweetConnectionType{}, err
+		}
+		filters = append(filters, db.Dweet.Or(
+			db.Dweet.PostedAt.Before(postedAt),
+			db.Dweet.And(
+				db.Dweet.PostedAt.Equals(postedAt),
+				db.Dweet.ID.Lt(id),
+			),
+		))
+	}
+
+	// Fetch one more than asked for to know if there's a next page
+	posts, err := common.Client.Dweet.FindMany(
+		filters...,
+	).With(
+		db.Dweet.Author.Fetch(),
+	).OrderBy(
+		db.Dweet.PostedAt.Order(db.DESC),
+		db.Dweet.ID.Order(db.DESC),
+	).Take(first + 1).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.DweetConnectionType{}, apierr.NewInternal(err)
+	}
+
+	hasNextPage := len(posts) > first
+	if hasNextPage {
+		posts = posts[:first]
+	}
+
+	edges := []schema.DweetEdgeType{}
+	cursors := []string{}
+	for _, post := range posts {
+		cursor := encodeCursor(post.PostedAt, post.ID)
+		edges = append(edges, schema.DweetEdgeType{
+			Cursor: cursor,
+			Node:   schema.FormatAsBasicDweetType(&post),
+		})
+		cursors = append(cursors, cursor)
+	}
+	return schema.DweetConnectionType{
+		Edges:    edges,
+		PageInfo: schema.FormatAsPageInfoType(cursors, hasNextPage, after != ""),
+	}, nil
+}
+
+// Fetch a page of users matching filters, newest accounts first, leaving out hidden accounts
+func userConnection(filters []db.UserWhereParam, first int, after string) (schema.UserConnectionType, error) {
+	err := checkPageSize(first)
+	if err != nil {
+		return schema.UserConnectionType{}, err
+	}
+
+	filters = append(filters, visibleUser())
+	if after != "" {
+		createdAt, username, err := decodeCursor(after)
+		if err != nil {
+			return schema.UserConnectionType{}, err
+		}
+		filters = append(filters, db.User.Or(
+			db.User.CreatedAt.Before(createdAt),
+			db.User.And(
+				db.User.CreatedAt.Equals(createdAt),
+				db.User.Username.Lt(username),
+			),
+		))
+	}
+
+	// Fetch one more than asked for to know if there's a next page
+	users, err := common.Client.User.FindMany(
+		filters...,
+	).OrderBy(
+		db.User.CreatedAt.Order(db.DESC),
+		db.User.Username.Order(db.DESC),
+	).Take(first + 1).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.UserConnectionType{}, apierr.NewInternal(err)
+	}
+
+	hasNextPage := len(users) > first
+	if hasNextPage {
+		users = users[:first]
+	}
+
+	edges := []schema.UserEdgeType{}
+	cursors := []string{}
+	for _, user := range users {
+		cursor := encodeCursor(user.CreatedAt, user.Username)
+		edges = append(edges, schema.UserEdgeType{
+			Cursor: cursor,
+			Node:   schema.FormatAsBasicUserType(&user),
+		})
+		cursors = append(cursors, cursor)
+	}
+	return schema.UserConnectionType{
+		Edges:    edges,
+		PageInfo: schema.FormatAsPageInfoType(cursors, hasNextPage, after != ""),
+	}, nil
+}
+
+// Search dweets by content, newest first
+func SearchPostsConnection(query string, first int, after string) (schema.DweetConnectionType, error) {
+	err := common.ValidateVar("text", query, "required,gt=0")
+	if err != nil {
+		return schema.DweetConnectionType{}, err
+	}
+
+	return dweetConnection([]db.DweetWhereParam{
+		db.Dweet.DweetBody.Contains(query),
+	}, first, after)
+}
+
+// Get a page of the dweets a user liked
+func GetLikedDweetsConnection(username string, first int, after string) (schema.DweetConnectionType, error) {
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
+	if err != nil {
+		return schema.DweetConnectionType{}, err
+	}
+
+	return dweetConnection([]db.DweetWhereParam{
+		db.Dweet.LikeUsers.Some(
+			db.User.Username.Equals(username),
+		),
+	}, first, after)
+}
+
+// Get a page of the replies to a dweet
+func GetRepliesConnection(postID string, first int, after string) (schema.DweetConnectionType, error) {
+	err := common.ValidateVar("id", postID, "required,alphanum,len=10")
+	if err != nil {
+		return schema.DweetConnectionType{}, err
+	}
+
+	post, err := common.Client.Dweet.FindUnique(
+		db.Dweet.ID.Equals(postID),
+	).With(
+		db.Dweet.Author.Fetch(),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return schema.DweetConnectionType{}, apierr.NewNotFound("dweet not found")
+	}
+	if err != nil {
+		return schema.DweetConnectionType{}, apierr.NewInternal(err)
+	}
+	err = checkAuthorVisible(post)
+	if err != nil {
+		return schema.DweetConnectionType{}, err
+	}
+
+	return dweetConnection([]db.DweetWhereParam{
+		db.Dweet.OriginalReplyID.Equals(postID),
+	}, first, after)
+}
+
+// Search users by username, newest accounts first
+func SearchUsersConnection(query string, first int, after string) (schema.UserConnectionType, error) {
+	err := common.ValidateVar("text", query, "required,gt=0")
+	if err != nil {
+		return schema.UserConnectionType{}, err
+	}
+
+	return userConnection([]db.UserWhereParam{
+		db.User.Username.Contains(query),
+	}, first, after)
+}
+
+// Get a page of a user's followers
+func GetFollowersConnection(username string, first int, after string) (schema.UserConnectionType, error) {
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
+	if err != nil {
+		return schema.UserConnectionType{}, err
+	}
+
+	return userConnection([]db.UserWhereParam{
+		db.User.Following.Some(
+			db.User.Username.Equals(username),
+		),
+	}, first, after)
+}
+
+// Get a page of the users a user follows
+func GetFollowingConnection(username string, first int, after string) (schema.UserConnectionType, error) {
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
+	if err != nil {
+		return schema.UserConnectionType{}, err
+	}
+
+	return userConnection([]db.UserWhereParam{
+		db.User.Followers.Some(
+			db.User.Username.Equals(username),
+		),
+	}, first, after)
+}
+
+// Get a page of a user's dweets and redweets, newest first
+func GetFeedObjectsConnection(username string, first int, after string) (schema.FeedObjectConnectionType, error) {
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
+	if err != nil {
+		return schema.FeedObjectConnectionType{}, err
+	}
+	err = checkPageSize(first)
+	if err != nil {
+		return schema.FeedObjectConnectionType{}, err
+	}
+	err = checkUserAvailable(username)
+	if err != nil {
+		return schema.FeedObjectConnectionType{}, err
+	}
+
+	dweetFilters := []db.DweetWhereParam{
+		db.Dweet.AuthorID.Equals(username),
+	}
+	// Hide redweets of dweets by accounts that are hidden
+	redweetFilters := []db.RedweetWhereParam{
+		db.Redweet.AuthorID.Equals(username),
+		db.Redweet.RedweetOf.Where(
+			db.Dweet.Author.Where(
+				visibleUser(),
+			),
+		),
+	}
+
+	// Dweets and redweets made at the same time are ordered by their keys, so every redweet comes before every dweet
+	if after != "" {
+		at, key, err := decodeCursor(after)
+		if err != nil {
+			return schema.FeedObjectConnectionType{}, err
+		}
+		switch {
+		case strings.HasPrefix(key, feedDweetPrefix):
+			dweetFilters = append(dweetFilters, db.Dweet.Or(
+				db.Dweet.PostedAt.Before(at),
+				db.Dweet.And(
+					db.Dweet.PostedAt.Equals(at),
+					db.Dweet.ID.Lt(strings.TrimPrefix(key, feedDweetPrefix)),
+				),
+			))
+			redweetFilters = append(redweetFilters, db.Redweet.RedweetTime.Before(at))
+		case strings.HasPrefix(key, feedRedweetPrefix):
+			dweetFilters = append(dweetFilters, db.Dweet.PostedAt.BeforeEquals(at))
+			redweetFilters = append(redweetFilters, db.Redweet.Or(
+				db.Redweet.RedweetTime.Before(at),
+				db.Redweet.And(
+					db.Redweet.RedweetTime.Equals(at),
+					db.Redweet.DbID.Lt(strings.TrimPrefix(key, feedRedweetPrefix)),
+				),
+			))
+		default:
+			return schema.FeedObjectConnectionType{}, errInvalidCursor
+		}
+	}
+
+	// Fetch one more of each than asked for, which is enough to fill the page and know if there's a next one
+	posts, err := common.Client.Dweet.FindMany(
+		dweetFilters...,
+	).With(
+		db.Dweet.Author.Fetch(),
+	).OrderBy(
+		db.Dweet.PostedAt.Order(db.DESC),
+		db.Dweet.ID.Order(db.DESC),
+	).Take(first + 1).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.FeedObjectConnectionType{}, apierr.NewInternal(err)
+	}
+	redweets, err := common.Client.Redweet.FindMany(
+		redweetFilters...,
+	).With(
+		db.Redweet.Author.Fetch(),
+		db.Redweet.RedweetOf.Fetch().With(
+			db.Dweet.Author.Fetch(),
+		),
+	).OrderBy(
+		db.Redweet.RedweetTime.Order(db.DESC),
+		db.Redweet.DbID.Order(db.DESC),
+	).Take(first + 1).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.FeedObjectConnectionType{}, apierr.NewInternal(err)
+	}
+
+	edges := []schema.FeedObjectEdgeType{}
+	cursors := []string{}
+	for len(edges) < first && (len(posts) > 0 || len(redweets) > 0) {
+		var edge schema.FeedObjectEdgeType
+		if len(redweets) == 0 || (len(posts) > 0 && posts[0].PostedAt.After(redweets[0].RedweetTime)) {
+			edge = schema.FeedObjectEdgeType{
+				Cursor: encodeCursor(posts[0].PostedAt, feedDweetPrefix+posts[0].ID),
+				Node:   schema.FormatAsBasicDweetType(&posts[0]),
+			}
+			posts = posts[1:]
+		} else {
+			edge = schema.FeedObjectEdgeType{
+				Cursor: encodeCursor(redweets[0].RedweetTime, feedRedweetPrefix+redweets[0].DbID),
+				Node:   schema.FormatAsRedweetType(&redweets[0]),
+			}
+			redweets = redweets[1:]
+		}
+		edges = append(edges, edge)
+		cursors = append(cursors, edge.Cursor)
+	}
+
+	hasNextPage := len(posts) > 0 || len(redweets) > 0
+	return schema.FeedObjectConnectionType{
+		Edges:    edges,
+		PageInfo: schema.FormatAsPageInfoType(cursors, hasNextPage, after != ""),
+	}, nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	india := time.FixedZone("IST", 5*60*60+30*60)
+	tests := []struct {
+		name string
+		at   time.Time
+		key  string
+	}{
+		{name: "dweet", at: time.Date(2022, 3, 14, 15, 9, 26, 535897932, time.UTC), key: "a1B2c3D4e5"},
+		{name: "whole second", at: time.Date(2022, 3, 14, 15, 9, 26, 0, time.UTC), key: "soumitradev"},
+		// Items are ordered by the instant they happened, whatever zone the time was read in
+		{name: "other time zone", at: time.Date(2022, 3, 14, 20, 39, 26, 1, india), key: "soumitradev"},
+		{name: "feed dweet", at: time.Date(2022, 3, 14, 15, 9, 26, 1000, time.UTC), key: feedDweetPrefix + "a1B2c3D4e5"},
+		{name: "feed redweet", at: time.Date(2022, 3, 14, 15, 9, 26, 1000, time.UTC), key: feedRedweetPrefix + "a1B2c3D4e5"},
+		// Only the first separator splits the time from the key
+		{name: "key with a separator", at: time.Date(2022, 3, 14, 15, 9, 26, 0, time.UTC), key: "a|b|c"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cursor := encodeCursor(test.at, test.key)
+			if _, err := base64.RawURLEncoding.DecodeString(cursor); err != nil {
+				t.Fatalf("cursor %q isn't unpadded base64url: %v", cursor, err)
+			}
+
+			at, key, err := decodeCursor(cursor)
+			if err != nil {
+				t.Fatalf("could not decode cursor %q: %v", cursor, err)
+			}
+			if !at.Equal(test.at) || key != test.key {
+				t.Errorf("got %v and %q, want %v and %q", at, key, test.at, test.key)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "padded base64", cursor: base64.URLEncoding.EncodeToString([]byte("2022-03-14T15:09:26Z|a"))},
+		{name: "no separator", cursor: encode("2022-03-14T15:09:26Z")},
+		{name: "no key", cursor: encode("2022-03-14T15:09:26Z|")},
+		{name: "no time", cursor: encode("|a1B2c3D4e5")},
+		{name: "not a time", cursor: encode("yesterday|a1B2c3D4e5")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := decodeCursor(test.cursor)
+			if err == nil {
+				t.Fatalf("decoded cursor %q, want an error", test.cursor)
+			}
+			var coded *apierr.Error
+			if !errors.As(err, &coded) || coded.Code != apierr.Validation || coded.Field != "after" {
+				t.Errorf("got %v, want a validation error for after", err)
+			}
+		})
+	}
+}
+
+func TestCheckPageSize(t *testing.T) {
+	tests := []struct {
+		first   int
+		wantErr bool
+	}{
+		{first: -1, wantErr: true},
+		{first: 0},
+		{first: DefaultPageSize},
+		{first: maxPageSize},
+		{first: maxPageSize + 1, wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := checkPageSize(test.first)
+		if (err != nil) != test.wantErr {
+			t.Errorf("checkPageSize(%d) got %v, want error: %v", test.first, err, test.wantErr)
+		}
+	}
+}
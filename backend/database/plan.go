@@ -0,0 +1,232 @@
+package database
+
+import (
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
+)
+
+// A Selection is the tree of fields a GraphQL query asked for, keyed by field name.
+// Functions that take one only fetch the relations that are going to be returned,
+// and leave authors, replied to dweets and like and redweet users to Loaders.
+// A nil Selection selects everything.
+type Selection map[string]Selection
+
+// Check if a field was selected
+func (s Selection) Has(field string) bool {
+	if s == nil {
+		return true
+	}
+	_, selected := s[field]
+	return selected
+}
+
+// The fields selected under a field
+func (s Selection) Field(field string) Selection {
+	if s == nil {
+		return nil
+	}
+	sub, selected := s[field]
+	if !selected {
+		return Selection{}
+	}
+	return sub
+}
+
+// The field of a user each objectsToFetch value fills
+var objectFields = map[string]string{
+	"feed":           "feedObjects",
+	"dweet":          "dweets",
+	"redweet":        "redweets",
+	"redweetedDweet": "redweetedDweets",
+	"liked":          "likedDweets",
+}
+
+// Replies to fetch with a dweet if they were selected, most liked first, leaving out replies by hidden accounts
+func replyRelations(sel Selection, repliesToFetch int, replyOffset int) []db.DweetRelationWith {
+	if !sel.Has("replyDweets") {
+		return nil
+	}
+	replies := db.Dweet.ReplyDweets.Fetch(
+		db.Dweet.Author.Where(
+			visibleUser(),
+		),
+	).OrderBy(
+		db.Dweet.LikeCount.Order(db.DESC),
+	)
+	if repliesToFetch >= 0 {
+		replies = replies.Take(repliesToFetch).Skip(replyOffset)
+	}
+	return []db.DweetRelationWith{replies}
+}
+
+// Relations to fetch with a user for objectsToFetch if they were selected, and for their followers and following if those were.
+// Posts by hidden accounts are left out, like the accounts themselves.
+// Feeds are merged from dweets and redweets, so both are fetched up to the end of the page and cut by userObjects.
+func userRelations(sel Selection, objectsToFetch string, feedObjectsToFetch int, feedObjectsOffset int) []db.UserRelationWith {
+	var relations []db.UserRelationWith
+
+	if sel.Has(objectFields[objectsToFetch]) {
+		switch objectsToFetch {
+		case "feed":
+			dweets := db.User.Dweets.Fetch().OrderBy(
+				db.Dweet.PostedAt.Order(db.DESC),
+			)
+			redweets := db.User.Redweets.Fetch(
+				db.Redweet.RedweetOf.Where(
+					db.Dweet.Author.Where(
+						visibleUser(),
+					),
+				),
+			).With(
+				db.Redweet.RedweetOf.Fetch(),
+			).OrderBy(
+				db.Redweet.RedweetTime.Order(db.DESC),
+			)
+			if feedObjectsToFetch >= 0 {
+				dweets = dweets.Take(feedObjectsToFetch + feedObjectsOffset)
+				redweets = redweets.Take(feedObjectsToFetch + feedObjectsOffset)
+			}
+			relations = append(relations, dweets, redweets)
+		case "dweet":
+			dweets := db.User.Dweets.Fetch().OrderBy(
+				db.Dweet.PostedAt.Order(db.DESC),
+			)
+			if feedObjectsToFetch >= 0 {
+				dweets = dweets.Take(feedObjectsToFetch).Skip(feedObjectsOffset)
+			}
+			relations = append(relations, dweets)
+		case "redweet":
+			redweets := db.User.Redweets.Fetch(
+				db.Redweet.RedweetOf.Where(
+					db.Dweet.Author.Where(
+						visibleUser(),
+					),
+				),
+			).With(
+				db.Redweet.RedweetOf.Fetch(),
+			).OrderBy(
+				db.Redweet.RedweetTime.Order(db.DESC),
+			)
+			if feedObjectsToFetch >= 0 {
+				redweets = redweets.Take(feedObjectsToFetch).Skip(feedObjectsOffset)
+			}
+			relations = append(relations, redweets)
+		case "redweetedDweet":
+			dweets := db.User.RedweetedDweets.Fetch(
+				db.Dweet.Author.Where(
+					visibleUser(),
+				),
+			).OrderBy(
+				db.Dweet.PostedAt.Order(db.DESC),
+			)
+			if feedObjectsToFetch >= 0 {
+				dweets = dweets.Take(feedObjectsToFetch).Skip(feedObjectsOffset)
+			}
+			relations = append(relations, dweets)
+		case "liked":
+			dweets := db.User.LikedDweets.Fetch(
+				db.Dweet.Author.Where(
+					visibleUser(),
+				),
+			).OrderBy(
+				db.Dweet.PostedAt.Order(db.DESC),
+			)
+			if feedObjectsToFetch >= 0 {
+				dweets = dweets.Take(feedObjectsToFetch).Skip(feedObjectsOffset)
+			}
+			relations = append(relations, dweets)
+		}
+	}
+
+	if sel.Has("followers") {
+		relations = append(relations, db.User.Followers.Fetch(
+			visibleUser(),
+		).OrderBy(
+			db.User.FollowerCount.Order(db.DESC),
+		))
+	}
+	if sel.Has("following") {
+		relations = append(relations, db.User.Following.Fetch(
+			visibleUser(),
+		).OrderBy(
+			db.User.FollowerCount.Order(db.DESC),
+		))
+	}
+	return relations
+}
+
+// Collect the objects userRelations fetched for objectsToFetch, in the order FormatAsUserType takes them
+func userObjects(user *db.UserModel, objectsToFetch string, feedObjectsToFetch int, feedObjectsOffset int) []interface{} {
+	relations := user.RelationsUser
+	objects := []interface{}{}
+
+	switch objectsToFetch {
+	case "feed":
+		merged := util.MergeDweetRedweetList(relations.Dweets, relations.Redweets)
+		if feedObjectsToFetch >= 0 {
+			end := util.Min(feedObjectsOffset+feedObjectsToFetch, len(merged))
+			if feedObjectsOffset >= end {
+				return objects
+			}
+			merged = merged[feedObjectsOffset:end]
+		}
+		objects = append(objects, merged...)
+	case "dweet":
+		for _, dweet := range relations.Dweets {
+			objects = append(objects, dweet)
+		}
+	case "redweet":
+		for _, redweet := range relations.Redweets {
+			objects = append(objects, redweet)
+		}
+	case "redweetedDweet":
+		for _, dweet := range relations.RedweetedDweets {
+			objects = append(objects, dweet)
+		}
+	case "liked":
+		for _, dweet := range relations.LikedDweets {
+			objects = append(objects, dweet)
+		}
+	}
+	return objects
+}
+
+// The viewer and the users they follow, whose follows are the ones shown on other profiles.
+// They're only fetched if followers or following were selected, and are nil when not logged in.
+func knownUsers(viewerUsername string, sel Selection) ([]db.UserModel, error) {
+	if viewerUsername == "" || !(sel.Has("followers") || sel.Has("following")) {
+		return nil, nil
+	}
+	viewer, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(viewerUsername),
+	).With(
+		db.User.Following.Fetch().OrderBy(
+			db.User.FollowerCount.Order(db.DESC),
+		),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, err
+	}
+	return append(viewer.Following(), *viewer), nil
+}
+
+// Format a user fetched with userRelations.
+// Viewers see all of their own followers and following and their email, and only the ones they know about on other profiles.
+func formatUser(user *db.UserModel, viewerUsername string, known []db.UserModel, objectsToFetch string, feedObjectsToFetch int, feedObjectsOffset int) (schema.UserType, error) {
+	var alsoFollowedBy []db.UserModel
+	var alsoFollowing []db.UserModel
+	isViewer := viewerUsername == user.Username
+
+	if isViewer {
+		alsoFollowedBy = user.RelationsUser.Followers
+		alsoFollowing = user.RelationsUser.Following
+	} else if known != nil {
+		alsoFollowedBy = util.HashIntersectUsers(user.RelationsUser.Followers, known)
+		alsoFollowing = util.HashIntersectUsers(user.RelationsUser.Following, known)
+	}
+
+	objects := userObjects(user, objectsToFetch, feedObjectsToFetch, feedObjectsOffset)
+	return schema.FormatAsUserType(user, alsoFollowedBy, alsoFollowing, objectsToFetch, objects, isViewer)
+}
@@ -1,10 +1,9 @@
 package database
 
 import (
-	"errors"
-	"fmt"
 	"time"
 
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/cdn"
 	"github.com/soumitradev/Dwitter/backend/common"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
@@ -15,27 +14,27 @@ import (
 // Update a dweet
 func UpdateDweet(postID string, username string, body string, mediaLinks []string, repliesToFetch int, replyOffset int) (schema.DweetType, error) {
 	// Validate params
-	err := common.Validate.Var(postID, "required,alphanum,len=10")
+	err := common.ValidateVar("id", postID, "required,alphanum,len=10")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err = common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(body, "lte=240")
+	err = common.ValidateVar("body", body, "lte=240")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(mediaLinks, "lte=8,dive,required,url")
+	err = common.ValidateVar("media", mediaLinks, "lte=8,dive,required,url")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
 
-	err = common.Validate.Var(replyOffset, "gte=0")
+	err = common.ValidateVar("repliesOffset", replyOffset, "gte=0")
 	if err != nil {
 		return schema.DweetType{}, err
 	}
@@ -46,15 +45,15 @@ func UpdateDweet(postID string, username string, body string, mediaLinks []strin
 		db.Dweet.Author.Fetch(),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	// Check if user owns dweet
 	if post.Author().Username != username {
-		return schema.DweetType{}, fmt.Errorf("authorization error: %v", errors.New("not authorized to edit dweet"))
+		return schema.DweetType{}, apierr.NewForbidden("authorization error: not authorized to edit dweet")
 	}
 
 	// Delete the media that isn't used anymore
@@ -122,10 +121,10 @@ func UpdateDweet(postID string, username string, body string, mediaLinks []strin
 		).Exec(common.BaseCtx)
 	}
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("dweet not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("dweet not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	// Mark media as used to prevent auto-deletion on expiry
@@ -142,10 +141,10 @@ func UpdateDweet(postID string, username string, body string, mediaLinks []strin
 		),
 	).Exec(common.BaseCtx)
 	if err == db.ErrNotFound {
-		return schema.DweetType{}, fmt.Errorf("user not found: %v", err)
+		return schema.DweetType{}, apierr.NewNotFound("user not found")
 	}
 	if err != nil {
-		return schema.DweetType{}, fmt.Errorf("internal server error: %v", err)
+		return schema.DweetType{}, apierr.NewInternal(err)
 	}
 
 	mutualLikes := util.HashIntersectUsers(user.Following(), post.LikeUsers())
@@ -155,10 +154,10 @@ func UpdateDweet(postID string, username string, body string, mediaLinks []strin
 	return npost, err
 }
 
-// Update a user
-func UpdateUser(username string, name string, email string, bio string, PfpUrl string, followersToFetch int, followersOffset int, followingToFetch int, followingOffset int, objectsToFetch string, feedObjectsToFetch int, feedObjectsOffset int) (schema.UserType, error) {
+// Update a user's profile. Emails are changed with auth.RequestEmailChange instead, since the new one has to be confirmed.
+func UpdateUser(username string, name string, bio string, PfpUrl string, followersToFetch int, followersOffset int, followingToFetch int, followingOffset int, objectsToFetch string, feedObjectsToFetch int, feedObjectsOffset int) (schema.UserType, error) {
 	// Validate params
-	err := common.Validate.Var(username, "required,alphanum,lte=20,gt=0")
+	err := common.ValidateVar("username", username, "required,alphanum,lte=20,gt=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
@@ -172,49 +171,41 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 	if name == "" {
 		name = basicUser.Name
 	}
-	if email == "" {
-		email = basicUser.Email
-	}
 	if PfpUrl == "" {
 		PfpUrl = basicUser.ProfilePicURL
 	}
 
-	err = common.Validate.Var(name, "lte=80")
+	err = common.ValidateVar("name", name, "lte=80")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(email, "email,lte=100")
+	err = common.ValidateVar("bio", bio, "lte=160")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(bio, "lte=160")
+	err = common.ValidateVar("pfpURL", PfpUrl, "url")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(PfpUrl, "url")
+	err = common.ValidateVar("objectsToFetch", objectsToFetch, "required,alpha,gt=0,oneof=feed dweet redweet redweetedDweet liked")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(objectsToFetch, "required,alpha,gt=0,oneof=feed dweet redweet redweetedDweet liked")
+	err = common.ValidateVar("followersOffset", followersOffset, "gte=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(followersOffset, "gte=0")
+	err = common.ValidateVar("followingOffset", followingOffset, "gte=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
 
-	err = common.Validate.Var(followingOffset, "gte=0")
-	if err != nil {
-		return schema.UserType{}, err
-	}
-
-	err = common.Validate.Var(feedObjectsOffset, "gte=0")
+	err = common.ValidateVar("feedObjectsOffset", feedObjectsOffset, "gte=0")
 	if err != nil {
 		return schema.UserType{}, err
 	}
@@ -250,15 +241,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -281,15 +271,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					dweets := user.Dweets()
@@ -314,15 +303,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweets := user.Redweets()
@@ -346,15 +334,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweetedDweets := user.RedweetedDweets()
@@ -378,15 +365,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					likes := user.LikedDweets()
@@ -421,15 +407,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -454,15 +439,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					dweets := user.Dweets()
@@ -487,15 +471,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweets := user.Redweets()
@@ -519,15 +502,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweetedDweets := user.RedweetedDweets()
@@ -551,15 +533,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					likes := user.LikedDweets()
@@ -596,15 +577,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -627,15 +607,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					dweets := user.Dweets()
@@ -660,15 +639,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweets := user.Redweets()
@@ -692,15 +670,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweetedDweets := user.RedweetedDweets()
@@ -724,15 +701,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					likes := user.LikedDweets()
@@ -767,15 +743,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -800,15 +775,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					dweets := user.Dweets()
@@ -833,15 +807,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweets := user.Redweets()
@@ -865,15 +838,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweetedDweets := user.RedweetedDweets()
@@ -897,15 +869,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					likes := user.LikedDweets()
@@ -945,15 +916,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -976,15 +946,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					dweets := user.Dweets()
@@ -1009,15 +978,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweets := user.Redweets()
@@ -1041,15 +1009,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweetedDweets := user.RedweetedDweets()
@@ -1073,15 +1040,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					likes := user.LikedDweets()
@@ -1116,15 +1082,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -1149,15 +1114,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					dweets := user.Dweets()
@@ -1182,15 +1146,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweets := user.Redweets()
@@ -1214,15 +1177,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweetedDweets := user.RedweetedDweets()
@@ -1246,15 +1208,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					likes := user.LikedDweets()
@@ -1291,15 +1252,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -1322,15 +1282,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					dweets := user.Dweets()
@@ -1355,15 +1314,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweets := user.Redweets()
@@ -1387,15 +1345,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweetedDweets := user.RedweetedDweets()
@@ -1419,15 +1376,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					likes := user.LikedDweets()
@@ -1462,15 +1418,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					merged := util.MergeDweetRedweetList(user.Dweets(), user.Redweets())
@@ -1495,15 +1450,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					dweets := user.Dweets()
@@ -1528,15 +1482,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweets := user.Redweets()
@@ -1560,15 +1513,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					redweetedDweets := user.RedweetedDweets()
@@ -1592,15 +1544,14 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 						).Take(followingToFetch).Skip(followingOffset),
 					).Update(
 						db.User.Name.Set(name),
-						db.User.Email.Set(email),
 						db.User.Bio.Set(bio),
 						db.User.ProfilePicURL.Set(PfpUrl),
 					).Exec(common.BaseCtx)
 					if err == db.ErrNotFound {
-						return schema.UserType{}, fmt.Errorf("user not found: %v", err)
+						return schema.UserType{}, apierr.NewNotFound("user not found")
 					}
 					if err != nil {
-						return schema.UserType{}, fmt.Errorf("internal server error: %v", err)
+						return schema.UserType{}, apierr.NewInternal(err)
 					}
 
 					likes := user.LikedDweets()
@@ -1617,3 +1568,19 @@ func UpdateUser(username string, name string, email string, bio string, PfpUrl s
 	nuser, err := schema.FormatAsUserType(user, user.Followers(), user.Following(), objectsToFetch, feedObjectList, true)
 	return nuser, err
 }
+
+// Mark a user as a bot or not. Dweets from bots are marked as automated.
+func SetBotAccount(username string, isBot bool) (bool, error) {
+	_, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).Update(
+		db.User.IsBot.Set(isBot),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound {
+		return false, apierr.NewNotFound("user not found")
+	}
+	if err != nil {
+		return false, apierr.NewInternal(err)
+	}
+	return isBot, nil
+}
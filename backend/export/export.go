@@ -0,0 +1,485 @@
+// Package export builds archives of a user's personal data for them to download.
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/cdn"
+	"github.com/soumitradev/Dwitter/backend/common"
+	"github.com/soumitradev/Dwitter/backend/mailer"
+	"github.com/soumitradev/Dwitter/backend/prisma/db"
+	"github.com/soumitradev/Dwitter/backend/schema"
+	"github.com/soumitradev/Dwitter/backend/util"
+)
+
+// Statuses of an export
+const (
+	StatusPending = "pending"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+// How often a user can request an export
+const exportCooldown = time.Hour * 24
+
+// How long an export can be downloaded for after it is requested
+const exportExpiry = time.Hour * 24 * 7
+
+// Longest an export can take to build before it is given up on
+const exportTimeout = time.Minute * 30
+
+// Tell a user their export is ready
+func SendExportReadyEmail(emailID string, link string) error {
+	return mailer.Send(emailID, "export_ready", map[string]interface{}{
+		"Link": link,
+	})
+}
+
+// Where an export is stored in the bucket
+func objectLocation(exportID string) string {
+	return "exports/" + exportID + ".zip"
+}
+
+func formatDataExport(export *db.DataExportModel) schema.DataExportType {
+	formatted := schema.DataExportType{
+		ID:        export.DbID,
+		Status:    export.Status,
+		CreatedAt: export.CreatedAt,
+		ExpiresAt: export.ExpiresAt,
+	}
+	if completedAt, completed := export.CompletedAt(); completed {
+		formatted.CompletedAt = &completedAt
+	}
+	return formatted
+}
+
+// Start building an export of a user's data. A link to it is emailed to them once it is ready.
+func RequestExport(username string) (schema.DataExportType, error) {
+	// Claiming the day's export in one update means two requests at once can't both get one
+	// Postgres keeps times to the millisecond, so keep it the same here for releaseCooldown to match it
+	requestedAt := time.Now().Truncate(time.Millisecond)
+	result, err := common.Client.User.FindMany(
+		db.User.Username.Equals(username),
+		db.User.Or(
+			db.User.ExportRequestedAt.IsNull(),
+			db.User.ExportRequestedAt.Before(requestedAt.Add(-exportCooldown)),
+		),
+	).Update(
+		db.User.ExportRequestedAt.Set(requestedAt),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return schema.DataExportType{}, apierr.NewInternal(err)
+	}
+	if result.Count == 0 {
+		user, err := common.Client.User.FindUnique(
+			db.User.Username.Equals(username),
+		).Exec(common.BaseCtx)
+		if err == db.ErrNotFound {
+			return schema.DataExportType{}, apierr.NewNotFound("user not found")
+		}
+		if err != nil {
+			return schema.DataExportType{}, apierr.NewInternal(err)
+		}
+		lastRequestedAt, _ := user.ExportRequestedAt()
+		wait := time.Until(lastRequestedAt.Add(exportCooldown)).Round(time.Minute)
+		return schema.DataExportType{}, apierr.NewRateLimited("you can only request one export a day, try again in %v", wait)
+	}
+
+	export, err := common.Client.DataExport.CreateOne(
+		db.DataExport.User.Link(
+			db.User.Username.Equals(username),
+		),
+		db.DataExport.ExpiresAt.Set(requestedAt.Add(exportExpiry)),
+		db.DataExport.CreatedAt.Set(requestedAt),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		releaseCooldown(username, requestedAt)
+		return schema.DataExportType{}, apierr.NewInternal(err)
+	}
+
+	go runExport(export.DbID, username)
+
+	return formatDataExport(export), nil
+}
+
+// List a user's exports
+func ListExports(username string) ([]schema.DataExportType, error) {
+	exports, err := common.Client.DataExport.FindMany(
+		db.DataExport.UserID.Equals(username),
+	).OrderBy(
+		db.DataExport.CreatedAt.Order(db.DESC),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return nil, apierr.NewInternal(err)
+	}
+
+	exportList := make([]schema.DataExportType, 0, len(exports))
+	for i := range exports {
+		exportList = append(exportList, formatDataExport(&exports[i]))
+	}
+	return exportList, nil
+}
+
+// Let a user request another export right away, as long as requestedAt is still their latest request
+func releaseCooldown(username string, requestedAt time.Time) {
+	_, err := common.Client.User.FindMany(
+		db.User.Username.Equals(username),
+		db.User.ExportRequestedAt.Equals(requestedAt),
+	).Update(
+		db.User.ExportRequestedAt.SetOptional(nil),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		fmt.Printf("Error resetting export cooldown: %v\n", err)
+	}
+}
+
+// Mark an export as failed. Failed exports don't count towards the daily limit, so users can try again right away.
+func failExport(export *db.DataExportModel) {
+	_, err := common.Client.DataExport.FindUnique(
+		db.DataExport.DbID.Equals(export.DbID),
+	).Update(
+		db.DataExport.Status.Set(StatusFailed),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		fmt.Printf("Error updating export: %v\n", err)
+	}
+	releaseCooldown(export.UserID, export.CreatedAt)
+}
+
+// Build an export, and email the user a link to it
+func runExport(exportID string, username string) {
+	ctx, cancel := context.WithTimeout(common.BaseCtx, exportTimeout)
+	defer cancel()
+
+	export, err := common.Client.DataExport.FindUnique(
+		db.DataExport.DbID.Equals(exportID),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		// Without the export there is nothing to mark as failed, and FailStaleExports will pick it up
+		fmt.Printf("Error finding export: %v\n", err)
+		return
+	}
+
+	user, err := buildArchive(ctx, exportID, username)
+	if err != nil {
+		fmt.Printf("Error building export: %v\n", err)
+		failExport(export)
+		return
+	}
+
+	token, err := util.GenSecureToken(32)
+	if err != nil {
+		fmt.Printf("Error generating export token: %v\n", err)
+		failExport(export)
+		return
+	}
+	_, err = common.Client.DataExport.FindUnique(
+		db.DataExport.DbID.Equals(exportID),
+	).Update(
+		db.DataExport.Status.Set(StatusReady),
+		db.DataExport.TokenHash.Set(util.HashToken(token)),
+		db.DataExport.CompletedAt.Set(time.Now()),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		fmt.Printf("Error updating export: %v\n", err)
+		failExport(export)
+		return
+	}
+
+	link := "http://localhost:5000/api/export/" + token
+	err = SendExportReadyEmail(user.Email, link)
+	if err != nil {
+		fmt.Printf("Error sending export email: %v\n", err)
+	}
+}
+
+// Write a JSON file to an archive
+func writeJSON(archive *zip.Writer, name string, data interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// Copy a file from the bucket to an archive. Links that aren't to our bucket, like avatars from OAuth providers, are skipped.
+func writeMedia(ctx context.Context, archive *zip.Writer, link string) error {
+	location, err := cdn.LinkToLocation(link)
+	if err != nil {
+		return nil
+	}
+	reader, err := common.Bucket.Object(location).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("Object(%q).NewReader: %v", location, err)
+	}
+	defer reader.Close()
+
+	file, err := archive.Create(location)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	return err
+}
+
+// Build the archive for an export and upload it to the bucket
+func buildArchive(ctx context.Context, exportID string, username string) (*db.UserModel, error) {
+	user, err := common.Client.User.FindUnique(
+		db.User.Username.Equals(username),
+	).With(
+		db.User.Dweets.Fetch().With(
+			db.Dweet.Author.Fetch(),
+			db.Dweet.ReplyTo.Fetch().With(
+				db.Dweet.Author.Fetch(),
+			),
+			db.Dweet.ReplyDweets.Fetch().With(
+				db.Dweet.Author.Fetch(),
+			),
+			db.Dweet.LikeUsers.Fetch(),
+			db.Dweet.RedweetUsers.Fetch(),
+		).OrderBy(
+			db.Dweet.PostedAt.Order(db.DESC),
+		),
+		db.User.Redweets.Fetch().With(
+			db.Redweet.Author.Fetch(),
+			db.Redweet.RedweetOf.Fetch().With(
+				db.Dweet.Author.Fetch(),
+			),
+		).OrderBy(
+			db.Redweet.RedweetTime.Order(db.DESC),
+		),
+		db.User.LikedDweets.Fetch().With(
+			db.Dweet.Author.Fetch(),
+		).OrderBy(
+			db.Dweet.PostedAt.Order(db.DESC),
+		),
+		db.User.Followers.Fetch(),
+		db.User.Following.Fetch(),
+	).Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadCtx, cancelUpload := context.WithCancel(ctx)
+	defer cancelUpload()
+	writer := common.Bucket.Object(objectLocation(exportID)).NewWriter(uploadCtx)
+	writer.ContentType = "application/zip"
+	archive := zip.NewWriter(writer)
+
+	err = writeArchive(ctx, archive, user)
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		// Closing the writer after cancelling its context throws away what was uploaded
+		cancelUpload()
+		writer.Close()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Write a user's data to an archive
+func writeArchive(ctx context.Context, archive *zip.Writer, user *db.UserModel) error {
+	profile, err := schema.FormatAsUserType(user, nil, nil, "", nil, true)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "profile.json", profile); err != nil {
+		return err
+	}
+
+	// Dweets include replies, since those are dweets too
+	dweets := make([]schema.DweetType, 0, len(user.Dweets()))
+	for _, dweet := range user.Dweets() {
+		formatted := schema.FormatAsDweetType(&dweet, dweet.LikeUsers(), dweet.RedweetUsers())
+		hideDweetEmails(&formatted, user.Username)
+		dweets = append(dweets, formatted)
+	}
+	if err := writeJSON(archive, "dweets.json", dweets); err != nil {
+		return err
+	}
+
+	redweets := make([]schema.RedweetType, 0, len(user.Redweets()))
+	for _, redweet := range user.Redweets() {
+		formatted := schema.FormatAsRedweetType(&redweet)
+		hideBasicDweetEmails(&formatted.RedweetOf, user.Username)
+		redweets = append(redweets, formatted)
+	}
+	if err := writeJSON(archive, "redweets.json", redweets); err != nil {
+		return err
+	}
+
+	likes := make([]schema.BasicDweetType, 0, len(user.LikedDweets()))
+	for _, dweet := range user.LikedDweets() {
+		formatted := schema.FormatAsBasicDweetType(&dweet)
+		hideBasicDweetEmails(&formatted, user.Username)
+		likes = append(likes, formatted)
+	}
+	if err := writeJSON(archive, "likes.json", likes); err != nil {
+		return err
+	}
+
+	if err := writeJSON(archive, "followers.json", formatOtherUsers(user.Followers())); err != nil {
+		return err
+	}
+	if err := writeJSON(archive, "following.json", formatOtherUsers(user.Following())); err != nil {
+		return err
+	}
+
+	// Copy over the files the user uploaded
+	if user.ProfilePicURL != common.DefaultPFPURL {
+		if err := writeMedia(ctx, archive, user.ProfilePicURL); err != nil {
+			return err
+		}
+	}
+	for _, dweet := range user.Dweets() {
+		for _, mediaLink := range dweet.Media {
+			if err := writeMedia(ctx, archive, mediaLink); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Format other users for an export, without their emails
+func formatOtherUsers(users []db.UserModel) []schema.BasicUserType {
+	formatted := make([]schema.BasicUserType, 0, len(users))
+	for i := range users {
+		basicUser := schema.FormatAsBasicUserType(&users[i])
+		basicUser.Email = ""
+		formatted = append(formatted, basicUser)
+	}
+	return formatted
+}
+
+// Remove the emails of users other than the one exporting their data
+func hideUserEmail(user *schema.BasicUserType, username string) {
+	if user.Username != username {
+		user.Email = ""
+	}
+}
+
+func hideBasicDweetEmails(dweet *schema.BasicDweetType, username string) {
+	hideUserEmail(&dweet.Author, username)
+}
+
+func hideDweetEmails(dweet *schema.DweetType, username string) {
+	hideUserEmail(&dweet.Author, username)
+	hideBasicDweetEmails(&dweet.ReplyTo, username)
+	for i := range dweet.ReplyDweets {
+		hideBasicDweetEmails(&dweet.ReplyDweets[i], username)
+	}
+	for i := range dweet.LikeUsers {
+		hideUserEmail(&dweet.LikeUsers[i], username)
+	}
+	for i := range dweet.RedweetUsers {
+		hideUserEmail(&dweet.RedweetUsers[i], username)
+	}
+}
+
+// Handles downloads of exports using a token from the link emailed to the user
+func DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	export, err := common.Client.DataExport.FindUnique(
+		db.DataExport.TokenHash.Equals(util.HashToken(token)),
+	).Exec(common.BaseCtx)
+	if err == db.ErrNotFound || (err == nil && (export.Status != StatusReady || time.Now().After(export.ExpiresAt))) {
+		http.Error(w, "Unrecognized or expired download link", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	reader, err := common.Bucket.Object(objectLocation(export.DbID)).NewReader(r.Context())
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"dwitter-"+export.UserID+".zip\"")
+	w.Header().Set("Cache-Control", "no-store")
+	// The headers are already sent, so all that can be done about a failed download is to log it
+	_, err = io.Copy(w, reader)
+	if err != nil {
+		fmt.Printf("Error sending export: %v\n", err)
+	}
+}
+
+// Mark exports that have been pending for longer than an export can take as failed.
+// Exports are built in the background, so these were being built when the server stopped.
+func FailStaleExports() error {
+	exports, err := common.Client.DataExport.FindMany(
+		db.DataExport.Status.Equals(StatusPending),
+		db.DataExport.CreatedAt.Before(time.Now().Add(-exportTimeout)),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return err
+	}
+	for i := range exports {
+		failExport(&exports[i])
+	}
+	return nil
+}
+
+// Delete exports that can't be downloaded anymore, along with their archives
+func DeleteExpiredExports() error {
+	exports, err := common.Client.DataExport.FindMany(
+		db.DataExport.ExpiresAt.Before(time.Now()),
+	).Exec(common.BaseCtx)
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if export.Status == StatusReady {
+			// The archive may never have been uploaded, or already be gone
+			err := cdn.DeleteLocation(objectLocation(export.DbID), false)
+			if err != nil && err.Error() != "media not found" {
+				fmt.Printf("Error deleting export: %v\n", err)
+				continue
+			}
+		}
+		_, err := common.Client.DataExport.FindUnique(
+			db.DataExport.DbID.Equals(export.DbID),
+		).Delete().Exec(common.BaseCtx)
+		if err != nil {
+			fmt.Printf("Error deleting export: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// Clean up stale and expired exports right away, and then again every interval
+func SweepExpiredExports(interval time.Duration) {
+	for {
+		err := FailStaleExports()
+		if err != nil {
+			fmt.Printf("Error sweeping stale exports: %v\n", err)
+		}
+		err = DeleteExpiredExports()
+		if err != nil {
+			fmt.Printf("Error sweeping exports: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
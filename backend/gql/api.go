@@ -2,10 +2,13 @@
 package gql
 
 import (
-	"errors"
+	"net/http"
+	"time"
 
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/auth"
 	"github.com/soumitradev/Dwitter/backend/database"
+	"github.com/soumitradev/Dwitter/backend/export"
 	"github.com/soumitradev/Dwitter/backend/schema"
 
 	"github.com/graphql-go/graphql"
@@ -25,45 +28,32 @@ var queryHandler = graphql.NewObject(
 					},
 					"repliesToFetch": &graphql.ArgumentConfig{
 						Type:         graphql.Int,
+						Description:  "Deprecated: use repliesConnection",
 						DefaultValue: 0,
 					},
 					"repliesOffset": &graphql.ArgumentConfig{
 						Type:         graphql.Int,
+						Description:  "Deprecated: use repliesConnection",
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						id, idPresent := params.Args["id"].(string)
-						numReplies, numPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
-						if idPresent && numPresent && offsetPresent {
-							post, err := database.GetPost(id, numReplies, replyOffset, data["username"].(string))
-							return post, err
-						}
-					} else {
-						id, idPresent := params.Args["id"].(string)
-						numReplies, numPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
-						if idPresent && numPresent && offsetPresent {
-							post, err := database.GetPostUnauth(id, numReplies, replyOffset)
-							return post, err
-						}
+				Resolve: optionalAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					id, idPresent := params.Args["id"].(string)
+					numReplies, numPresent := params.Args["repliesToFetch"].(int)
+					replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
+					if idPresent && numPresent && offsetPresent {
+						post, err := database.GetPost(id, numReplies, replyOffset, viewer.Username, selectionOf(params))
+						return post, err
 					}
 
-					return nil, errors.New("param \"id\" or missing")
-				},
+					return nil, apierr.NewValidation("id", "param \"id\" missing")
+				}),
 			},
 			// TODO: Advanced search
 			"dweets": &graphql.Field{
-				Type:        graphql.NewList(schema.DweetSchema),
-				Description: "Search dweets by content",
+				Type:              graphql.NewList(schema.DweetSchema),
+				Description:       "Search dweets by content",
+				DeprecationReason: "Use dweetsConnection, offsets skip or repeat items when the list changes between pages",
 				Args: graphql.FieldConfigArgument{
 					"text": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.String),
@@ -85,37 +75,19 @@ var queryHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						txt, txtPresent := params.Args["text"].(string)
-						num, numPresent := params.Args["dweetsToFetch"].(int)
-						numOffset, numOffsetPresent := params.Args["dweetsOffset"].(int)
-						numReplies, numRepliesPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, replyOffsetPresent := params.Args["repliesOffset"].(int)
-						if txtPresent && numPresent && numOffsetPresent && numRepliesPresent && replyOffsetPresent {
-							posts, err := database.SearchPosts(txt, num, numOffset, numReplies, replyOffset, data["username"].(string))
-							return posts, err
-						}
-					} else {
-						txt, txtPresent := params.Args["text"].(string)
-						num, numPresent := params.Args["dweetsToFetch"].(int)
-						numOffset, numOffsetPresent := params.Args["dweetsOffset"].(int)
-						numReplies, numRepliesPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, replyOffsetPresent := params.Args["repliesOffset"].(int)
-						if txtPresent && numPresent && numOffsetPresent && numRepliesPresent && replyOffsetPresent {
-							posts, err := database.SearchPostsUnauth(txt, num, numOffset, numReplies, replyOffset)
-							return posts, err
-						}
+				Resolve: optionalAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					txt, txtPresent := params.Args["text"].(string)
+					num, numPresent := params.Args["dweetsToFetch"].(int)
+					numOffset, numOffsetPresent := params.Args["dweetsOffset"].(int)
+					numReplies, numRepliesPresent := params.Args["repliesToFetch"].(int)
+					replyOffset, replyOffsetPresent := params.Args["repliesOffset"].(int)
+					if txtPresent && numPresent && numOffsetPresent && numRepliesPresent && replyOffsetPresent {
+						posts, err := database.SearchPosts(txt, num, numOffset, numReplies, replyOffset, viewer.Username, selectionOf(params))
+						return posts, err
 					}
 
-					return nil, errors.New("param \"text\" missing")
-				},
+					return nil, apierr.NewValidation("text", "param \"text\" missing")
+				}),
 			},
 			"user": &graphql.Field{
 				Type:        schema.UserSchema,
@@ -130,47 +102,33 @@ var queryHandler = graphql.NewObject(
 					},
 					"feedObjectsToFetch": &graphql.ArgumentConfig{
 						Type:         graphql.Int,
+						Description:  "Deprecated: use feedObjectsConnection",
 						DefaultValue: 0,
 					},
 					"feedObjectsOffset": &graphql.ArgumentConfig{
 						Type:         graphql.Int,
+						Description:  "Deprecated: use feedObjectsConnection",
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						username, userPresent := params.Args["username"].(string)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
-						if userPresent && objectsToFetchPresent && numPresent && feedObjectsOffsetPresent {
-							user, err := database.GetUser(username, objectsToFetch, numFeedObjects, feedObjectsOffset, data["username"].(string))
-							return user, err
-						}
-					} else {
-						username, userPresent := params.Args["username"].(string)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
-						if userPresent && objectsToFetchPresent && numPresent && feedObjectsOffsetPresent {
-							user, err := database.GetUserUnauth(username, objectsToFetch, numFeedObjects, feedObjectsOffset)
-							return user, err
-						}
+				Resolve: optionalAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					username, userPresent := params.Args["username"].(string)
+					objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
+					numFeedObjects, numPresent := params.Args["feedObjectsToFetch"].(int)
+					feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
+					if userPresent && objectsToFetchPresent && numPresent && feedObjectsOffsetPresent {
+						user, err := database.GetUser(username, objectsToFetch, numFeedObjects, feedObjectsOffset, viewer.Username, selectionOf(params))
+						return user, err
 					}
 
-					return nil, errors.New("param \"username\" missing")
-				},
+					return nil, apierr.NewValidation("username", "param \"username\" missing")
+				}),
 			},
 			// TODO: Advanced search
 			"users": &graphql.Field{
-				Type:        graphql.NewList(schema.UserSchema),
-				Description: "Search users by username",
+				Type:              graphql.NewList(schema.UserSchema),
+				Description:       "Search users by username",
+				DeprecationReason: "Use usersConnection, offsets skip or repeat items when the list changes between pages",
 				Args: graphql.FieldConfigArgument{
 					"text": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.String),
@@ -196,43 +154,25 @@ var queryHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						txt, txtPresent := params.Args["text"].(string)
-						num, numPresent := params.Args["numberToFetch"].(int)
-						numOffset, numOffsetPresent := params.Args["numberOffset"].(int)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
-						if txtPresent && numPresent && numOffsetPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
-							posts, err := database.SearchUsers(txt, num, numOffset, objectsToFetch, numFeedObjects, feedObjectsOffset, data["username"].(string))
-							return posts, err
-						}
-					} else {
-						txt, txtPresent := params.Args["text"].(string)
-						num, numPresent := params.Args["numberToFetch"].(int)
-						numOffset, numOffsetPresent := params.Args["numberOffset"].(int)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
-						if txtPresent && numPresent && numOffsetPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
-							posts, err := database.SearchUsersUnauth(txt, num, numOffset, objectsToFetch, numFeedObjects, feedObjectsOffset)
-							return posts, err
-						}
+				Resolve: optionalAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					txt, txtPresent := params.Args["text"].(string)
+					num, numPresent := params.Args["numberToFetch"].(int)
+					numOffset, numOffsetPresent := params.Args["numberOffset"].(int)
+					objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
+					numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
+					feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
+					if txtPresent && numPresent && numOffsetPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
+						users, err := database.SearchUsers(txt, num, numOffset, objectsToFetch, numFeedObjects, feedObjectsOffset, viewer.Username, selectionOf(params))
+						return users, err
 					}
 
-					return nil, errors.New("param \"text\" missing")
-				},
+					return nil, apierr.NewValidation("text", "param \"text\" missing")
+				}),
 			},
 			"likedDweets": &graphql.Field{
-				Type:        graphql.NewList(schema.DweetSchema),
-				Description: "Get liked dweets of authenticated user",
+				Type:              graphql.NewList(schema.DweetSchema),
+				Description:       "Get liked dweets of authenticated user",
+				DeprecationReason: "Use likedDweetsConnection, offsets skip or repeat items when the list changes between pages",
 				Args: graphql.FieldConfigArgument{
 					"numberToFetch": &graphql.ArgumentConfig{
 						Type:         graphql.Int,
@@ -251,30 +191,22 @@ var queryHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						numDweets, dweetPresent := params.Args["numberToFetch"].(int)
-						numOffset, numOffsetPresent := params.Args["numberOffset"].(int)
-						numReplies, repliesPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, replyOffsetPresent := params.Args["repliesOffset"].(int)
-						if dweetPresent && repliesPresent && numOffsetPresent && replyOffsetPresent {
-							post, err := database.GetLikedDweets(data["username"].(string), numDweets, numOffset, numReplies, replyOffset)
-							return post, err
-						}
+				Resolve: requireAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					numDweets, dweetPresent := params.Args["numberToFetch"].(int)
+					numOffset, numOffsetPresent := params.Args["numberOffset"].(int)
+					numReplies, repliesPresent := params.Args["repliesToFetch"].(int)
+					replyOffset, replyOffsetPresent := params.Args["repliesOffset"].(int)
+					if dweetPresent && repliesPresent && numOffsetPresent && replyOffsetPresent {
+						post, err := database.GetLikedDweets(viewer.Username, numDweets, numOffset, numReplies, replyOffset, selectionOf(params))
+						return post, err
 					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"followers": &graphql.Field{
-				Type:        graphql.NewList(schema.UserSchema),
-				Description: "Get followers of authenticated user",
+				Type:              graphql.NewList(schema.UserSchema),
+				Description:       "Get followers of authenticated user",
+				DeprecationReason: "Use followersConnection, offsets skip or repeat items when the list changes between pages",
 				Args: graphql.FieldConfigArgument{
 					"numberToFetch": &graphql.ArgumentConfig{
 						Type:         graphql.Int,
@@ -297,31 +229,23 @@ var queryHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						numUsers, usersPresent := params.Args["numberToFetch"].(int)
-						numOffset, usersOffsetPresent := params.Args["numberOffset"].(int)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
-						if usersPresent && usersOffsetPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
-							post, err := database.GetFollowers(data["username"].(string), numUsers, numOffset, objectsToFetch, numFeedObjects, feedObjectsOffset)
-							return post, err
-						}
+				Resolve: requireAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					numUsers, usersPresent := params.Args["numberToFetch"].(int)
+					numOffset, usersOffsetPresent := params.Args["numberOffset"].(int)
+					objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
+					numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
+					feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
+					if usersPresent && usersOffsetPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
+						post, err := database.GetFollowers(viewer.Username, numUsers, numOffset, objectsToFetch, numFeedObjects, feedObjectsOffset, selectionOf(params))
+						return post, err
 					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"following": &graphql.Field{
-				Type:        graphql.NewList(schema.UserSchema),
-				Description: "Get users that authenticated user follows",
+				Type:              graphql.NewList(schema.UserSchema),
+				Description:       "Get users that authenticated user follows",
+				DeprecationReason: "Use followingConnection, offsets skip or repeat items when the list changes between pages",
 				Args: graphql.FieldConfigArgument{
 					"numberToFetch": &graphql.ArgumentConfig{
 						Type:         graphql.Int,
@@ -344,26 +268,239 @@ var queryHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-					if isAuth {
-						numUsers, usersPresent := params.Args["numberToFetch"].(int)
-						numOffset, usersOffsetPresent := params.Args["numberOffset"].(int)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
-						if usersPresent && usersOffsetPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
-							post, err := database.GetFollowing(data["username"].(string), numUsers, numOffset, objectsToFetch, numFeedObjects, feedObjectsOffset)
-							return post, err
-						}
+				Resolve: requireAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					numUsers, usersPresent := params.Args["numberToFetch"].(int)
+					numOffset, usersOffsetPresent := params.Args["numberOffset"].(int)
+					objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
+					numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
+					feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
+					if usersPresent && usersOffsetPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
+						post, err := database.GetFollowing(viewer.Username, numUsers, numOffset, objectsToFetch, numFeedObjects, feedObjectsOffset, selectionOf(params))
+						return post, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"dweetsConnection": &graphql.Field{
+				Type:        schema.DweetConnectionSchema,
+				Description: "Search dweets by content, newest first, a page at a time",
+				Args: graphql.FieldConfigArgument{
+					"text": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"first": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: database.DefaultPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "",
+					},
+				},
+				Resolve: optionalAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					txt, txtPresent := params.Args["text"].(string)
+					first, firstPresent := params.Args["first"].(int)
+					after, afterPresent := params.Args["after"].(string)
+					if txtPresent && firstPresent && afterPresent {
+						posts, err := database.SearchPostsConnection(txt, first, after)
+						return posts, err
 					}
 
-					return nil, errors.New("Unauthorized")
+					return nil, errMissingArgument
+				}),
+			},
+			"repliesConnection": &graphql.Field{
+				Type:        schema.DweetConnectionSchema,
+				Description: "Get the replies to a dweet, newest first, a page at a time",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"first": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: database.DefaultPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "",
+					},
 				},
+				Resolve: optionalAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					id, idPresent := params.Args["id"].(string)
+					first, firstPresent := params.Args["first"].(int)
+					after, afterPresent := params.Args["after"].(string)
+					if idPresent && firstPresent && afterPresent {
+						replies, err := database.GetRepliesConnection(id, first, after)
+						return replies, err
+					}
+
+					return nil, errMissingArgument
+				}),
+			},
+			"feedObjectsConnection": &graphql.Field{
+				Type:        schema.FeedObjectConnectionSchema,
+				Description: "Get the dweets and redweets of a user, newest first, a page at a time",
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"first": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: database.DefaultPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "",
+					},
+				},
+				Resolve: optionalAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					username, userPresent := params.Args["username"].(string)
+					first, firstPresent := params.Args["first"].(int)
+					after, afterPresent := params.Args["after"].(string)
+					if userPresent && firstPresent && afterPresent {
+						feedObjects, err := database.GetFeedObjectsConnection(username, first, after)
+						return feedObjects, err
+					}
+
+					return nil, errMissingArgument
+				}),
+			},
+			"usersConnection": &graphql.Field{
+				Type:        schema.UserConnectionSchema,
+				Description: "Search users by username, newest accounts first, a page at a time",
+				Args: graphql.FieldConfigArgument{
+					"text": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"first": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: database.DefaultPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "",
+					},
+				},
+				Resolve: optionalAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					txt, txtPresent := params.Args["text"].(string)
+					first, firstPresent := params.Args["first"].(int)
+					after, afterPresent := params.Args["after"].(string)
+					if txtPresent && firstPresent && afterPresent {
+						users, err := database.SearchUsersConnection(txt, first, after)
+						return users, err
+					}
+
+					return nil, errMissingArgument
+				}),
+			},
+			"likedDweetsConnection": &graphql.Field{
+				Type:        schema.DweetConnectionSchema,
+				Description: "Get liked dweets of authenticated user, newest first, a page at a time",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: database.DefaultPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "",
+					},
+				},
+				Resolve: requireAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					first, firstPresent := params.Args["first"].(int)
+					after, afterPresent := params.Args["after"].(string)
+					if firstPresent && afterPresent {
+						page, err := database.GetLikedDweetsConnection(viewer.Username, first, after)
+						return page, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"followersConnection": &graphql.Field{
+				Type:        schema.UserConnectionSchema,
+				Description: "Get followers of authenticated user, newest accounts first, a page at a time",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: database.DefaultPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "",
+					},
+				},
+				Resolve: requireAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					first, firstPresent := params.Args["first"].(int)
+					after, afterPresent := params.Args["after"].(string)
+					if firstPresent && afterPresent {
+						page, err := database.GetFollowersConnection(viewer.Username, first, after)
+						return page, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"followingConnection": &graphql.Field{
+				Type:        schema.UserConnectionSchema,
+				Description: "Get users that authenticated user follows, newest accounts first, a page at a time",
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: database.DefaultPageSize,
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "",
+					},
+				},
+				Resolve: requireAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					first, firstPresent := params.Args["first"].(int)
+					after, afterPresent := params.Args["after"].(string)
+					if firstPresent && afterPresent {
+						page, err := database.GetFollowingConnection(viewer.Username, first, after)
+						return page, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"sessions": &graphql.Field{
+				Type:        graphql.NewList(schema.SessionSchema),
+				Description: "Get the active sessions of authenticated user",
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					sessions, err := auth.ListSessions(viewer.Username, viewer.SessionID)
+					return sessions, err
+				}),
+			},
+			"linkedIdentities": &graphql.Field{
+				Type:        graphql.NewList(schema.LinkedIdentitySchema),
+				Description: "Get the OAuth providers linked to authenticated user",
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					identities, err := auth.GetLinkedIdentities(viewer.Username)
+					return identities, err
+				}),
+			},
+			"dataExports": &graphql.Field{
+				Type:        graphql.NewList(schema.DataExportSchema),
+				Description: "Get the personal data exports of authenticated user",
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					exports, err := export.ListExports(viewer.Username)
+					return exports, err
+				}),
+			},
+			"passkeys": &graphql.Field{
+				Type:        graphql.NewList(schema.PasskeySchema),
+				Description: "Get the passkeys of authenticated user",
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					passkeys, err := auth.ListPasskeys(viewer.Username)
+					return passkeys, err
+				}),
+			},
+			"personalAccessTokens": &graphql.Field{
+				Type:        graphql.NewList(schema.PersonalAccessTokenSchema),
+				Description: "Get the personal access tokens of authenticated user",
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					tokens, err := auth.ListPersonalAccessTokens(viewer.Username)
+					return tokens, err
+				}),
 			},
 		},
 	},
@@ -405,7 +542,7 @@ var mutationHandler = graphql.NewObject(
 						user, err := database.SignUpUser(username, password, name, bio, email)
 						return user, err
 					}
-					return nil, errors.New("invalid request: missing argument")
+					return nil, errMissingArgument
 				},
 			},
 			"createDweet": &graphql.Field{
@@ -420,31 +557,20 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: []interface{}{},
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						// Create dweet, and return formatted
-						body, bodyPresent := params.Args["body"].(string)
-						media, mediaPresent := params.Args["media"].([]interface{})
-						if bodyPresent && mediaPresent {
-							mediaList := []string{}
-							for _, link := range media {
-								mediaList = append(mediaList, link.(string))
-							}
-							dweet, err := database.NewDweet(body, data["username"].(string), mediaList)
-							return dweet, err
+				Resolve: requireAuth(auth.ScopeWriteDweets, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Create dweet, and return formatted
+					body, bodyPresent := params.Args["body"].(string)
+					media, mediaPresent := params.Args["media"].([]interface{})
+					if bodyPresent && mediaPresent {
+						mediaList := []string{}
+						for _, link := range media {
+							mediaList = append(mediaList, link.(string))
 						}
-						return nil, errors.New("invalid request: missing argument")
+						dweet, err := database.NewDweet(body, viewer.Username, mediaList, auth.IsPersonalAccessToken(viewer.Claims))
+						return dweet, err
 					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"createReply": &graphql.Field{
 				Type:        schema.DweetSchema,
@@ -461,32 +587,21 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: []interface{}{},
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						// Create a reply to a dweet, and return formatted
-						originalID, idPresent := params.Args["id"].(string)
-						body, bodyPresent := params.Args["body"].(string)
-						media, mediaPresent := params.Args["media"].([]interface{})
-						if bodyPresent && mediaPresent && idPresent {
-							mediaList := []string{}
-							for _, link := range media {
-								mediaList = append(mediaList, link.(string))
-							}
-							dweet, err := database.NewReply(originalID, body, data["username"].(string), mediaList)
-							return dweet, err
+				Resolve: requireAuth(auth.ScopeWriteDweets, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Create a reply to a dweet, and return formatted
+					originalID, idPresent := params.Args["id"].(string)
+					body, bodyPresent := params.Args["body"].(string)
+					media, mediaPresent := params.Args["media"].([]interface{})
+					if bodyPresent && mediaPresent && idPresent {
+						mediaList := []string{}
+						for _, link := range media {
+							mediaList = append(mediaList, link.(string))
 						}
-						return nil, errors.New("invalid request: missing argument")
+						dweet, err := database.NewReply(originalID, body, viewer.Username, mediaList, auth.IsPersonalAccessToken(viewer.Claims))
+						return dweet, err
 					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"redweet": &graphql.Field{
 				Type:        schema.RedweetSchema,
@@ -496,26 +611,15 @@ var mutationHandler = graphql.NewObject(
 						Type: graphql.NewNonNull(graphql.String),
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						// Create a redweet, and return formatted
-						originalID, idPresent := params.Args["id"].(string)
-						if idPresent {
-							redweet, err := database.Redweet(originalID, data["username"].(string))
-							return redweet, err
-						}
-						return nil, errors.New("invalid request: missing argument")
+				Resolve: requireAuth(auth.ScopeWriteDweets, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Create a redweet, and return formatted
+					originalID, idPresent := params.Args["id"].(string)
+					if idPresent {
+						redweet, err := database.Redweet(originalID, viewer.Username)
+						return redweet, err
 					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"follow": &graphql.Field{
 				Type:        schema.UserSchema,
@@ -537,34 +641,23 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						// Make user follow the other user, and return formatted
-						username, userPresent := params.Args["username"].(string)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
+				Resolve: requireAuth(auth.ScopeWriteFollows, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Make user follow the other user, and return formatted
+					username, userPresent := params.Args["username"].(string)
+					objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
+					numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
+					feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
 
-						if username == data["username"].(string) {
-							return nil, errors.New("can't follow self")
-						}
-
-						if userPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
-							user, err := database.Follow(username, data["username"].(string), objectsToFetch, numFeedObjects, feedObjectsOffset)
-							return user, err
-						}
-						return nil, errors.New("invalid request: missing argument")
+					if username == viewer.Username {
+						return nil, apierr.NewValidation("username", "can't follow self")
 					}
 
-					return nil, errors.New("Unauthorized")
-				},
+					if userPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
+						user, err := database.Follow(username, viewer.Username, objectsToFetch, numFeedObjects, feedObjectsOffset)
+						return user, err
+					}
+					return nil, errMissingArgument
+				}),
 			},
 			"like": &graphql.Field{
 				Type:        schema.DweetSchema,
@@ -582,28 +675,17 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						// Make user like dweet, and return formatted
-						id, idPresent := params.Args["id"].(string)
-						repliesToFetch, repliesPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
-						if idPresent && repliesPresent && offsetPresent {
-							dweet, err := database.Like(id, data["username"].(string), repliesToFetch, replyOffset)
-							return dweet, err
-						}
-						return nil, errors.New("invalid request: missing argument")
+				Resolve: requireAuth(auth.ScopeWriteLikes, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Make user like dweet, and return formatted
+					id, idPresent := params.Args["id"].(string)
+					repliesToFetch, repliesPresent := params.Args["repliesToFetch"].(int)
+					replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
+					if idPresent && repliesPresent && offsetPresent {
+						dweet, err := database.Like(id, viewer.Username, repliesToFetch, replyOffset)
+						return dweet, err
 					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"unlike": &graphql.Field{
 				Type:        schema.DweetSchema,
@@ -621,28 +703,17 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
+				Resolve: requireAuth(auth.ScopeWriteLikes, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Make user unlike dweet, and return formatted
+					id, idPresent := params.Args["id"].(string)
+					repliesToFetch, repliesPresent := params.Args["repliesToFetch"].(int)
+					replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
+					if idPresent && repliesPresent && offsetPresent {
+						dweet, err := database.Unlike(id, viewer.Username, repliesToFetch, replyOffset)
+						return dweet, err
 					}
-
-					if isAuth {
-						// Make user unlike dweet, and return formatted
-						id, idPresent := params.Args["id"].(string)
-						repliesToFetch, repliesPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
-						if idPresent && repliesPresent && offsetPresent {
-							dweet, err := database.Unlike(id, data["username"].(string), repliesToFetch, replyOffset)
-							return dweet, err
-						}
-						return nil, errors.New("invalid request: missing argument")
-					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"unfollow": &graphql.Field{
 				Type:        schema.UserSchema,
@@ -664,34 +735,23 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						// Make user unfollow the other user, and return formatted
-						username, userPresent := params.Args["username"].(string)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
-
-						if username == data["username"].(string) {
-							return nil, errors.New("can't unfollow self")
-						}
+				Resolve: requireAuth(auth.ScopeWriteFollows, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Make user unfollow the other user, and return formatted
+					username, userPresent := params.Args["username"].(string)
+					objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
+					numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
+					feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
 
-						if userPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
-							user, err := database.Unfollow(username, data["username"].(string), objectsToFetch, numFeedObjects, feedObjectsOffset)
-							return user, err
-						}
-						return nil, errors.New("invalid request: missing argument")
+					if username == viewer.Username {
+						return nil, apierr.NewValidation("username", "can't unfollow self")
 					}
 
-					return nil, errors.New("Unauthorized")
-				},
+					if userPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent {
+						user, err := database.Unfollow(username, viewer.Username, objectsToFetch, numFeedObjects, feedObjectsOffset)
+						return user, err
+					}
+					return nil, errMissingArgument
+				}),
 			},
 			"editDweet": &graphql.Field{
 				Type:        schema.DweetSchema,
@@ -716,38 +776,27 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						// Edit dweet, and return formatted
-						id, idPresent := params.Args["id"].(string)
-						body, bodyPresent := params.Args["body"].(string)
-						media, mediaPresent := params.Args["media"].([]interface{})
-						repliesToFetch, numPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
-						if bodyPresent && mediaPresent && idPresent && numPresent && offsetPresent {
-							mediaList := []string{}
-							for _, link := range media {
-								mediaList = append(mediaList, link.(string))
-							}
-							dweet, err := database.UpdateDweet(id, data["username"].(string), body, mediaList, repliesToFetch, replyOffset)
-							return dweet, err
+				Resolve: requireAuth(auth.ScopeWriteDweets, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Edit dweet, and return formatted
+					id, idPresent := params.Args["id"].(string)
+					body, bodyPresent := params.Args["body"].(string)
+					media, mediaPresent := params.Args["media"].([]interface{})
+					repliesToFetch, numPresent := params.Args["repliesToFetch"].(int)
+					replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
+					if bodyPresent && mediaPresent && idPresent && numPresent && offsetPresent {
+						mediaList := []string{}
+						for _, link := range media {
+							mediaList = append(mediaList, link.(string))
 						}
-						return nil, errors.New("invalid request: missing argument")
+						dweet, err := database.UpdateDweet(id, viewer.Username, body, mediaList, repliesToFetch, replyOffset)
+						return dweet, err
 					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"editUser": &graphql.Field{
 				Type:        schema.UserSchema,
-				Description: "Edit authenticated user",
+				Description: "Edit authenticated user. A new email is only set once it is confirmed with a link sent to it.",
 				Args: graphql.FieldConfigArgument{
 					"name": &graphql.ArgumentConfig{
 						Type:         graphql.String,
@@ -793,40 +842,39 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						// Edit user, and return formatted
-						name, namePresent := params.Args["name"].(string)
-						email, emailPresent := params.Args["email"].(string)
-						bio, bioPresent := params.Args["bio"].(string)
-						PfpUrl, pfpPresent := params.Args["pfpURL"].(string)
-						objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
-						numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
-						feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
-						followersToFetch, followersPresent := params.Args["followersToFetch"].(int)
-						followersOffset, followersOffsetPresent := params.Args["followersOffset"].(int)
-						followingToFetch, followingPresent := params.Args["followingToFetch"].(int)
-						followingOffset, followingOffsetPresent := params.Args["followingOffset"].(int)
-						if namePresent && emailPresent && bioPresent && pfpPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent && followersPresent && followersOffsetPresent && followingPresent && followingOffsetPresent {
-							user, err := database.UpdateUser(data["username"].(string), name, email, bio, PfpUrl, followersToFetch, followersOffset, followingToFetch, followingOffset, objectsToFetch, numFeedObjects, feedObjectsOffset)
-							return user, err
+				Resolve: requireAuth(auth.ScopeWriteProfile, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Edit user, and return formatted
+					name, namePresent := params.Args["name"].(string)
+					email, emailPresent := params.Args["email"].(string)
+					bio, bioPresent := params.Args["bio"].(string)
+					PfpUrl, pfpPresent := params.Args["pfpURL"].(string)
+					objectsToFetch, objectsToFetchPresent := params.Args["objectsToFetch"].(string)
+					numFeedObjects, numFeedObjectsPresent := params.Args["feedObjectsToFetch"].(int)
+					feedObjectsOffset, feedObjectsOffsetPresent := params.Args["feedObjectsOffset"].(int)
+					followersToFetch, followersPresent := params.Args["followersToFetch"].(int)
+					followersOffset, followersOffsetPresent := params.Args["followersOffset"].(int)
+					followingToFetch, followingPresent := params.Args["followingToFetch"].(int)
+					followingOffset, followingOffsetPresent := params.Args["followingOffset"].(int)
+					if namePresent && emailPresent && bioPresent && pfpPresent && objectsToFetchPresent && numFeedObjectsPresent && feedObjectsOffsetPresent && followersPresent && followersOffsetPresent && followingPresent && followingOffsetPresent {
+						if email != "" {
+							err := auth.RequireScope(viewer.Claims, auth.ScopeAccount)
+							if err != nil {
+								return nil, err
+							}
+							err = auth.RequestEmailChange(viewer.Username, email)
+							if err != nil {
+								return nil, err
+							}
 						}
-						return nil, errors.New("invalid request: missing argument")
+						user, err := database.UpdateUser(viewer.Username, name, bio, PfpUrl, followersToFetch, followersOffset, followingToFetch, followingOffset, objectsToFetch, numFeedObjects, feedObjectsOffset)
+						return user, err
 					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"deleteDweet": &graphql.Field{
 				Type:        schema.DweetSchema,
-				Description: "Delete dweet authored by user",
+				Description: "Delete dweet authored by user, or any dweet if user is a moderator",
 				Args: graphql.FieldConfigArgument{
 					"id": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.String),
@@ -840,28 +888,17 @@ var mutationHandler = graphql.NewObject(
 						DefaultValue: 0,
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
+				Resolve: requireAuth(auth.ScopeWriteDweets, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Delete dweet, and return formatted
+					id, idPresent := params.Args["id"].(string)
+					repliesToFetch, repliesPresent := params.Args["repliesToFetch"].(int)
+					replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
+					if idPresent && repliesPresent && offsetPresent {
+						dweet, err := database.DeleteDweet(id, viewer.Username, auth.HasRole(viewer.Claims, auth.RoleModerator), repliesToFetch, replyOffset)
+						return dweet, err
 					}
-
-					if isAuth {
-						// Delete dweet, and return formatted
-						id, idPresent := params.Args["id"].(string)
-						repliesToFetch, repliesPresent := params.Args["repliesToFetch"].(int)
-						replyOffset, offsetPresent := params.Args["repliesOffset"].(int)
-						if idPresent && repliesPresent && offsetPresent {
-							dweet, err := database.DeleteDweet(id, data["username"].(string), repliesToFetch, replyOffset)
-							return dweet, err
-						}
-						return nil, errors.New("invalid request: missing argument")
-					}
-
-					return nil, errors.New("Unauthorized")
-				},
+					return nil, errMissingArgument
+				}),
 			},
 			"unredweet": &graphql.Field{
 				Type:        schema.RedweetSchema,
@@ -871,26 +908,419 @@ var mutationHandler = graphql.NewObject(
 						Type: graphql.NewNonNull(graphql.String),
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
+				Resolve: requireAuth(auth.ScopeWriteDweets, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					// Make user unredweet the dweet, and return formatted
+					id, present := params.Args["id"].(string)
+					if present {
+						redweet, err := database.DeleteRedweet(id, viewer.Username)
+						return redweet, err
 					}
-
-					if isAuth {
-						// Make user unredweet the dweet, and return formatted
-						id, present := params.Args["id"].(string)
-						if present {
-							redweet, err := database.DeleteRedweet(id, data["username"].(string))
-							return redweet, err
+					return nil, errMissingArgument
+				}),
+			},
+			"enrollTOTP": &graphql.Field{
+				Type:        schema.TOTPEnrollmentSchema,
+				Description: "Start setting up two-factor authentication for authenticated user",
+				Args: graphql.FieldConfigArgument{
+					"password": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						Description:  "Not needed for users without a password, who have to have logged in in the last 10 minutes instead",
+						DefaultValue: "",
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					password, passwordPresent := params.Args["password"].(string)
+					sessionID := viewer.SessionID
+					if passwordPresent && sessionID != "" {
+						enrollment, err := auth.EnrollTOTP(viewer.Username, sessionID, password)
+						return enrollment, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"confirmTOTP": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Finish setting up two-factor authentication for authenticated user using a code from their authenticator app",
+				Args: graphql.FieldConfigArgument{
+					"code": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"password": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						Description:  "Not needed for users without a password, who have to have logged in in the last 10 minutes instead",
+						DefaultValue: "",
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					code, codePresent := params.Args["code"].(string)
+					password, passwordPresent := params.Args["password"].(string)
+					sessionID := viewer.SessionID
+					if codePresent && passwordPresent && sessionID != "" {
+						confirmed, err := auth.ConfirmTOTP(viewer.Username, sessionID, password, code)
+						return confirmed, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"disableTOTP": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Turn off two-factor authentication for authenticated user using a TOTP or recovery code",
+				Args: graphql.FieldConfigArgument{
+					"code": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					code, present := params.Args["code"].(string)
+					if present {
+						disabled, err := auth.DisableTOTP(viewer.Username, code)
+						return disabled, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"revokeSession": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Revoke one of authenticated user's sessions, logging that device out",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					sessionID, present := params.Args["id"].(string)
+					if present {
+						revoked, err := auth.RevokeSession(viewer.Username, sessionID)
+						return revoked, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"revokeAllSessions": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Revoke all of authenticated user's sessions, optionally keeping the current one, and return how many were revoked",
+				Args: graphql.FieldConfigArgument{
+					"keepCurrent": &graphql.ArgumentConfig{
+						Type:         graphql.Boolean,
+						DefaultValue: false,
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					keepSessionID := ""
+					if keepCurrent, _ := params.Args["keepCurrent"].(bool); keepCurrent {
+						keepSessionID = viewer.SessionID
+					}
+					revoked, err := auth.RevokeAllSessions(viewer.Username, keepSessionID)
+					return revoked, err
+				}),
+			},
+			"createPersonalAccessToken": &graphql.Field{
+				Type:        schema.PersonalAccessTokenSchema,
+				Description: "Create a personal access token for authenticated user. The token is only shown once.",
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"scopes": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+					},
+					"expiresInDays": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: 0,
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					name, namePresent := params.Args["name"].(string)
+					scopes, scopesPresent := params.Args["scopes"].([]interface{})
+					expiresInDays, expiryPresent := params.Args["expiresInDays"].(int)
+					if namePresent && scopesPresent && expiryPresent {
+						scopeList := []string{}
+						for _, scope := range scopes {
+							scopeList = append(scopeList, scope.(string))
 						}
-						return nil, errors.New("invalid request: missing argument")
+						token, err := auth.CreatePersonalAccessToken(viewer.Username, name, scopeList, expiresInDays)
+						return token, err
 					}
-
-					return nil, errors.New("Unauthorized")
+					return nil, errMissingArgument
+				}),
+			},
+			"revokePersonalAccessToken": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Revoke one of authenticated user's personal access tokens",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
 				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					tokenID, present := params.Args["id"].(string)
+					if present {
+						revoked, err := auth.RevokePersonalAccessToken(viewer.Username, tokenID)
+						return revoked, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"deletePasskey": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Delete one of authenticated user's passkeys",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					passkeyID, present := params.Args["id"].(string)
+					if present {
+						deleted, err := auth.DeletePasskey(viewer.Username, passkeyID)
+						return deleted, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"setBotAccount": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Mark authenticated user as a bot or not. Dweets by bots are marked as automated.",
+				Args: graphql.FieldConfigArgument{
+					"isBot": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Boolean),
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					isBot, present := params.Args["isBot"].(bool)
+					if present {
+						bot, err := database.SetBotAccount(viewer.Username, isBot)
+						return bot, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"changePassword": &graphql.Field{
+				Type:        schema.AuthTokensSchema,
+				Description: "Change authenticated user's password, logging out every other session",
+				Args: graphql.FieldConfigArgument{
+					"currentPassword": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"newPassword": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					currentPassword, currentPresent := params.Args["currentPassword"].(string)
+					newPassword, newPresent := params.Args["newPassword"].(string)
+					sessionID := viewer.SessionID
+					if currentPresent && newPresent && sessionID != "" {
+						tokens, err := auth.ChangePassword(viewer.Username, sessionID, currentPassword, newPassword)
+						return tokens, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"requestDataExport": &graphql.Field{
+				Type:        schema.DataExportSchema,
+				Description: "Start building an archive of authenticated user's data, which is emailed to them when ready. Can be done once a day.",
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					dataExport, err := export.RequestExport(viewer.Username)
+					return dataExport, err
+				}),
+			},
+			"deleteAccount": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "Delete authenticated user after a 30 day grace period, and return when that is. Logging in before then keeps the account.",
+				Args: graphql.FieldConfigArgument{
+					"password": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						Description:  "Not needed for users without a password, who have to have logged in in the last 10 minutes instead",
+						DefaultValue: "",
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					password, passwordPresent := params.Args["password"].(string)
+					sessionID := viewer.SessionID
+					if passwordPresent && sessionID != "" {
+						deleteAfter, err := auth.ScheduleAccountDeletion(viewer.Username, sessionID, password)
+						return deleteAfter, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"deactivateAccount": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Deactivate authenticated user, hiding their profile and posts until they log in at /api/reactivate",
+				Args: graphql.FieldConfigArgument{
+					"password": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						Description:  "Not needed for users without a password, who have to have logged in in the last 10 minutes instead",
+						DefaultValue: "",
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					password, passwordPresent := params.Args["password"].(string)
+					sessionID := viewer.SessionID
+					if passwordPresent && sessionID != "" {
+						deactivated, err := auth.DeactivateAccount(viewer.Username, sessionID, password)
+						return deactivated, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"setUserRole": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Change the role of a user to user, moderator or admin. Only admins can do this.",
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"role": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireRole(auth.RoleAdmin, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					username, usernamePresent := params.Args["username"].(string)
+					role, rolePresent := params.Args["role"].(string)
+					if usernamePresent && rolePresent {
+						newRole, err := auth.SetUserRole(viewer.Username, username, role)
+						return newRole, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"adminDeleteUser": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Permanently delete a user and everything they posted right away. Only admins can do this.",
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireRole(auth.RoleAdmin, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					username, present := params.Args["username"].(string)
+					if present {
+						deleted, err := database.AdminDeleteUser(username)
+						return deleted, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"unlockAccount": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Unlock an account locked after too many failed logins. Only admins can do this.",
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireRole(auth.RoleAdmin, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					username, present := params.Args["username"].(string)
+					if present {
+						err := auth.UnlockAccount(username)
+						return err == nil, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"unlockIP": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Unlock an IP locked out after too many failed logins. Only admins can do this.",
+				Args: graphql.FieldConfigArgument{
+					"ip": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireRole(auth.RoleAdmin, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					ip, present := params.Args["ip"].(string)
+					if present {
+						err := auth.UnlockIP(ip)
+						return err == nil, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"suspendUser": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Suspend a user until a time, hiding their profile and posts and keeping them from logging in. Only admins can do this.",
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"reason": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"until": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.DateTime),
+					},
+				},
+				Resolve: requireRole(auth.RoleAdmin, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					username, usernamePresent := params.Args["username"].(string)
+					reason, reasonPresent := params.Args["reason"].(string)
+					until, untilPresent := params.Args["until"].(time.Time)
+					if usernamePresent && reasonPresent && untilPresent {
+						suspended, err := auth.SuspendUser(viewer.Username, username, reason, until)
+						return suspended, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"unsuspendUser": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Lift a user's suspension early. Only admins can do this.",
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireRole(auth.RoleAdmin, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					username, present := params.Args["username"].(string)
+					if present {
+						unsuspended, err := auth.UnsuspendUser(username)
+						return unsuspended, err
+					}
+					return nil, errMissingArgument
+				}),
+			},
+			"completeOAuthSignup": &graphql.Field{
+				Type:        schema.AuthTokensSchema,
+				Description: "Finish signing up with an OAuth provider by picking a username, and log in",
+				Args: graphql.FieldConfigArgument{
+					"onboardingToken": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+					onboardingToken, tokenPresent := params.Args["onboardingToken"].(string)
+					username, usernamePresent := params.Args["username"].(string)
+					if tokenPresent && usernamePresent {
+						root, _ := params.Info.RootValue.(map[string]interface{})
+						request, ok := root["request"].(*http.Request)
+						if !ok {
+							return nil, apierr.NewValidation("", "invalid request: signup must be completed over HTTP")
+						}
+						tokens, err := auth.CompleteOAuthSignup(onboardingToken, username, request)
+						return tokens, err
+					}
+					return nil, errMissingArgument
+				},
+			},
+			"unlinkProvider": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Unlink an OAuth provider from authenticated user, as long as they can still log in some other way",
+				Args: graphql.FieldConfigArgument{
+					"provider": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: requireAuth(auth.ScopeAccount, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					provider, present := params.Args["provider"].(string)
+					if present {
+						unlinked, err := auth.UnlinkProvider(viewer.Username, provider)
+						return unlinked, err
+					}
+					return nil, errMissingArgument
+				}),
 			},
 		},
 	},
@@ -903,21 +1333,10 @@ var subscriptionHandler = graphql.NewObject(
 		Fields: graphql.Fields{
 			"feed": &graphql.Field{
 				Type: graphql.NewList(schema.FeedObjectSchema),
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-					// Check authentication
-					tokenString := params.Info.RootValue.(map[string]interface{})["token"].(string)
-					data, isAuth, err := auth.VerifyAccessToken(tokenString)
-					if err != nil {
-						return nil, err
-					}
-
-					if isAuth {
-						obj, err := database.GetFeed(data["username"].(string))
-						return obj, err
-					}
-
-					return nil, errors.New("Unauthorized")
-				},
+				Resolve: requireAuth(auth.ScopeRead, func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error) {
+					obj, err := database.GetFeed(viewer.Username)
+					return obj, err
+				}),
 			},
 		},
 	},
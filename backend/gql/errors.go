@@ -0,0 +1,29 @@
+package gql
+
+import (
+	"github.com/soumitradev/Dwitter/backend/apierr"
+
+	"github.com/graphql-go/graphql"
+)
+
+// Returned by resolvers whose arguments didn't all come through
+var errMissingArgument = apierr.NewValidation("", "invalid request: missing argument")
+
+// Give every error the API returns a code in extensions.code, so that clients never have to match on messages
+func init() {
+	for _, object := range []*graphql.Object{queryHandler, mutationHandler, subscriptionHandler} {
+		for _, field := range object.Fields() {
+			field.Resolve = withErrorCode(field.Resolve)
+		}
+	}
+}
+
+func withErrorCode(resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		result, err := resolve(params)
+		if err != nil {
+			return nil, apierr.From(err)
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,347 @@
+package gql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/graphql-go/handler"
+)
+
+// Limits on how much a single query can ask for, which are checked before it is run
+type Limits struct {
+	// How deeply fields can be nested
+	MaxDepth int
+	// The most a query can cost, where every field costs 1 for each time it can be returned
+	MaxCost int
+	// The most items a *ToFetch, numberToFetch or first argument can ask for
+	MaxFetch int
+}
+
+// The most a GraphQL request body can be, in bytes. Uploads go to their own endpoints, so queries never need more.
+const maxQueryBodySize = 1 << 20
+
+// The limits queries are checked against, until InitLimits loads them from the environment
+var limits = Limits{
+	MaxDepth: 10,
+	MaxCost:  5000,
+	MaxFetch: 100,
+}
+
+// Load the query limits from GRAPHQL_MAX_DEPTH, GRAPHQL_MAX_COST and GRAPHQL_MAX_FETCH, keeping the defaults for the ones that aren't set
+func InitLimits() error {
+	loaded := limits
+	for name, limit := range map[string]*int{
+		"GRAPHQL_MAX_DEPTH": &loaded.MaxDepth,
+		"GRAPHQL_MAX_COST":  &loaded.MaxCost,
+		"GRAPHQL_MAX_FETCH": &loaded.MaxFetch,
+	} {
+		if value := os.Getenv(name); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 1 {
+				return fmt.Errorf("%s must be a positive number", name)
+			}
+			*limit = number
+		}
+	}
+	limits = loaded
+	return nil
+}
+
+// A LimitError says which limit a query went over
+type LimitError struct {
+	Code    string
+	Message string
+	Limit   int
+	Actual  int
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// Extensions lets GraphQL responses carry the limit along with the message
+func (e *LimitError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":   e.Code,
+		"limit":  e.Limit,
+		"actual": e.Actual,
+	}
+}
+
+// Arguments that set how many items a field returns
+var ownFetchArgs = map[string]bool{
+	"numberToFetch": true,
+	"dweetsToFetch": true,
+}
+
+// Arguments that set how many items fields under a field return
+var childFetchArgs = map[string][]string{
+	"first":              {"edges"},
+	"repliesToFetch":     {"replyDweets"},
+	"feedObjectsToFetch": {"feedObjects", "dweets", "redweets", "redweetedDweets", "likedDweets"},
+	"followersToFetch":   {"followers"},
+	"followingToFetch":   {"following"},
+}
+
+// Walks a query, adding up its depth and cost
+type queryWalker struct {
+	source    *source.Source
+	variables map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+	// Fragments being walked, so that fragments that spread themselves don't loop forever
+	spreading map[string]bool
+	err       *gqlerrors.Error
+}
+
+// Check a query against the limits before running it. Queries that can't be parsed are left for graphql.Do to report.
+func CheckQueryLimits(query string, variables map[string]interface{}, operationName string) error {
+	src := source.NewSource(&source.Source{
+		Body: []byte(query),
+		Name: "GraphQL request",
+	})
+	document, err := parser.Parse(parser.ParseParams{Source: src})
+	if err != nil {
+		return nil
+	}
+
+	walker := &queryWalker{
+		source:    src,
+		variables: variables,
+		fragments: map[string]*ast.FragmentDefinition{},
+		spreading: map[string]bool{},
+	}
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operations = append(operations, definition)
+			}
+		case *ast.FragmentDefinition:
+			walker.fragments[definition.Name.Value] = definition
+		}
+	}
+	// Anything but exactly one operation is an error graphql.Do reports
+	if len(operations) != 1 {
+		return nil
+	}
+
+	var root *graphql.Object
+	switch operations[0].Operation {
+	case ast.OperationTypeMutation:
+		root = Schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = Schema.SubscriptionType()
+	default:
+		root = Schema.QueryType()
+	}
+	if root == nil {
+		return nil
+	}
+
+	cost := walker.selectionCost(root, operations[0].SelectionSet, nil, 1)
+	if cost > limits.MaxCost {
+		walker.fail(operations[0], &LimitError{
+			Code:    "QUERY_TOO_EXPENSIVE",
+			Message: fmt.Sprintf("query is too expensive: it costs %d, and the most a query can cost is %d", cost, limits.MaxCost),
+			Limit:   limits.MaxCost,
+			Actual:  cost,
+		})
+	}
+	if walker.err != nil {
+		return walker.err
+	}
+	return nil
+}
+
+// Report the first limit a query goes over, pointing at the field that went over it
+func (w *queryWalker) fail(node ast.Node, limitErr *LimitError) {
+	if w.err == nil {
+		w.err = gqlerrors.NewError(limitErr.Message, []ast.Node{node}, "", w.source, []int{}, limitErr)
+	}
+}
+
+// Add up the cost of a selection set on parent, where sizes are the number of items the fields named in it return
+func (w *queryWalker) selectionCost(parent graphql.Type, selectionSet *ast.SelectionSet, sizes map[string]int, depth int) int {
+	if selectionSet == nil || w.err != nil {
+		return 0
+	}
+
+	cost := 0
+	for _, selection := range selectionSet.Selections {
+		switch node := selection.(type) {
+		case *ast.Field:
+			cost += w.fieldCost(parent, node, sizes, depth)
+		case *ast.InlineFragment:
+			fragmentType := parent
+			if node.TypeCondition != nil {
+				fragmentType = Schema.Type(node.TypeCondition.Name.Value)
+			}
+			cost += w.selectionCost(fragmentType, node.SelectionSet, sizes, depth)
+		case *ast.FragmentSpread:
+			name := node.Name.Value
+			fragment, ok := w.fragments[name]
+			if !ok || w.spreading[name] {
+				continue
+			}
+			w.spreading[name] = true
+			cost += w.selectionCost(Schema.Type(fragment.TypeCondition.Name.Value), fragment.SelectionSet, sizes, depth)
+			w.spreading[name] = false
+		}
+	}
+	return cost
+}
+
+// Add up the cost of a field and the fields under it
+func (w *queryWalker) fieldCost(parent graphql.Type, field *ast.Field, sizes map[string]int, depth int) int {
+	name := field.Name.Value
+	// Introspection is left alone, so that tools like the playground keep working
+	if strings.HasPrefix(name, "__") {
+		return 0
+	}
+
+	var definition *graphql.FieldDefinition
+	switch parent := parent.(type) {
+	case *graphql.Object:
+		definition = parent.Fields()[name]
+	case *graphql.Interface:
+		definition = parent.Fields()[name]
+	}
+	// Unknown fields are left for validation to report
+	if definition == nil {
+		return 0
+	}
+
+	if depth > limits.MaxDepth {
+		w.fail(field, &LimitError{
+			Code:    "QUERY_TOO_DEEP",
+			Message: fmt.Sprintf("query is too deep: %s is nested %d fields deep, and fields can be nested at most %d deep", name, depth, limits.MaxDepth),
+			Limit:   limits.MaxDepth,
+			Actual:  depth,
+		})
+		return 0
+	}
+
+	// Lists are assumed to be as long as they can be, unless an argument says otherwise
+	size := limits.MaxFetch
+	if fetched, ok := sizes[name]; ok {
+		size = fetched
+	}
+	childSizes := map[string]int{}
+	for argName, value := range w.arguments(definition, field) {
+		if !ownFetchArgs[argName] && childFetchArgs[argName] == nil {
+			continue
+		}
+		if value < 0 || value > limits.MaxFetch {
+			w.fail(field, &LimitError{
+				Code:    "FETCH_LIMIT_EXCEEDED",
+				Message: fmt.Sprintf("%s on %s must be between 0 and %d", argName, name, limits.MaxFetch),
+				Limit:   limits.MaxFetch,
+				Actual:  value,
+			})
+			return 0
+		}
+		if ownFetchArgs[argName] {
+			size = value
+		}
+		for _, child := range childFetchArgs[argName] {
+			childSizes[child] = value
+		}
+	}
+
+	fieldType := definition.Type
+	if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+		fieldType = nonNull.OfType
+	}
+	list, isList := fieldType.(*graphql.List)
+	if isList {
+		fieldType = list.OfType
+		if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+			fieldType = nonNull.OfType
+		}
+	}
+
+	childCost := w.selectionCost(fieldType, field.SelectionSet, childSizes, depth+1)
+	if isList {
+		// Each item of a list costs at least 1, even if nothing is selected on it
+		return 1 + size*(1+childCost)
+	}
+	return 1 + childCost
+}
+
+// The integer arguments of a field, with defaults filled in
+func (w *queryWalker) arguments(definition *graphql.FieldDefinition, field *ast.Field) map[string]int {
+	values := map[string]int{}
+	for _, arg := range definition.Args {
+		if value, ok := arg.DefaultValue.(int); ok {
+			values[arg.Name()] = value
+		}
+	}
+	for _, arg := range field.Arguments {
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			if number, err := strconv.Atoi(value.Value); err == nil {
+				values[arg.Name.Value] = number
+			}
+		case *ast.Variable:
+			switch variable := w.variables[value.Name.Value].(type) {
+			case int:
+				values[arg.Name.Value] = variable
+			case float64:
+				values[arg.Name.Value] = int(variable)
+			case json.Number:
+				if number, err := variable.Int64(); err == nil {
+					values[arg.Name.Value] = int(number)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// Reject queries that go over the limits with a GraphQL error before they reach the GraphQL handler
+func LimitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Reading the query uses up the body, so read it from a copy and give the handler its own.
+		// Content-Length can be left out of chunked requests, so the body is capped as it is read.
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxQueryBodySize))
+			if err != nil && len(body) >= maxQueryBodySize {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "could not read request body", http.StatusBadRequest)
+				return
+			}
+		}
+		copied := r.Clone(r.Context())
+		copied.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		opts := handler.NewRequestOptions(copied)
+		err := CheckQueryLimits(opts.Query, opts.Variables, opts.OperationName)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(&graphql.Result{
+				Errors: gqlerrors.FormatErrors(err),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,227 @@
+package gql
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// Check queries against these limits until the test ends
+func withLimits(t *testing.T, testLimits Limits) {
+	t.Helper()
+	previous := limits
+	limits = testLimits
+	t.Cleanup(func() {
+		limits = previous
+	})
+}
+
+// The limit a query went over, or nil if it didn't go over any
+func limitErrorOf(t *testing.T, err error) *LimitError {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	graphqlErr, ok := err.(*gqlerrors.Error)
+	if !ok {
+		t.Fatalf("got %T %v, want a GraphQL error", err, err)
+	}
+	limitErr, ok := graphqlErr.OriginalError.(*LimitError)
+	if !ok {
+		t.Fatalf("got %v, want a limit error", err)
+	}
+	return limitErr
+}
+
+func TestCheckQueryLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    Limits
+		query     string
+		variables map[string]interface{}
+		// The code and actual value of the limit the query goes over, if it should go over one
+		wantCode   string
+		wantActual int
+	}{
+		{
+			name:   "shallow query",
+			limits: Limits{MaxDepth: 3, MaxCost: 100, MaxFetch: 50},
+			query:  `{ dweet(id: "1") { author { username } } }`,
+		},
+		{
+			name:       "too deep",
+			limits:     Limits{MaxDepth: 2, MaxCost: 100, MaxFetch: 50},
+			query:      `{ dweet(id: "1") { author { username } } }`,
+			wantCode:   "QUERY_TOO_DEEP",
+			wantActual: 3,
+		},
+		{
+			name:       "too deep through a fragment",
+			limits:     Limits{MaxDepth: 2, MaxCost: 100, MaxFetch: 50},
+			query:      `{ dweet(id: "1") { ...authorName } } fragment authorName on Dweet { author { username } }`,
+			wantCode:   "QUERY_TOO_DEEP",
+			wantActual: 3,
+		},
+		{
+			// users costs 1 + 10 * (1 + username + followers), and followers costs 1 + 50 * (1 + username)
+			name:   "nested lists at the cost limit",
+			limits: Limits{MaxDepth: 10, MaxCost: 1031, MaxFetch: 50},
+			query:  `{ users(text: "a", numberToFetch: 10) { username followers { username } } }`,
+		},
+		{
+			name:       "nested lists multiply",
+			limits:     Limits{MaxDepth: 10, MaxCost: 1030, MaxFetch: 50},
+			query:      `{ users(text: "a", numberToFetch: 10) { username followers { username } } }`,
+			wantCode:   "QUERY_TOO_EXPENSIVE",
+			wantActual: 1031,
+		},
+		{
+			// user costs 1 + likedDweets, which costs 1 + 3 * (1 + id)
+			name:       "arguments size the lists under a field",
+			limits:     Limits{MaxDepth: 10, MaxCost: 7, MaxFetch: 50},
+			query:      `{ user(username: "a", feedObjectsToFetch: 3) { likedDweets { id } } }`,
+			wantCode:   "QUERY_TOO_EXPENSIVE",
+			wantActual: 8,
+		},
+		{
+			name:       "fetching too many",
+			limits:     Limits{MaxDepth: 10, MaxCost: 100000, MaxFetch: 50},
+			query:      `{ users(text: "a", numberToFetch: 51) { username } }`,
+			wantCode:   "FETCH_LIMIT_EXCEEDED",
+			wantActual: 51,
+		},
+		{
+			// Validation rejects the cycle later, the walk only has to end
+			name:   "fragment that spreads itself",
+			limits: Limits{MaxDepth: 10, MaxCost: 100, MaxFetch: 50},
+			query:  `{ dweet(id: "1") { ...loop } } fragment loop on Dweet { id ...loop }`,
+		},
+		{
+			name:       "fragments that spread each other",
+			limits:     Limits{MaxDepth: 10, MaxCost: 2, MaxFetch: 50},
+			query:      `{ dweet(id: "1") { ...a } } fragment a on Dweet { id ...b } fragment b on Dweet { likeCount ...a }`,
+			wantCode:   "QUERY_TOO_EXPENSIVE",
+			wantActual: 3,
+		},
+		{
+			// dweetsConnection costs 1 + edges, which costs 1 + first * (1 + node), and node costs 1 + id
+			name:       "first from a variable",
+			limits:     Limits{MaxDepth: 10, MaxCost: 16, MaxFetch: 50},
+			query:      `query($first: Int) { dweetsConnection(text: "a", first: $first) { edges { node { id } } } }`,
+			variables:  map[string]interface{}{"first": 5},
+			wantCode:   "QUERY_TOO_EXPENSIVE",
+			wantActual: 17,
+		},
+		{
+			name:       "first from a JSON variable",
+			limits:     Limits{MaxDepth: 10, MaxCost: 16, MaxFetch: 50},
+			query:      `query($first: Int) { dweetsConnection(text: "a", first: $first) { edges { node { id } } } }`,
+			variables:  map[string]interface{}{"first": float64(5)},
+			wantCode:   "QUERY_TOO_EXPENSIVE",
+			wantActual: 17,
+		},
+		{
+			name:       "first from a JSON number variable",
+			limits:     Limits{MaxDepth: 10, MaxCost: 16, MaxFetch: 50},
+			query:      `query($first: Int) { dweetsConnection(text: "a", first: $first) { edges { node { id } } } }`,
+			variables:  map[string]interface{}{"first": json.Number("5")},
+			wantCode:   "QUERY_TOO_EXPENSIVE",
+			wantActual: 17,
+		},
+		{
+			name:       "first from a variable that is too big",
+			limits:     Limits{MaxDepth: 10, MaxCost: 100000, MaxFetch: 50},
+			query:      `query($first: Int) { dweetsConnection(text: "a", first: $first) { edges { node { id } } } }`,
+			variables:  map[string]interface{}{"first": 500},
+			wantCode:   "FETCH_LIMIT_EXCEEDED",
+			wantActual: 500,
+		},
+		{
+			name:       "first from a negative variable",
+			limits:     Limits{MaxDepth: 10, MaxCost: 100000, MaxFetch: 50},
+			query:      `query($first: Int) { dweetsConnection(text: "a", first: $first) { edges { node { id } } } }`,
+			variables:  map[string]interface{}{"first": -1},
+			wantCode:   "FETCH_LIMIT_EXCEEDED",
+			wantActual: -1,
+		},
+		{
+			name:   "introspection is free",
+			limits: Limits{MaxDepth: 1, MaxCost: 1, MaxFetch: 1},
+			query:  `{ __schema { types { name fields { name } } } }`,
+		},
+		{
+			name:   "unparseable queries are left for graphql",
+			limits: Limits{MaxDepth: 1, MaxCost: 1, MaxFetch: 1},
+			query:  `{ dweet(id: "1") {`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withLimits(t, test.limits)
+
+			limitErr := limitErrorOf(t, CheckQueryLimits(test.query, test.variables, ""))
+			if test.wantCode == "" {
+				if limitErr != nil {
+					t.Fatalf("got %s (%d), want no error", limitErr.Code, limitErr.Actual)
+				}
+				return
+			}
+			if limitErr == nil {
+				t.Fatalf("got no error, want %s", test.wantCode)
+			}
+			if limitErr.Code != test.wantCode || limitErr.Actual != test.wantActual {
+				t.Errorf("got %s (%d), want %s (%d)", limitErr.Code, limitErr.Actual, test.wantCode, test.wantActual)
+			}
+		})
+	}
+}
+
+func TestLimitHandlerBodySize(t *testing.T) {
+	withLimits(t, Limits{MaxDepth: 10, MaxCost: 100, MaxFetch: 50})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "query",
+			body:       `{"query": "{ dweet(id: \"1\") { id } }"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "body over the cap",
+			body:       `{"query": "{ dweet(id: \"1\") { id } }", "padding": "` + strings.Repeat("a", maxQueryBodySize) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/graphql", bytes.NewReader([]byte(test.body)))
+			r.Header.Set("Content-Type", "application/json")
+			// Chunked requests don't say how long they are, so nothing before the handler can reject them
+			r.ContentLength = -1
+			rec := httptest.NewRecorder()
+			LimitHandler(next).ServeHTTP(rec, r)
+
+			if rec.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, test.wantStatus, rec.Body.String())
+			}
+			if test.wantStatus == http.StatusOK && rec.Body.String() != test.body {
+				t.Errorf("handler got body %q, want %q", rec.Body.String(), test.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package gql
+
+import (
+	"github.com/soumitradev/Dwitter/backend/auth"
+	"github.com/soumitradev/Dwitter/backend/database"
+	"github.com/soumitradev/Dwitter/backend/schema"
+
+	"github.com/graphql-go/graphql"
+)
+
+// Resolve the relations of dweets and redweets that the query returning them didn't fetch,
+// batching the lookups of a whole request through its database.Loaders
+func init() {
+	for _, dweetSchema := range []*graphql.Object{schema.BasicDweetSchema, schema.DweetSchema} {
+		dweetSchema.AddFieldConfig("author", &graphql.Field{
+			Type:    schema.BasicUserSchema,
+			Resolve: resolveAuthor,
+		})
+	}
+	schema.RedweetSchema.AddFieldConfig("author", &graphql.Field{
+		Type:    schema.BasicUserSchema,
+		Resolve: resolveAuthor,
+	})
+	schema.RedweetSchema.AddFieldConfig("redweetOf", &graphql.Field{
+		Type:    schema.BasicDweetSchema,
+		Resolve: resolveRedweetOf,
+	})
+	schema.DweetSchema.AddFieldConfig("replyTo", &graphql.Field{
+		Type:    schema.BasicDweetSchema,
+		Resolve: resolveReplyTo,
+	})
+	schema.DweetSchema.AddFieldConfig("replyDweets", &graphql.Field{
+		Type:    graphql.NewList(schema.BasicDweetSchema),
+		Resolve: resolveReplyDweets,
+	})
+	schema.DweetSchema.AddFieldConfig("likeUsers", &graphql.Field{
+		Type:    graphql.NewList(schema.BasicUserSchema),
+		Resolve: resolveLikeUsers,
+	})
+	schema.DweetSchema.AddFieldConfig("redweetUsers", &graphql.Field{
+		Type:    graphql.NewList(schema.BasicUserSchema),
+		Resolve: resolveRedweetUsers,
+	})
+
+	// The schema was built before these were added, so redefine the fields now rather than during the first request
+	for _, object := range []*graphql.Object{schema.BasicDweetSchema, schema.DweetSchema, schema.RedweetSchema} {
+		object.Fields()
+	}
+}
+
+// The loaders of the request being resolved, which are made the first time they're needed
+func requestLoaders(params graphql.ResolveParams) *database.Loaders {
+	root, ok := params.Info.RootValue.(map[string]interface{})
+	if !ok {
+		return database.NewLoaders("")
+	}
+	if loaders, ok := root["loaders"].(*database.Loaders); ok {
+		return loaders
+	}
+
+	// Like and redweet users depend on who is asking
+	viewerUsername := ""
+	viewer := auth.ViewerFrom(params.Context)
+	if viewer.LoggedIn() && auth.HasScope(viewer.Claims, auth.ScopeRead) {
+		viewerUsername = viewer.Username
+	}
+
+	loaders := database.NewLoaders(viewerUsername)
+	root["loaders"] = loaders
+	return loaders
+}
+
+func resolveAuthor(params graphql.ResolveParams) (interface{}, error) {
+	var author schema.BasicUserType
+	var authorID string
+	switch source := params.Source.(type) {
+	case schema.BasicDweetType:
+		author, authorID = source.Author, source.AuthorID
+	case schema.DweetType:
+		author, authorID = source.Author, source.AuthorID
+	case schema.RedweetType:
+		author, authorID = source.Author, source.AuthorID
+	default:
+		return nil, nil
+	}
+
+	if author.Username != "" {
+		return author, nil
+	}
+	return requestLoaders(params).User(authorID), nil
+}
+
+func resolveRedweetOf(params graphql.ResolveParams) (interface{}, error) {
+	redweet, ok := params.Source.(schema.RedweetType)
+	if !ok {
+		return nil, nil
+	}
+	if redweet.RedweetOf.ID != "" {
+		return redweet.RedweetOf, nil
+	}
+	return requestLoaders(params).Dweet(redweet.OriginalRedweetID), nil
+}
+
+func resolveReplyTo(params graphql.ResolveParams) (interface{}, error) {
+	dweet, ok := params.Source.(schema.DweetType)
+	if !ok || !dweet.IsReply {
+		return nil, nil
+	}
+	if dweet.ReplyTo.ID != "" {
+		return dweet.ReplyTo, nil
+	}
+	return requestLoaders(params).Dweet(dweet.OriginalReplyID), nil
+}
+
+func resolveReplyDweets(params graphql.ResolveParams) (interface{}, error) {
+	dweet, ok := params.Source.(schema.DweetType)
+	if !ok {
+		return nil, nil
+	}
+	if dweet.ReplyDweets != nil {
+		return dweet.ReplyDweets, nil
+	}
+	return requestLoaders(params).Replies(dweet.ID), nil
+}
+
+func resolveLikeUsers(params graphql.ResolveParams) (interface{}, error) {
+	dweet, ok := params.Source.(schema.DweetType)
+	if !ok {
+		return nil, nil
+	}
+	if dweet.LikeUsers != nil {
+		return dweet.LikeUsers, nil
+	}
+	return requestLoaders(params).LikeUsers(dweet.ID), nil
+}
+
+func resolveRedweetUsers(params graphql.ResolveParams) (interface{}, error) {
+	dweet, ok := params.Source.(schema.DweetType)
+	if !ok {
+		return nil, nil
+	}
+	if dweet.RedweetUsers != nil {
+		return dweet.RedweetUsers, nil
+	}
+	return requestLoaders(params).RedweetUsers(dweet.ID), nil
+}
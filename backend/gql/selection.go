@@ -0,0 +1,43 @@
+package gql
+
+import (
+	"github.com/soumitradev/Dwitter/backend/database"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Collect the fields selected under the field being resolved, following fragments,
+// so that the database only fetches the relations the query asked for
+func selectionOf(params graphql.ResolveParams) database.Selection {
+	sel := database.Selection{}
+	for _, field := range params.Info.FieldASTs {
+		addSelections(sel, field.SelectionSet, params.Info.Fragments)
+	}
+	return sel
+}
+
+func addSelections(sel database.Selection, selectionSet *ast.SelectionSet, fragments map[string]ast.Definition) {
+	if selectionSet == nil {
+		return
+	}
+	for _, selection := range selectionSet.Selections {
+		switch node := selection.(type) {
+		case *ast.Field:
+			// Aliases don't matter here, only which fields are used
+			name := node.Name.Value
+			sub, present := sel[name]
+			if !present {
+				sub = database.Selection{}
+				sel[name] = sub
+			}
+			addSelections(sub, node.SelectionSet, fragments)
+		case *ast.InlineFragment:
+			addSelections(sel, node.SelectionSet, fragments)
+		case *ast.FragmentSpread:
+			if fragment, ok := fragments[node.Name.Value].(*ast.FragmentDefinition); ok {
+				addSelections(sel, fragment.SelectionSet, fragments)
+			}
+		}
+	}
+}
@@ -9,8 +9,15 @@ import (
 
 	"github.com/functionalfoundry/graphqlws"
 	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
 )
 
+// How often the viewers of open subscriptions are checked again
+const subscriptionRevalidateInterval = time.Minute
+
+// How many checks go by between subscription updates
+const subscriptionUpdateTicks = 5
+
 func init() {
 	common.SubscriptionManager = graphqlws.NewSubscriptionManager(&Schema)
 	common.GraphqlwsHandler = graphqlws.NewHandler(graphqlws.HandlerConfig{
@@ -18,20 +25,24 @@ func init() {
 		SubscriptionManager: common.SubscriptionManager,
 
 		// Optional: Add a hook to resolve auth tokens into users that are
-		// then stored on the GraphQL WS connections
+		// then stored on the GraphQL WS connections. Connections outlive their tokens, so they are checked again on every tick.
 		Authenticate: func(authToken string) (interface{}, error) {
-			data, _, err := auth.VerifyAccessToken(authToken)
+			viewer := auth.NewConnectionViewer(authToken)
+			if viewer.Err != nil {
+				return nil, viewer.Err
+			}
+			err := auth.RequireScope(viewer.Claims, auth.ScopeRead)
 			if err != nil {
 				return nil, err
 			}
-			return data["username"].(string), nil
+			return viewer, nil
 		},
 	})
 
 	go func() {
-		for {
-			// Every 5 mins, update the subscriptions
-			time.Sleep(5 * time.Minute)
+		for tick := 1; ; tick++ {
+			// Every minute, end subscriptions whose viewer can't be let in anymore, and every 5 mins, update the rest
+			time.Sleep(subscriptionRevalidateInterval)
 			subscriptions := common.SubscriptionManager.Subscriptions()
 
 			for conn := range subscriptions {
@@ -39,6 +50,21 @@ func init() {
 				conn.ID()   // The connection ID
 				conn.User() // The user returned from the Authenticate function
 
+				viewer, _ := conn.User().(*auth.Viewer)
+				if viewer == nil {
+					viewer = &auth.Viewer{}
+				}
+				// An expired token, a suspension, a password change or a revoked session all end the subscriptions
+				err := viewer.Revalidate()
+				if err != nil {
+					conn.SendError(err)
+					common.SubscriptionManager.RemoveSubscriptions(conn)
+					continue
+				}
+				if tick%subscriptionUpdateTicks != 0 {
+					continue
+				}
+
 				for _, subscription := range subscriptions[conn] {
 					// Things you have access to here:
 					// subscription.ID            // The subscription ID (unique per conn)
@@ -49,13 +75,23 @@ func init() {
 					// subscription.Fields        // The names of top-level queries
 					// subscription.Connection    // The GraphQL WS connection
 
-					// Re-execute the subscription query
+					// Queries that ask for too much are never run
+					err := CheckQueryLimits(subscription.Query, subscription.Variables, subscription.OperationName)
+					if err != nil {
+						subscription.SendData(&graphqlws.DataMessagePayload{
+							Errors: graphqlws.ErrorsFromGraphQLErrors(gqlerrors.FormatErrors(err)),
+						})
+						continue
+					}
+
+					// Re-execute the subscription query as the viewer the connection was authenticated as
 					params := graphql.Params{
 						Schema:         Schema, // The GraphQL schema
 						RequestString:  subscription.Query,
 						VariableValues: subscription.Variables,
 						OperationName:  subscription.OperationName,
-						Context:        common.BaseCtx,
+						RootObject:     map[string]interface{}{},
+						Context:        auth.WithViewer(common.BaseCtx, viewer),
 					}
 					result := graphql.Do(params)
 
@@ -0,0 +1,73 @@
+package gql
+
+import (
+	"github.com/soumitradev/Dwitter/backend/apierr"
+	"github.com/soumitradev/Dwitter/backend/auth"
+
+	"github.com/graphql-go/graphql"
+)
+
+// A resolver that is told who is asking
+type viewerResolveFn func(params graphql.ResolveParams, viewer *auth.Viewer) (interface{}, error)
+
+// The viewer of the request being resolved, who was found once by middleware.ViewerHandler
+func requestViewer(params graphql.ResolveParams) (*auth.Viewer, error) {
+	viewer := auth.ViewerFrom(params.Context)
+	if viewer.Err != nil {
+		return nil, viewer.Err
+	}
+	return viewer, nil
+}
+
+// Resolve a field for logged in and logged out viewers alike. Logged in viewers need a token with scope.
+func optionalAuth(scope string, resolve viewerResolveFn) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		viewer, err := requestViewer(params)
+		if err != nil {
+			return nil, err
+		}
+		if viewer.LoggedIn() {
+			err = auth.RequireScope(viewer.Claims, scope)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return resolve(params, viewer)
+	}
+}
+
+// Resolve a field only for logged in viewers with a token with scope
+func requireAuth(scope string, resolve viewerResolveFn) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		viewer, err := requestViewer(params)
+		if err != nil {
+			return nil, err
+		}
+		if !viewer.LoggedIn() {
+			return nil, apierr.NewUnauthenticated("Unauthorized")
+		}
+		err = auth.RequireScope(viewer.Claims, scope)
+		if err != nil {
+			return nil, err
+		}
+		return resolve(params, viewer)
+	}
+}
+
+// Resolve a field only for logged in viewers with a role, or one above it
+func requireRole(role string, resolve viewerResolveFn) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		viewer, err := requestViewer(params)
+		if err != nil {
+			return nil, err
+		}
+		if !viewer.LoggedIn() {
+			return nil, apierr.NewUnauthenticated("Unauthorized")
+		}
+		err = auth.RequireRole(viewer.Claims, role)
+		if err != nil {
+			return nil, err
+		}
+		return resolve(params, viewer)
+	}
+}
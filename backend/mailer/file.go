@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A FileMailer writes emails to .eml files in a directory instead of sending them, for development and testing
+type FileMailer struct {
+	dir string
+}
+
+// Create a file mailer, creating its directory if it doesn't exist
+func NewFileMailer(dir string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	encoded, err := msg.MIME()
+	if err != nil {
+		return err
+	}
+
+	// Name files so they sort by when they were sent, and say who they were for
+	recipient := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, msg.To)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), recipient)
+	return os.WriteFile(filepath.Join(m.dir, name), encoded, 0600)
+}
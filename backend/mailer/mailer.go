@@ -0,0 +1,172 @@
+// Package mailer sends the emails this API sends to users, through whichever backend is configured.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// A Message is a rendered email, with an HTML body and a plain-text alternative
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// A Mailer delivers emails
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// The mailer emails are sent through
+var current Mailer
+
+// The address emails are sent from
+var fromAddress string
+
+// Pick a mailer based on MAIL_BACKEND, which is one of "sendgrid", "smtp" or "file".
+// If it isn't set, SendGrid is used when SENDGRID_API_KEY is set, and emails are written to files otherwise.
+func Init() error {
+	fromAddress = os.Getenv("MAIL_FROM")
+	if fromAddress == "" {
+		fromAddress = os.Getenv("SENDGRID_SENDER_EMAIL_ADDR")
+	}
+
+	backend := os.Getenv("MAIL_BACKEND")
+	if backend == "" {
+		if os.Getenv("SENDGRID_API_KEY") != "" {
+			backend = "sendgrid"
+		} else {
+			backend = "file"
+		}
+	}
+
+	switch backend {
+	case "sendgrid":
+		apiKey := os.Getenv("SENDGRID_API_KEY")
+		if apiKey == "" {
+			return fmt.Errorf("MAIL_BACKEND is sendgrid, but SENDGRID_API_KEY is not set")
+		}
+		current = NewSendGridMailer(apiKey)
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return fmt.Errorf("MAIL_BACKEND is smtp, but SMTP_HOST is not set")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		current = NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		fileMailer, err := NewFileMailer(dir)
+		if err != nil {
+			return err
+		}
+		log.Printf("Emails are not being sent, they are written to %s instead", dir)
+		current = fileMailer
+	default:
+		return fmt.Errorf("unknown MAIL_BACKEND %q, it must be sendgrid, smtp or file", backend)
+	}
+
+	if fromAddress == "" {
+		return fmt.Errorf("MAIL_FROM is not set")
+	}
+	return nil
+}
+
+// Use a specific mailer, like one that records emails instead of sending them
+func SetMailer(mailer Mailer, from string) {
+	current = mailer
+	fromAddress = from
+}
+
+// Render an email template and send it
+func Send(to string, templateName string, data map[string]interface{}) error {
+	if current == nil {
+		return fmt.Errorf("mailer not initialized")
+	}
+
+	msg, err := render(templateName, data)
+	if err != nil {
+		return err
+	}
+	msg.From = fromAddress
+	msg.To = to
+	return current.Send(msg)
+}
+
+// Encode a message in MIME format, with the plain-text and HTML bodies as alternatives
+func (msg Message) MIME() ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		// Clients show the last alternative they support, so HTML goes last
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writer, err := parts.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	messageID := make([]byte, 16)
+	if _, err := rand.Read(messageID); err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(msg.From, "@"); at != -1 {
+		domain = msg.From[at+1:]
+	}
+
+	var encoded bytes.Buffer
+	headers := [][2]string{
+		{"From", mime.QEncoding.Encode("utf-8", "Dwitter") + " <" + msg.From + ">"},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(messageID) + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	}
+	for _, header := range headers {
+		encoded.WriteString(header[0] + ": " + header[1] + "\r\n")
+	}
+	encoded.WriteString("\r\n")
+	encoded.Write(body.Bytes())
+	return encoded.Bytes(), nil
+}
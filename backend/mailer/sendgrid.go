@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"fmt"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// A SendGridMailer sends emails through the SendGrid API
+type SendGridMailer struct {
+	client *sendgrid.Client
+}
+
+func NewSendGridMailer(apiKey string) *SendGridMailer {
+	return &SendGridMailer{client: sendgrid.NewSendClient(apiKey)}
+}
+
+func (m *SendGridMailer) Send(msg Message) error {
+	from := mail.NewEmail("Dwitter", msg.From)
+	to := mail.NewEmail("", msg.To)
+	message := mail.NewSingleEmail(from, msg.Subject, to, msg.Text, msg.HTML)
+	response, err := m.client.Send(message)
+	if err != nil {
+		return err
+	}
+	// SendGrid reports rejected emails in the response instead of as an error
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid returned %d: %s", response.StatusCode, response.Body)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// An SMTPMailer sends emails through an SMTP server, using STARTTLS when the server supports it
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+}
+
+// Create an SMTP mailer. The username can be empty for servers that don't need authentication.
+func NewSMTPMailer(host string, port string, username string, password string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{addr: net.JoinHostPort(host, port), auth: auth}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	encoded, err := msg.MIME()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, msg.From, []string{msg.To}, encoded)
+}
@@ -0,0 +1,75 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// Each email has an HTML template, which is wrapped in layout.html, and a plain-text template
+//
+//go:embed templates
+var templateFiles embed.FS
+
+// Subjects of the emails, by template name
+var subjects = map[string]string{
+	"verification":         "Dwitter account verification",
+	"password_reset":       "Dwitter password reset",
+	"magic_link":           "Your Dwitter login link",
+	"password_changed":     "Your Dwitter password was changed",
+	"lockout":              "Your Dwitter account was temporarily locked",
+	"email_change_confirm": "Confirm your new Dwitter email",
+	"email_change_notice":  "Your Dwitter email is being changed",
+	"account_deletion":     "Your Dwitter account will be deleted",
+	"export_ready":         "Your Dwitter data export is ready",
+}
+
+// An emailTemplate stores the parsed templates of an email
+type emailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+var templates = map[string]emailTemplate{}
+
+// Parse all templates on startup, so a broken template is found right away instead of when it is first sent
+func init() {
+	for name := range subjects {
+		html, err := htmltemplate.ParseFS(templateFiles, "templates/layout.html", "templates/"+name+".html")
+		if err != nil {
+			panic(fmt.Errorf("error parsing email template %s: %v", name, err))
+		}
+		text, err := texttemplate.ParseFS(templateFiles, "templates/"+name+".txt")
+		if err != nil {
+			panic(fmt.Errorf("error parsing email template %s: %v", name, err))
+		}
+		templates[name] = emailTemplate{html: html, text: text}
+	}
+}
+
+// Render an email from its templates
+func render(templateName string, data map[string]interface{}) (Message, error) {
+	tmpl, ok := templates[templateName]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email template %q", templateName)
+	}
+
+	var html bytes.Buffer
+	err := tmpl.html.ExecuteTemplate(&html, "layout", data)
+	if err != nil {
+		return Message{}, err
+	}
+	var text bytes.Buffer
+	err = tmpl.text.Execute(&text, data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: subjects[templateName],
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/soumitradev/Dwitter/backend/auth"
 	"github.com/soumitradev/Dwitter/backend/common"
 
 	"github.com/gorilla/handlers"
@@ -27,6 +28,14 @@ func SizeHandler(next http.Handler) http.Handler {
 	})
 }
 
+// Verify the access token of a request once, and pass who it belongs to down in the request's context
+func ViewerHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		viewer := auth.NewViewer(auth.SplitAuthToken(r.Header.Get("authorization")))
+		next.ServeHTTP(w, r.WithContext(auth.WithViewer(r.Context(), viewer)))
+	})
+}
+
 // Log requests
 func LoggingHandler(next http.Handler) http.Handler {
 	return handlers.CombinedLoggingHandler(os.Stdout, next)
@@ -0,0 +1,53 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Load the providers listed in OAUTH_PROVIDERS, e.g. "discord,github,google".
+// Each provider is configured with OAUTH_<NAME>_CLIENT_ID, OAUTH_<NAME>_CLIENT_SECRET and OAUTH_<NAME>_REDIRECT_URL.
+// Providers other than Discord and GitHub are OpenID Connect providers and also need OAUTH_<NAME>_ISSUER.
+func LoadFromEnv(ctx context.Context, client *http.Client) (map[string]Provider, error) {
+	providers := make(map[string]Provider)
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+		config := Config{
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		if config.ClientID == "" || config.ClientSecret == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("OAuth provider %s is missing client credentials or redirect URL", name)
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			config.Scopes = strings.Fields(scopes)
+		}
+
+		switch name {
+		case "discord":
+			providers[name] = NewDiscordProvider(config, DiscordEndpoint, client)
+		case "github":
+			providers[name] = NewGitHubProvider(config, GitHubEndpoint, GitHubEmailsURL, client)
+		default:
+			issuer := os.Getenv(prefix + "ISSUER")
+			if issuer == "" {
+				return nil, fmt.Errorf("OAuth provider %s needs %sISSUER", name, prefix)
+			}
+			provider, err := NewOIDCProvider(ctx, name, issuer, config, client)
+			if err != nil {
+				return nil, err
+			}
+			providers[name] = provider
+		}
+	}
+	return providers, nil
+}
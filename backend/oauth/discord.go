@@ -0,0 +1,70 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Discord's OAuth2 endpoints
+var DiscordEndpoint = Endpoint{
+	AuthURL:     "https://discord.com/api/oauth2/authorize",
+	TokenURL:    "https://discord.com/api/oauth2/token",
+	UserInfoURL: "https://discord.com/api/users/@me",
+}
+
+// A discordUser stores the user data returned by Discord
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	AvatarHash string `json:"avatar"`
+	Email      string `json:"email"`
+	Verified   bool   `json:"verified"`
+}
+
+type discordProvider struct {
+	baseProvider
+}
+
+// Create a provider that logs users in with Discord
+func NewDiscordProvider(config Config, endpoint Endpoint, client *http.Client) Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"identify", "email"}
+	}
+	return &discordProvider{baseProvider{
+		name:     "discord",
+		config:   config,
+		endpoint: endpoint,
+		client:   client,
+	}}
+}
+
+func (p *discordProvider) FetchProfile(ctx context.Context, token Token) (Profile, error) {
+	var user discordUser
+	err := p.getJSON(ctx, p.endpoint.UserInfoURL, token, &user)
+	if err != nil {
+		return Profile{}, err
+	}
+	if user.ID == "" {
+		return Profile{}, errors.New("discord returned a user without an ID")
+	}
+
+	name := user.GlobalName
+	if name == "" {
+		name = user.Username
+	}
+	avatarURL := ""
+	if user.AvatarHash != "" {
+		avatarURL = "https://cdn.discordapp.com/avatars/" + user.ID + "/" + user.AvatarHash + ".png"
+	}
+
+	return Profile{
+		ID:            user.ID,
+		Username:      user.Username,
+		Name:          name,
+		Email:         user.Email,
+		EmailVerified: user.Verified,
+		AvatarURL:     avatarURL,
+	}, nil
+}
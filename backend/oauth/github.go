@@ -0,0 +1,90 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// GitHub's OAuth2 endpoints. GitHub has no standard user info endpoint, so emails are fetched separately.
+var GitHubEndpoint = Endpoint{
+	AuthURL:     "https://github.com/login/oauth/authorize",
+	TokenURL:    "https://github.com/login/oauth/access_token",
+	UserInfoURL: "https://api.github.com/user",
+}
+
+// Where GitHub lists a user's email addresses
+var GitHubEmailsURL = "https://api.github.com/user/emails"
+
+// A githubUser stores the user data returned by GitHub
+type githubUser struct {
+	ID        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+// A githubEmail stores one of a GitHub user's email addresses
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+type githubProvider struct {
+	baseProvider
+	emailsURL string
+}
+
+// Create a provider that logs users in with GitHub
+func NewGitHubProvider(config Config, endpoint Endpoint, emailsURL string, client *http.Client) Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"read:user", "user:email"}
+	}
+	return &githubProvider{
+		baseProvider: baseProvider{
+			name:     "github",
+			config:   config,
+			endpoint: endpoint,
+			client:   client,
+		},
+		emailsURL: emailsURL,
+	}
+}
+
+func (p *githubProvider) FetchProfile(ctx context.Context, token Token) (Profile, error) {
+	var user githubUser
+	err := p.getJSON(ctx, p.endpoint.UserInfoURL, token, &user)
+	if err != nil {
+		return Profile{}, err
+	}
+	if user.ID == 0 {
+		return Profile{}, errors.New("github returned a user without an ID")
+	}
+
+	// The public profile email may be empty or unverified, so use the primary email instead
+	var emails []githubEmail
+	err = p.getJSON(ctx, p.emailsURL, token, &emails)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	profile := Profile{
+		ID:        strconv.FormatInt(user.ID, 10),
+		Username:  user.Login,
+		Name:      user.Name,
+		AvatarURL: user.AvatarURL,
+	}
+	if profile.Name == "" {
+		profile.Name = user.Login
+	}
+	for _, email := range emails {
+		if email.Primary {
+			profile.Email = email.Email
+			profile.EmailVerified = email.Verified
+			break
+		}
+	}
+	return profile, nil
+}
@@ -0,0 +1,107 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// An oidcDiscovery stores the fields of an OpenID Connect discovery document this API uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// An oidcUserInfo stores the standard claims returned by an OpenID Connect user info endpoint
+type oidcUserInfo struct {
+	Subject           string      `json:"sub"`
+	PreferredUsername string      `json:"preferred_username"`
+	Name              string      `json:"name"`
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"`
+	Picture           string      `json:"picture"`
+}
+
+type oidcProvider struct {
+	baseProvider
+}
+
+// Create a provider for any OpenID Connect issuer, using its discovery document to find its endpoints
+func NewOIDCProvider(ctx context.Context, name string, issuer string, config Config, client *http.Client) (Provider, error) {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	p := &oidcProvider{baseProvider{
+		name:   name,
+		config: config,
+		client: client,
+	}}
+
+	issuer = strings.TrimSuffix(issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var discovery oidcDiscovery
+	err = p.doJSON(req, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed for %s: %v", name, err)
+	}
+
+	// A discovery document for another issuer means something is misconfigured, or worse
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC discovery failed for %s: issuer mismatch, got %q", name, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.UserInfoEndpoint == "" {
+		return nil, fmt.Errorf("OIDC discovery failed for %s: missing endpoints", name)
+	}
+
+	p.endpoint = Endpoint{
+		AuthURL:     discovery.AuthorizationEndpoint,
+		TokenURL:    discovery.TokenEndpoint,
+		UserInfoURL: discovery.UserInfoEndpoint,
+	}
+	return p, nil
+}
+
+func (p *oidcProvider) FetchProfile(ctx context.Context, token Token) (Profile, error) {
+	var info oidcUserInfo
+	err := p.getJSON(ctx, p.endpoint.UserInfoURL, token, &info)
+	if err != nil {
+		return Profile{}, err
+	}
+	if info.Subject == "" {
+		return Profile{}, errors.New(p.name + " returned a user without a subject")
+	}
+
+	// Some providers send email_verified as a string
+	verified := false
+	switch v := info.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+
+	username := info.PreferredUsername
+	if username == "" && info.Email != "" {
+		username = strings.Split(info.Email, "@")[0]
+	}
+	name := info.Name
+	if name == "" {
+		name = username
+	}
+
+	return Profile{
+		ID:            info.Subject,
+		Username:      username,
+		Name:          name,
+		Email:         info.Email,
+		EmailVerified: verified,
+		AvatarURL:     info.Picture,
+	}, nil
+}
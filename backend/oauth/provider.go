@@ -0,0 +1,157 @@
+// Package oauth provides OAuth2 and OpenID Connect login providers for this API.
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A Provider is an external identity provider users can log in with
+type Provider interface {
+	// Name of the provider, as used in routes and stored on users
+	Name() string
+	// URL to send the user to so they can authorize this API
+	AuthCodeURL(state string, codeChallenge string) string
+	// Exchange an authorization code for tokens
+	Exchange(ctx context.Context, code string, codeVerifier string) (Token, error)
+	// Fetch the profile of the user the tokens belong to
+	FetchProfile(ctx context.Context, token Token) (Profile, error)
+}
+
+// A Config stores the client credentials registered with a provider
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// A Token stores the tokens a provider returns for an authorization code
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
+	IDToken      string `json:"id_token"`
+}
+
+// A Profile stores what a provider knows about a user
+type Profile struct {
+	// ID of the user at the provider. Unlike the username, it never changes.
+	ID            string
+	Username      string
+	Name          string
+	Email         string
+	EmailVerified bool
+	AvatarURL     string
+}
+
+// An Endpoint stores the URLs of a provider's OAuth2 endpoints
+type Endpoint struct {
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+}
+
+// A baseProvider implements the authorization code flow shared by all providers
+type baseProvider struct {
+	name     string
+	config   Config
+	endpoint Endpoint
+	client   *http.Client
+}
+
+func (p *baseProvider) Name() string {
+	return p.name
+}
+
+// Generate the PKCE code challenge for a code verifier using the S256 method
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *baseProvider) AuthCodeURL(state string, codeChallenge string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.endpoint.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.endpoint.AuthURL + separator + query.Encode()
+}
+
+func (p *baseProvider) Exchange(ctx context.Context, code string, codeVerifier string) (Token, error) {
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", p.config.RedirectURL)
+	data.Set("client_id", p.config.ClientID)
+	data.Set("client_secret", p.config.ClientSecret)
+	data.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoint.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token Token
+	err = p.doJSON(req, &token)
+	if err != nil {
+		return Token{}, fmt.Errorf("token exchange failed: %v", err)
+	}
+	if token.AccessToken == "" {
+		return Token{}, errors.New("token exchange failed: no access token returned")
+	}
+	return token, nil
+}
+
+// Make a GET request authorized with an access token and decode the JSON response
+func (p *baseProvider) getJSON(ctx context.Context, endpoint string, token Token, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	return p.doJSON(req, dst)
+}
+
+// Send a request and decode the JSON response, turning non-200 responses into errors
+func (p *baseProvider) doJSON(req *http.Request, dst interface{}) error {
+	client := p.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dst)
+}
@@ -0,0 +1,65 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// How many hex characters of the SHA-1 hash pick the file a password is looked up in
+const breachedPrefixLength = 5
+
+// A BreachedList is an offline copy of the Have I Been Pwned password list, split by hash prefix.
+// The directory has one file per 5 character prefix of the uppercase SHA-1 hash, like "21BD1" or "21BD1.txt",
+// holding "SUFFIX:COUNT" lines, which is what the range API returns.
+type BreachedList struct {
+	dir string
+}
+
+// Use the breached password list in dir
+func NewBreachedList(dir string) (*BreachedList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening breached password list: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password list %s is not a directory", dir)
+	}
+	return &BreachedList{dir: dir}, nil
+}
+
+// Check if a password is in the list. Only the file for its hash prefix is read.
+func (list *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	file, err := os.Open(filepath.Join(list.dir, prefix))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(list.dir, prefix+".txt"))
+	}
+	// No file means nothing with this prefix was ever breached
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if colon := strings.IndexByte(line, ':'); colon != -1 {
+			line = line[:colon]
+		}
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,110 @@
+package passwords
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The uppercase SHA-1 hash of a password, split where BreachedList splits it
+func breachedHash(password string) (string, string) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+}
+
+// Make a breached password list holding passwords, with a file per prefix like the range API returns
+func newTestBreachedList(t *testing.T, passwords ...string) *BreachedList {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{}
+	for _, password := range passwords {
+		prefix, suffix := breachedHash(password)
+		files[prefix] += suffix + ":42\n"
+	}
+	for prefix, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, prefix), []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("could not write breached password list: %v", err)
+		}
+	}
+
+	list, err := NewBreachedList(dir)
+	if err != nil {
+		t.Fatalf("could not open breached password list: %v", err)
+	}
+	return list
+}
+
+func TestBreachedListContains(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, contents string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("could not write breached password list: %v", err)
+		}
+	}
+
+	prefix, suffix := breachedHash("password")
+	// Another suffix under the same prefix, which doesn't belong to any password in the tests
+	otherSuffix := strings.Repeat("0", len(suffix))
+	write(prefix, otherSuffix+":3\r\n"+suffix+":9545824\r\n")
+
+	// Files can also end in .txt, and suffixes can be lowercase or have no count
+	txtPrefix, txtSuffix := breachedHash("letmein")
+	write(txtPrefix+".txt", strings.ToLower(txtSuffix)+"\n")
+
+	// A password whose prefix file exists but doesn't list it
+	missingPrefix, missingSuffix := breachedHash("not breached")
+	write(missingPrefix, otherSuffix+":1\n")
+	if missingSuffix == otherSuffix {
+		t.Fatal("the made up suffix is the real one")
+	}
+
+	list, err := NewBreachedList(dir)
+	if err != nil {
+		t.Fatalf("could not open breached password list: %v", err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{password: "password", want: true},
+		{password: "letmein", want: true},
+		{password: "not breached", want: false},
+		// No file for the prefix means nothing with it was breached
+		{password: "Battery-Staple9", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			got, err := list.Contains(test.password)
+			if err != nil {
+				t.Fatalf("could not check password: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewBreachedList(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "list.txt")
+	err := ioutil.WriteFile(file, []byte{}, 0600)
+	if err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+
+	for _, path := range []string{filepath.Join(dir, "missing"), file} {
+		_, err := NewBreachedList(path)
+		if err == nil {
+			t.Errorf("opened %s, want an error", path)
+		}
+	}
+}
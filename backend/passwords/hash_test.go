@@ -0,0 +1,93 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hash a password like Hash does, but with other parameters, like hashes made before the defaults changed
+func hashWithParams(t *testing.T, password string, params Argon2Params) string {
+	t.Helper()
+	salt := make([]byte, params.SaltLength)
+	rand.Read(salt)
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func TestVerify(t *testing.T) {
+	const password = "correct horse battery staple"
+
+	current, err := Hash(password)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password with bcrypt: %v", err)
+	}
+	weaker := hashWithParams(t, password, Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	shorterSalt := hashWithParams(t, password, Argon2Params{Memory: DefaultParams.Memory, Iterations: DefaultParams.Iterations, Parallelism: DefaultParams.Parallelism, SaltLength: 8, KeyLength: 32})
+
+	tests := []struct {
+		name            string
+		password        string
+		hash            string
+		wantMatches     bool
+		wantNeedsRehash bool
+		wantErr         bool
+	}{
+		{name: "current hash", password: password, hash: current, wantMatches: true},
+		{name: "current hash with the wrong password", password: "wrong", hash: current},
+		{name: "bcrypt hash is upgraded", password: password, hash: string(bcryptHash), wantMatches: true, wantNeedsRehash: true},
+		{name: "bcrypt hash with the wrong password", password: "wrong", hash: string(bcryptHash)},
+		{name: "argon2id hash with old parameters is upgraded", password: password, hash: weaker, wantMatches: true, wantNeedsRehash: true},
+		{name: "argon2id hash with old parameters and the wrong password", password: "wrong", hash: weaker},
+		{name: "argon2id hash with a shorter salt is upgraded", password: password, hash: shorterSalt, wantMatches: true, wantNeedsRehash: true},
+		{name: "empty hash", password: password, hash: "", wantErr: true},
+		{name: "argon2i hash", password: password, hash: "$argon2i$v=19$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5", wantErr: true},
+		{name: "other argon2 version", password: password, hash: "$argon2id$v=16$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5", wantErr: true},
+		{name: "no iterations", password: password, hash: "$argon2id$v=19$m=65536,t=0,p=2$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5", wantErr: true},
+		{name: "salt that isn't base64", password: password, hash: "$argon2id$v=19$m=65536,t=3,p=2$!!!$a2V5a2V5a2V5", wantErr: true},
+		{name: "empty key", password: password, hash: "$argon2id$v=19$m=65536,t=3,p=2$c2FsdHNhbHRzYWx0$", wantErr: true},
+		{name: "broken bcrypt hash", password: password, hash: "$2a$10$tooshort", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, needsRehash, err := Verify(test.password, test.hash)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, test.wantErr)
+			}
+			if matches != test.wantMatches || needsRehash != test.wantNeedsRehash {
+				t.Errorf("got matches %v and needsRehash %v, want %v and %v", matches, needsRehash, test.wantMatches, test.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestHashIsSalted(t *testing.T) {
+	first, err := Hash("hunter22")
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	second, err := Hash("hunter22")
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	if first == second {
+		t.Errorf("hashing the same password twice gave %s both times", first)
+	}
+}
@@ -0,0 +1,204 @@
+package passwords
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+)
+
+// Codes of the reasons a password can break the policy
+const (
+	ReasonTooShort = "too_short"
+	ReasonTooLong  = "too_long"
+	ReasonTooWeak  = "too_weak"
+	ReasonBreached = "breached"
+)
+
+// A Violation is one reason a password doesn't follow the policy
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// A PolicyError lists every reason a password was rejected
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return "password rejected: " + strings.Join(messages, "; ")
+}
+
+// Extensions lets GraphQL responses carry the reasons along with the message.
+// The code is the same as the one other invalid arguments have.
+func (e *PolicyError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":    apierr.Validation,
+		"field":   "password",
+		"reasons": e.Violations,
+	}
+}
+
+// A Policy is what passwords are checked against
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// The lowest EstimateEntropy a password can have
+	MinEntropyBits float64
+	// Passwords known from data breaches, or nil to not check
+	Breached *BreachedList
+}
+
+// The policy passwords are checked against, until Init loads one from the environment
+var current = Policy{
+	MinLength:      8,
+	MaxLength:      128,
+	MinEntropyBits: 35,
+}
+
+// Load the policy from PASSWORD_MIN_LENGTH, PASSWORD_MIN_ENTROPY and BREACHED_PASSWORDS_DIR.
+// Anything that isn't set keeps its default.
+func Init() error {
+	policy := current
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		minLength, err := strconv.Atoi(value)
+		if err != nil || minLength < 1 || minLength > policy.MaxLength {
+			return fmt.Errorf("PASSWORD_MIN_LENGTH must be a number between 1 and %d", policy.MaxLength)
+		}
+		policy.MinLength = minLength
+	}
+	if value := os.Getenv("PASSWORD_MIN_ENTROPY"); value != "" {
+		minEntropy, err := strconv.ParseFloat(value, 64)
+		if err != nil || minEntropy < 0 {
+			return fmt.Errorf("PASSWORD_MIN_ENTROPY must be a positive number of bits")
+		}
+		policy.MinEntropyBits = minEntropy
+	}
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		breached, err := NewBreachedList(dir)
+		if err != nil {
+			return err
+		}
+		policy.Breached = breached
+	}
+	current = policy
+	return nil
+}
+
+// Use a specific policy
+func SetPolicy(policy Policy) {
+	current = policy
+}
+
+// Check a password against the current policy, returning a *PolicyError if it breaks it
+func Validate(password string) error {
+	return current.Check(password)
+}
+
+// Check a password against the policy, returning a *PolicyError with every reason it breaks it, if it does
+func (p Policy) Check(password string) error {
+	violations := []Violation{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    ReasonTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    ReasonTooLong,
+			Message: fmt.Sprintf("password must be at most %d characters long", p.MaxLength),
+		})
+	}
+	// A password that's too short is also weak, but saying so twice doesn't help anyone
+	if length >= p.MinLength && EstimateEntropy(password) < p.MinEntropyBits {
+		violations = append(violations, Violation{
+			Code:    ReasonTooWeak,
+			Message: "password is too easy to guess, try a longer one or mix in other kinds of characters",
+		})
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return apierr.NewInternal(err)
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Code:    ReasonBreached,
+				Message: "password has appeared in a data breach, pick one that hasn't",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// Roughly estimate how many bits of entropy a password has.
+// Each character counts for the size of the character sets the password draws from,
+// except characters that repeat or continue a sequence of the one before, like "aaa" or "123", which barely count.
+func EstimateEntropy(password string) float64 {
+	var hasLower, hasUpper, hasDigit, hasSymbol, hasOther bool
+	for _, char := range password {
+		switch {
+		case char >= 'a' && char <= 'z':
+			hasLower = true
+		case char >= 'A' && char <= 'Z':
+			hasUpper = true
+		case char >= '0' && char <= '9':
+			hasDigit = true
+		case char < unicode.MaxASCII && unicode.IsPrint(char):
+			hasSymbol = true
+		default:
+			hasOther = true
+		}
+	}
+
+	pool := 0
+	if hasLower {
+		pool += 26
+	}
+	if hasUpper {
+		pool += 26
+	}
+	if hasDigit {
+		pool += 10
+	}
+	if hasSymbol {
+		pool += 33
+	}
+	if hasOther {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+	bitsPerChar := math.Log2(float64(pool))
+
+	entropy := 0.0
+	previous := rune(-1)
+	for _, char := range password {
+		delta := char - previous
+		if previous != -1 && (delta == 0 || delta == 1 || delta == -1) {
+			entropy += 1
+		} else {
+			entropy += bitsPerChar
+		}
+		previous = char
+	}
+	return entropy
+}
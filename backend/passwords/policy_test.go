@@ -0,0 +1,83 @@
+package passwords
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/soumitradev/Dwitter/backend/apierr"
+)
+
+func TestEstimateEntropy(t *testing.T) {
+	lower := math.Log2(26)
+	tests := []struct {
+		password string
+		want     float64
+	}{
+		{password: "", want: 0},
+		// Repeats and sequences after the first character count for a bit each
+		{password: "aaaaaaaa", want: lower + 7},
+		{password: "abcdefgh", want: lower + 7},
+		{password: "hgfedcba", want: lower + 7},
+		{password: "password", want: 7*lower + 1},
+		{password: "Tr0ub4dor&3", want: 11 * math.Log2(26+26+10+33)},
+		{password: "pässwörd", want: 7*math.Log2(26+100) + 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			got := EstimateEntropy(test.password)
+			if math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("got %f bits, want %f", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	breached := newTestBreachedList(t, "Correct-Horse7")
+	policy := Policy{MinLength: 8, MaxLength: 16, MinEntropyBits: 35, Breached: breached}
+
+	tests := []struct {
+		name      string
+		password  string
+		wantCodes []string
+	}{
+		{name: "strong password", password: "Battery-Staple9"},
+		// Short passwords aren't also called weak
+		{name: "too short", password: "aB3$", wantCodes: []string{ReasonTooShort}},
+		{name: "too weak", password: "aaaaaaaaaaaa", wantCodes: []string{ReasonTooWeak}},
+		{name: "too long", password: "Battery-Staple9-Battery", wantCodes: []string{ReasonTooLong}},
+		{name: "breached", password: "Correct-Horse7", wantCodes: []string{ReasonBreached}},
+		{name: "every reason at once", password: "aaaaaaaaaaaaaaaaaaaa", wantCodes: []string{ReasonTooLong, ReasonTooWeak}},
+		// Lengths are counted in characters, not bytes
+		{name: "multibyte characters", password: "ééééééé", wantCodes: []string{ReasonTooShort}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Check(test.password)
+			if len(test.wantCodes) == 0 {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+
+			policyErr, ok := err.(*PolicyError)
+			if !ok {
+				t.Fatalf("got %v, want a *PolicyError", err)
+			}
+			codes := []string{}
+			for _, violation := range policyErr.Violations {
+				codes = append(codes, violation.Code)
+			}
+			if !reflect.DeepEqual(codes, test.wantCodes) {
+				t.Errorf("got reasons %v, want %v", codes, test.wantCodes)
+			}
+			if code := policyErr.Extensions()["code"]; code != apierr.Validation {
+				t.Errorf("got code %v, want %v", code, apierr.Validation)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package schema
+
+import "github.com/graphql-go/graphql"
+
+// Relay-style connections, for paging through lists with cursors instead of offsets.
+// See https://relay.dev/graphql/connections.htm
+
+// Where a page sits in the list it was taken from
+type PageInfoType struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor"`
+	EndCursor       *string `json:"endCursor"`
+}
+
+// A dweet in a connection, along with the cursor to continue after it
+type DweetEdgeType struct {
+	Cursor string         `json:"cursor"`
+	Node   BasicDweetType `json:"node"`
+}
+
+// A page of dweets
+type DweetConnectionType struct {
+	Edges    []DweetEdgeType `json:"edges"`
+	PageInfo PageInfoType    `json:"pageInfo"`
+}
+
+// A user in a connection, along with the cursor to continue after it
+type UserEdgeType struct {
+	Cursor string        `json:"cursor"`
+	Node   BasicUserType `json:"node"`
+}
+
+// A page of users
+type UserConnectionType struct {
+	Edges    []UserEdgeType `json:"edges"`
+	PageInfo PageInfoType   `json:"pageInfo"`
+}
+
+// A feed object in a connection, along with the cursor to continue after it.
+// The node is either a BasicDweetType or a RedweetType.
+type FeedObjectEdgeType struct {
+	Cursor string      `json:"cursor"`
+	Node   interface{} `json:"node"`
+}
+
+// A page of feed objects
+type FeedObjectConnectionType struct {
+	Edges    []FeedObjectEdgeType `json:"edges"`
+	PageInfo PageInfoType         `json:"pageInfo"`
+}
+
+// Make the page info of a page from the cursors of its edges
+func FormatAsPageInfoType(cursors []string, hasNextPage bool, hasPreviousPage bool) PageInfoType {
+	info := PageInfoType{
+		HasNextPage:     hasNextPage,
+		HasPreviousPage: hasPreviousPage,
+	}
+	if len(cursors) > 0 {
+		info.StartCursor = &cursors[0]
+		info.EndCursor = &cursors[len(cursors)-1]
+	}
+	return info
+}
+
+// GraphQL schema for page info
+var PageInfoSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"hasPreviousPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+			},
+			"startCursor": &graphql.Field{
+				Type: graphql.String,
+			},
+			"endCursor": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	},
+)
+
+// GraphQL schema for dweet edge
+var DweetEdgeSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "DweetEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"node": &graphql.Field{
+				Type: BasicDweetSchema,
+			},
+		},
+	},
+)
+
+// GraphQL schema for dweet connection
+var DweetConnectionSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "DweetConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewList(DweetEdgeSchema),
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(PageInfoSchema),
+			},
+		},
+	},
+)
+
+// GraphQL schema for user edge
+var UserEdgeSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "UserEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"node": &graphql.Field{
+				Type: BasicUserSchema,
+			},
+		},
+	},
+)
+
+// GraphQL schema for user connection
+var UserConnectionSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewList(UserEdgeSchema),
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(PageInfoSchema),
+			},
+		},
+	},
+)
+
+// GraphQL schema for feed object edge
+var FeedObjectEdgeSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "FeedObjectEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"node": &graphql.Field{
+				Type: BasicFeedObjectSchema,
+			},
+		},
+	},
+)
+
+// GraphQL schema for feed object connection
+var FeedObjectConnectionSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "FeedObjectConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{
+				Type: graphql.NewList(FeedObjectEdgeSchema),
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(PageInfoSchema),
+			},
+		},
+	},
+)
@@ -19,6 +19,7 @@ type BasicUserType struct {
 	FollowerCount  int       `json:"followerCount"`
 	FollowingCount int       `json:"followingCount"`
 	CreatedAt      time.Time `json:"createdAt"`
+	IsBot          bool      `json:"isBot"`
 }
 
 // A User object
@@ -38,6 +39,7 @@ type UserType struct {
 	FollowingCount  int              `json:"followingCount"`
 	Following       []BasicUserType  `json:"following"`
 	CreatedAt       time.Time        `json:"createdAt"`
+	IsBot           bool             `json:"isBot"`
 }
 
 // A Dweet object without any relation fields except for Author (a necessary relation field)
@@ -54,6 +56,7 @@ type BasicDweetType struct {
 	ReplyCount      int           `json:"replyCount"`
 	RedweetCount    int           `json:"redweetCount"`
 	Media           []string      `json:"media"`
+	Automated       bool          `json:"automated"`
 }
 
 // A Dweet object
@@ -74,6 +77,7 @@ type DweetType struct {
 	RedweetCount    int              `json:"redweetCount"`
 	RedweetUsers    []BasicUserType  `json:"redweetUsers"`
 	Media           []string         `json:"media"`
+	Automated       bool             `json:"automated"`
 }
 
 // A Redweet Object
@@ -85,6 +89,64 @@ type RedweetType struct {
 	RedweetTime       time.Time      `json:"redweetTime"`
 }
 
+// A TOTP enrollment, sent only once when two-factor authentication is set up
+type TOTPEnrollmentType struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// A refresh token session on one of a user's devices
+type SessionType struct {
+	ID          string    `json:"id"`
+	DeviceLabel string    `json:"deviceLabel"`
+	IPAddress   string    `json:"ipAddress"`
+	UserAgent   string    `json:"userAgent"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastUsedAt  time.Time `json:"lastUsedAt"`
+	Current     bool      `json:"current"`
+}
+
+// Tokens returned when a user logs in
+type AuthTokensType struct {
+	AccessToken string `json:"accessToken"`
+	JID         string `json:"jid"`
+}
+
+// A personal access token. Token is only set right after the token is created.
+type PersonalAccessTokenType struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+}
+
+// A passkey a user can log in with
+type PasskeyType struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// An external account linked to a user, which they can log in with
+type LinkedIdentityType struct {
+	Provider  string    `json:"provider"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// A personal data export. It can be downloaded from a link emailed to the user once it is ready.
+type DataExportType struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+}
+
 // GraphQL schema for basic user
 var BasicUserSchema = graphql.NewObject(
 	graphql.ObjectConfig{
@@ -114,6 +176,9 @@ var BasicUserSchema = graphql.NewObject(
 			"createdAt": &graphql.Field{
 				Type: graphql.DateTime,
 			},
+			"isBot": &graphql.Field{
+				Type: graphql.Boolean,
+			},
 		},
 	},
 )
@@ -168,6 +233,9 @@ var UserSchema = graphql.NewObject(
 			"createdAt": &graphql.Field{
 				Type: graphql.DateTime,
 			},
+			"isBot": &graphql.Field{
+				Type: graphql.Boolean,
+			},
 		},
 	},
 )
@@ -213,6 +281,9 @@ var BasicDweetSchema = graphql.NewObject(
 			"media": &graphql.Field{
 				Type: graphql.NewList(graphql.String),
 			},
+			"automated": &graphql.Field{
+				Type: graphql.Boolean,
+			},
 		},
 	},
 )
@@ -270,6 +341,9 @@ var DweetSchema = graphql.NewObject(
 			"media": &graphql.Field{
 				Type: graphql.NewList(graphql.String),
 			},
+			"automated": &graphql.Field{
+				Type: graphql.Boolean,
+			},
 		},
 	},
 )
@@ -298,6 +372,159 @@ var RedweetSchema = graphql.NewObject(
 	},
 )
 
+// GraphQL schema for TOTP enrollment
+var TOTPEnrollmentSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "TOTPEnrollment",
+		Fields: graphql.Fields{
+			"secret": &graphql.Field{
+				Type: graphql.String,
+			},
+			"uri": &graphql.Field{
+				Type: graphql.String,
+			},
+			"recoveryCodes": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+			},
+		},
+	},
+)
+
+// GraphQL schema for a session
+var SessionSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Session",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.String,
+			},
+			"deviceLabel": &graphql.Field{
+				Type: graphql.String,
+			},
+			"ipAddress": &graphql.Field{
+				Type: graphql.String,
+			},
+			"userAgent": &graphql.Field{
+				Type: graphql.String,
+			},
+			"createdAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"lastUsedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"current": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+		},
+	},
+)
+
+// GraphQL schema for login tokens
+var AuthTokensSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "AuthTokens",
+		Fields: graphql.Fields{
+			"accessToken": &graphql.Field{
+				Type: graphql.String,
+			},
+			"jid": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	},
+)
+
+// GraphQL schema for a personal access token
+var PersonalAccessTokenSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "PersonalAccessToken",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.String,
+			},
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"token": &graphql.Field{
+				Type: graphql.String,
+			},
+			"scopes": &graphql.Field{
+				Type: graphql.NewList(graphql.String),
+			},
+			"createdAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"lastUsedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"expiresAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	},
+)
+
+// GraphQL schema for a passkey
+var PasskeySchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Passkey",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.String,
+			},
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"createdAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"lastUsedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	},
+)
+
+// GraphQL schema for a linked identity
+var LinkedIdentitySchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "LinkedIdentity",
+		Fields: graphql.Fields{
+			"provider": &graphql.Field{
+				Type: graphql.String,
+			},
+			"createdAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	},
+)
+
+// GraphQL schema for a personal data export
+var DataExportSchema = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "DataExport",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.String,
+			},
+			"status": &graphql.Field{
+				Type: graphql.String,
+			},
+			"createdAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"completedAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+			"expiresAt": &graphql.Field{
+				Type: graphql.DateTime,
+			},
+		},
+	},
+)
+
 // A GraphQL union type for objects that may appear on a feed. i.e. Dweets and Redweets
 var FeedObjectSchema = graphql.NewUnion(graphql.UnionConfig{
 	Name:        "FeedObject",
@@ -2,11 +2,20 @@
 package schema
 
 import (
-	"errors"
+	"fmt"
 
+	"github.com/soumitradev/Dwitter/backend/apierr"
 	"github.com/soumitradev/Dwitter/backend/prisma/db"
 )
 
+// Format the author of a dweet or redweet, if it was fetched. Authors that weren't are resolved by the API later.
+func formatAuthor(author *db.UserModel) BasicUserType {
+	if author == nil {
+		return BasicUserType{}
+	}
+	return FormatAsBasicUserType(author)
+}
+
 // Format as BasicDweet
 func FormatAsBasicDweetType(dweet *db.DweetModel) BasicDweetType {
 	reply_id, present := dweet.OriginalReplyID()
@@ -16,7 +25,7 @@ func FormatAsBasicDweetType(dweet *db.DweetModel) BasicDweetType {
 	return BasicDweetType{
 		DweetBody:       dweet.DweetBody,
 		ID:              dweet.ID,
-		Author:          FormatAsBasicUserType(dweet.Author()),
+		Author:          formatAuthor(dweet.RelationsDweet.Author),
 		AuthorID:        dweet.AuthorID,
 		PostedAt:        dweet.PostedAt,
 		LastUpdatedAt:   dweet.LastUpdatedAt,
@@ -26,12 +35,13 @@ func FormatAsBasicDweetType(dweet *db.DweetModel) BasicDweetType {
 		ReplyCount:      dweet.ReplyCount,
 		RedweetCount:    dweet.RedweetCount,
 		Media:           dweet.Media,
+		Automated:       dweet.Automated,
 	}
 }
 
 // Format as Dweet
 func FormatAsDweetType(dweet *db.DweetModel, likeUsers []db.UserModel, redweetUsers []db.UserModel) DweetType {
-	author := FormatAsBasicUserType(dweet.Author())
+	author := formatAuthor(dweet.RelationsDweet.Author)
 
 	reply_id, present := dweet.OriginalReplyID()
 	if !present {
@@ -45,18 +55,28 @@ func FormatAsDweetType(dweet *db.DweetModel, likeUsers []db.UserModel, redweetUs
 		reply_to = BasicDweetType{}
 	}
 
+	// Lists left nil weren't fetched, and are resolved by the API later
 	var reply_dweets []BasicDweetType
-	reply_dweets_db_schema := dweet.ReplyDweets()
+	reply_dweets_db_schema := dweet.RelationsDweet.ReplyDweets
+	if reply_dweets_db_schema != nil {
+		reply_dweets = []BasicDweetType{}
+	}
 	for i := 0; i < len(reply_dweets_db_schema); i++ {
 		reply_dweets = append(reply_dweets, FormatAsBasicDweetType(&reply_dweets_db_schema[i]))
 	}
 
 	var likes []BasicUserType
+	if likeUsers != nil {
+		likes = []BasicUserType{}
+	}
 	for i := 0; i < len(likeUsers); i++ {
 		likes = append(likes, FormatAsBasicUserType((&likeUsers[i])))
 	}
 
 	var redweet_users []BasicUserType
+	if redweetUsers != nil {
+		redweet_users = []BasicUserType{}
+	}
 	for i := 0; i < len(redweetUsers); i++ {
 		redweet_users = append(redweet_users, FormatAsBasicUserType((&redweetUsers[i])))
 	}
@@ -78,6 +98,7 @@ func FormatAsDweetType(dweet *db.DweetModel, likeUsers []db.UserModel, redweetUs
 		RedweetCount:    dweet.RedweetCount,
 		RedweetUsers:    redweet_users,
 		Media:           dweet.Media,
+		Automated:       dweet.Automated,
 	}
 }
 
@@ -92,6 +113,7 @@ func FormatAsBasicUserType(user *db.UserModel) BasicUserType {
 		FollowerCount:  user.FollowerCount,
 		FollowingCount: user.FollowingCount,
 		CreatedAt:      user.CreatedAt,
+		IsBot:          user.IsBot,
 	}
 }
 
@@ -113,7 +135,7 @@ func FormatAsUserType(user *db.UserModel, alsoFollowedBy []db.UserModel, alsoFol
 			} else if redweet, ok := obj.(db.RedweetModel); ok {
 				feedObjects[index] = FormatAsRedweetType(&redweet)
 			} else {
-				return UserType{}, errors.New("internal server error")
+				return UserType{}, apierr.NewInternal(fmt.Errorf("unexpected %T in %s list", obj, objectsToFetch))
 			}
 		}
 	case "dweet":
@@ -122,7 +144,7 @@ func FormatAsUserType(user *db.UserModel, alsoFollowedBy []db.UserModel, alsoFol
 			if dweet, ok := obj.(db.DweetModel); ok {
 				dweets[index] = FormatAsBasicDweetType(&dweet)
 			} else {
-				return UserType{}, errors.New("internal server error")
+				return UserType{}, apierr.NewInternal(fmt.Errorf("unexpected %T in %s list", obj, objectsToFetch))
 			}
 		}
 	case "redweet":
@@ -131,7 +153,7 @@ func FormatAsUserType(user *db.UserModel, alsoFollowedBy []db.UserModel, alsoFol
 			if redweet, ok := obj.(db.RedweetModel); ok {
 				redweets[index] = FormatAsRedweetType(&redweet)
 			} else {
-				return UserType{}, errors.New("internal server error")
+				return UserType{}, apierr.NewInternal(fmt.Errorf("unexpected %T in %s list", obj, objectsToFetch))
 			}
 		}
 	case "redweetedDweet":
@@ -140,7 +162,7 @@ func FormatAsUserType(user *db.UserModel, alsoFollowedBy []db.UserModel, alsoFol
 			if dweet, ok := obj.(db.DweetModel); ok {
 				redweeted_dweets[index] = FormatAsBasicDweetType(&dweet)
 			} else {
-				return UserType{}, errors.New("internal server error")
+				return UserType{}, apierr.NewInternal(fmt.Errorf("unexpected %T in %s list", obj, objectsToFetch))
 			}
 		}
 	case "liked":
@@ -149,7 +171,7 @@ func FormatAsUserType(user *db.UserModel, alsoFollowedBy []db.UserModel, alsoFol
 			if dweet, ok := obj.(db.DweetModel); ok {
 				liked_dweets[index] = FormatAsBasicDweetType(&dweet)
 			} else {
-				return UserType{}, errors.New("internal server error")
+				return UserType{}, apierr.NewInternal(fmt.Errorf("unexpected %T in %s list", obj, objectsToFetch))
 			}
 		}
 	default:
@@ -188,15 +210,21 @@ func FormatAsUserType(user *db.UserModel, alsoFollowedBy []db.UserModel, alsoFol
 		FollowingCount:  user.FollowingCount,
 		Following:       following,
 		CreatedAt:       user.CreatedAt,
+		IsBot:           user.IsBot,
 	}, nil
 }
 
 // Format as Redweet
 func FormatAsRedweetType(redweet *db.RedweetModel) RedweetType {
+	var redweet_of BasicDweetType
+	if redweet.RelationsRedweet.RedweetOf != nil {
+		redweet_of = FormatAsBasicDweetType(redweet.RelationsRedweet.RedweetOf)
+	}
+
 	return RedweetType{
-		Author:            FormatAsBasicUserType(redweet.Author()),
+		Author:            formatAuthor(redweet.RelationsRedweet.Author),
 		AuthorID:          redweet.AuthorID,
-		RedweetOf:         FormatAsBasicDweetType(redweet.RedweetOf()),
+		RedweetOf:         redweet_of,
 		OriginalRedweetID: redweet.OriginalRedweetID,
 		RedweetTime:       redweet.RedweetTime,
 	}
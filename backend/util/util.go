@@ -4,7 +4,10 @@ package util
 
 import (
 	crypto_rand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	math_rand "math/rand"
 	"reflect"
 
@@ -47,6 +50,22 @@ func GenToken(n int) string {
 	return string(b)
 }
 
+// Make a cryptographically secure, URL-safe random token out of n random bytes
+func GenSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := crypto_rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash a token with SHA-256 so that it can be stored without storing the token itself
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 // Hash function to find intersection of two slices
 // with modifications from: https://github.com/juliangruber/go-intersect/blob/2e99d8c0a75f6975a52f7efeb81926a19b221214/intersect.go#L42-L62
 // Hash has complexity: O(n * x) where x is a factor of hash function efficiency (between 1 and 2)
@@ -0,0 +1,172 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Authenticators encode everything in the small, canonical subset of CBOR that CTAP2 allows,
+// so this decoder only handles definite lengths and the types WebAuthn actually uses.
+
+// How deeply CBOR arrays and maps can be nested before decoding gives up
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// Decode a single CBOR value from the start of data, and return it along with how many bytes it took up.
+// Integers are decoded as int64, byte strings as []byte, text as string, arrays as []interface{}
+// and maps as map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	return decodeCBORValue(data, 0)
+}
+
+// Read the argument that follows the initial byte of a CBOR item
+func readCBORArgument(data []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 0, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(data[0]), 1, nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data)), 2, nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, 0, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data)), 4, nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, 0, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(data), 8, nil
+	default:
+		return 0, 0, fmt.Errorf("cbor: unsupported additional info %d", info)
+	}
+}
+
+func decodeCBORValue(data []byte, depth int) (interface{}, int, error) {
+	if depth > maxCBORDepth {
+		return nil, 0, errors.New("cbor: nested too deeply")
+	}
+	if len(data) < 1 {
+		return nil, 0, errCBORTruncated
+	}
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	// Simple values and floats share their encoding with arguments, but don't mean a length
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, 1, nil
+		case 21:
+			return true, 1, nil
+		case 22, 23:
+			return nil, 1, nil
+		case 25:
+			// Half precision floats don't show up in anything WebAuthn verifies, so they're only skipped over
+			if len(data) < 3 {
+				return nil, 0, errCBORTruncated
+			}
+			return nil, 3, nil
+		case 26:
+			if len(data) < 5 {
+				return nil, 0, errCBORTruncated
+			}
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data[1:]))), 5, nil
+		case 27:
+			if len(data) < 9 {
+				return nil, 0, errCBORTruncated
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(data[1:])), 9, nil
+		default:
+			return nil, 0, fmt.Errorf("cbor: unsupported simple value %d", info)
+		}
+	}
+
+	argument, argumentLength, err := readCBORArgument(data[1:], info)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset := 1 + argumentLength
+
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, 0, errors.New("cbor: integer overflows int64")
+		}
+		return int64(argument), offset, nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, 0, errors.New("cbor: integer overflows int64")
+		}
+		return -1 - int64(argument), offset, nil
+	case 2, 3:
+		if argument > uint64(len(data)-offset) {
+			return nil, 0, errCBORTruncated
+		}
+		end := offset + int(argument)
+		if major == 2 {
+			return append([]byte{}, data[offset:end]...), end, nil
+		}
+		return string(data[offset:end]), end, nil
+	case 4:
+		// Every item takes at least a byte, which keeps a huge length from allocating a huge slice
+		if argument > uint64(len(data)-offset) {
+			return nil, 0, errCBORTruncated
+		}
+		items := make([]interface{}, 0, int(argument))
+		for i := uint64(0); i < argument; i++ {
+			item, length, err := decodeCBORValue(data[offset:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			offset += length
+		}
+		return items, offset, nil
+	case 5:
+		if argument > uint64(len(data)-offset) {
+			return nil, 0, errCBORTruncated
+		}
+		entries := make(map[interface{}]interface{}, int(argument))
+		for i := uint64(0); i < argument; i++ {
+			key, length, err := decodeCBORValue(data[offset:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			offset += length
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errors.New("cbor: map keys must be integers or text")
+			}
+			if _, duplicate := entries[key]; duplicate {
+				return nil, 0, errors.New("cbor: duplicate map key")
+			}
+			value, length, err := decodeCBORValue(data[offset:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			offset += length
+			entries[key] = value
+		}
+		return entries, offset, nil
+	case 6:
+		// Tags only add meaning to the value that follows, which is all that's needed here
+		value, length, err := decodeCBORValue(data[offset:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return value, offset + length, nil
+	}
+	return nil, 0, fmt.Errorf("cbor: unsupported major type %d", major)
+}
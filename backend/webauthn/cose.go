@@ -0,0 +1,143 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the signature algorithms credentials can use
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key types
+const (
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+)
+
+// COSE curves
+const (
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// The signature algorithms offered to authenticators, most preferred first
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// A publicKey is a credential public key decoded from its COSE encoding
+type publicKey struct {
+	algorithm int
+	key       crypto.PublicKey
+}
+
+// Read an integer out of a decoded COSE key
+func coseInt(key map[interface{}]interface{}, label int64) (int64, bool) {
+	value, ok := key[label].(int64)
+	return value, ok
+}
+
+// Read a byte string out of a decoded COSE key
+func coseBytes(key map[interface{}]interface{}, label int64) ([]byte, bool) {
+	value, ok := key[label].([]byte)
+	return value, ok
+}
+
+// Decode a COSE_Key, as stored in authenticator data, into a public key
+func parsePublicKey(encoded []byte) (publicKey, error) {
+	decoded, length, err := decodeCBOR(encoded)
+	if err != nil {
+		return publicKey{}, err
+	}
+	if length != len(encoded) {
+		return publicKey{}, errors.New("unexpected data after public key")
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return publicKey{}, errors.New("public key is not a COSE key")
+	}
+
+	keyType, ok := coseInt(key, 1)
+	if !ok {
+		return publicKey{}, errors.New("public key has no key type")
+	}
+	algorithm, ok := coseInt(key, 3)
+	if !ok {
+		return publicKey{}, errors.New("public key has no algorithm")
+	}
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgES256:
+		curve, _ := coseInt(key, -1)
+		x, xOK := coseBytes(key, -2)
+		y, yOK := coseBytes(key, -3)
+		if curve != coseCurveP256 || !xOK || !yOK || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, errors.New("invalid ES256 public key")
+		}
+		point := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !point.Curve.IsOnCurve(point.X, point.Y) {
+			return publicKey{}, errors.New("ES256 public key is not on the curve")
+		}
+		return publicKey{algorithm: AlgES256, key: point}, nil
+
+	case keyType == coseKeyTypeOKP && algorithm == AlgEdDSA:
+		curve, _ := coseInt(key, -1)
+		x, ok := coseBytes(key, -2)
+		if curve != coseCurveEd25519 || !ok || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, errors.New("invalid EdDSA public key")
+		}
+		return publicKey{algorithm: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+
+	case keyType == coseKeyTypeRSA && algorithm == AlgRS256:
+		n, nOK := coseBytes(key, -1)
+		e, eOK := coseBytes(key, -2)
+		if !nOK || !eOK || len(e) > 4 {
+			return publicKey{}, errors.New("invalid RS256 public key")
+		}
+		modulus := new(big.Int).SetBytes(n)
+		if modulus.BitLen() < 2048 {
+			return publicKey{}, errors.New("RS256 public keys must be at least 2048 bits")
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 || exponent%2 == 0 {
+			return publicKey{}, errors.New("invalid RS256 public key exponent")
+		}
+		return publicKey{algorithm: AlgRS256, key: &rsa.PublicKey{N: modulus, E: exponent}}, nil
+	}
+
+	return publicKey{}, fmt.Errorf("unsupported public key type %d with algorithm %d", keyType, algorithm)
+}
+
+// Check a signature over data made with the credential's private key
+func (pub publicKey) verify(data []byte, signature []byte) error {
+	digest := sha256.Sum256(data)
+	switch pub.algorithm {
+	case AlgES256:
+		if !ecdsa.VerifyASN1(pub.key.(*ecdsa.PublicKey), digest[:], signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case AlgEdDSA:
+		if !ed25519.Verify(pub.key.(ed25519.PublicKey), data, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case AlgRS256:
+		return rsa.VerifyPKCS1v15(pub.key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature)
+	}
+	return fmt.Errorf("unsupported algorithm %d", pub.algorithm)
+}
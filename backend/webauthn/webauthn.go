@@ -0,0 +1,395 @@
+// Package webauthn verifies WebAuthn registration and login ceremonies, so users can log in with passkeys.
+// It only checks what authenticators send back, and leaves storing challenges and credentials to the caller.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// How long the browser gives the user to finish a ceremony
+const Timeout = time.Minute * 5
+
+// Flags in authenticator data
+const (
+	flagUserPresent      = 0x01
+	flagUserVerified     = 0x04
+	flagAttestedCredData = 0x40
+	flagExtensionData    = 0x80
+)
+
+// Credential IDs longer than this are rejected, as the spec allows
+const maxCredentialIDLength = 1023
+
+// Returned when an authenticator's signature counter didn't go up, which can mean it was cloned
+var ErrSignCountNotIncreased = errors.New("signature counter did not increase, the authenticator may have been cloned")
+
+// A RelyingParty is the site credentials are registered with
+type RelyingParty struct {
+	// The domain credentials are scoped to, like "dwitter.com"
+	ID   string
+	Name string
+	// The origins ceremonies are allowed to come from, like "https://dwitter.com"
+	Origins []string
+}
+
+// Load the relying party from WEBAUTHN_RP_ID and WEBAUTHN_ORIGINS, a comma separated list.
+// They default to localhost and the local frontend.
+func LoadFromEnv() RelyingParty {
+	rp := RelyingParty{
+		ID:      os.Getenv("WEBAUTHN_RP_ID"),
+		Name:    "Dwitter",
+		Origins: []string{},
+	}
+	if rp.ID == "" {
+		rp.ID = "localhost"
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			rp.Origins = append(rp.Origins, origin)
+		}
+	}
+	if len(rp.Origins) == 0 {
+		rp.Origins = []string{"http://localhost:5000"}
+	}
+	return rp
+}
+
+// Encode bytes the way WebAuthn sends them in JSON, as unpadded base64url
+func EncodeBase64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode base64url sent by a client, with or without padding
+func DecodeBase64(encoded string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+}
+
+// Generate a random challenge for a ceremony
+func NewChallenge() (string, error) {
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return "", err
+	}
+	return EncodeBase64(challenge), nil
+}
+
+// A CredentialDescriptor points an authenticator at a credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// A CredentialParameter is a signature algorithm the relying party accepts
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// A RelyingPartyEntity describes the site to the authenticator
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// A UserEntity describes the user a credential is being registered for.
+// ID is the user handle, which shouldn't contain anything personal since the authenticator stores it.
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// An AuthenticatorSelection says what kind of authenticator is wanted
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed to navigator.credentials.create() to register a credential, once the base64url fields are decoded
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// RequestOptions are passed to navigator.credentials.get() to log in, once the base64url fields are decoded
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// List credential IDs as descriptors
+func credentialDescriptors(credentialIDs [][]byte) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(credentialIDs))
+	for _, credentialID := range credentialIDs {
+		descriptors = append(descriptors, CredentialDescriptor{
+			Type: "public-key",
+			ID:   EncodeBase64(credentialID),
+		})
+	}
+	return descriptors
+}
+
+// Build the options for registering a credential. Credentials the user already has are excluded, so the same authenticator isn't registered twice.
+func (rp RelyingParty) CreationOptions(challenge string, user UserEntity, existingCredentialIDs [][]byte) CreationOptions {
+	params := make([]CredentialParameter, 0, len(SupportedAlgorithms))
+	for _, alg := range SupportedAlgorithms {
+		params = append(params, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	return CreationOptions{
+		Challenge:        challenge,
+		RP:               RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:             user,
+		PubKeyCredParams: params,
+		Timeout:          Timeout.Milliseconds(),
+		// Attestation would only say which authenticator model made the credential, which doesn't matter here
+		Attestation:        "none",
+		ExcludeCredentials: credentialDescriptors(existingCredentialIDs),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+	}
+}
+
+// Build the options for logging in. With no allowed credentials, the authenticator offers any passkey it has for this site.
+func (rp RelyingParty) RequestOptions(challenge string, allowedCredentialIDs [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          Timeout.Milliseconds(),
+		AllowCredentials: credentialDescriptors(allowedCredentialIDs),
+		UserVerification: "preferred",
+	}
+}
+
+// ClientData is what the browser says about a ceremony, signed over by the authenticator
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Parse clientDataJSON, so the challenge can be looked up before anything is verified
+func ParseClientData(clientDataJSON []byte) (ClientData, error) {
+	var clientData ClientData
+	err := json.Unmarshal(clientDataJSON, &clientData)
+	if err != nil {
+		return ClientData{}, fmt.Errorf("invalid client data: %v", err)
+	}
+	return clientData, nil
+}
+
+// Check client data against what the ceremony expects
+func (rp RelyingParty) verifyClientData(clientDataJSON []byte, ceremonyType string, challenge string) error {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if clientData.Type != ceremonyType {
+		return fmt.Errorf("client data is for %q, not %q", clientData.Type, ceremonyType)
+	}
+	if subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1 {
+		return errors.New("client data challenge does not match")
+	}
+	if clientData.CrossOrigin {
+		return errors.New("cross-origin ceremonies are not allowed")
+	}
+	for _, origin := range rp.Origins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed", clientData.Origin)
+}
+
+// authenticatorData is what the authenticator says about a ceremony
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// Parse authenticator data, including the attested credential when there is one
+func parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	if len(data) < 37 {
+		return authenticatorData{}, errors.New("authenticator data is too short")
+	}
+	parsed := authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if parsed.flags&flagAttestedCredData != 0 {
+		// The AAGUID, which says what model the authenticator is, comes first and isn't needed
+		if len(rest) < 18 {
+			return authenticatorData{}, errors.New("attested credential data is too short")
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength > maxCredentialIDLength || len(rest) < idLength {
+			return authenticatorData{}, errors.New("invalid credential ID")
+		}
+		parsed.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, keyLength, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("invalid credential public key: %v", err)
+		}
+		parsed.publicKey = rest[:keyLength]
+		rest = rest[keyLength:]
+	}
+
+	if parsed.flags&flagExtensionData != 0 {
+		_, extensionsLength, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, fmt.Errorf("invalid extension data: %v", err)
+		}
+		rest = rest[extensionsLength:]
+	}
+	if len(rest) != 0 {
+		return authenticatorData{}, errors.New("unexpected data after authenticator data")
+	}
+	return parsed, nil
+}
+
+// Check the parts of authenticator data that every ceremony has
+func (rp RelyingParty) verifyAuthenticatorData(data authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return errors.New("credential is for another site")
+	}
+	if data.flags&flagUserPresent == 0 {
+		return errors.New("user was not present")
+	}
+	return nil
+}
+
+// A Credential is a newly registered passkey
+type Credential struct {
+	ID []byte
+	// The COSE encoded public key, which is what gets stored
+	PublicKey    []byte
+	Algorithm    int
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Verify the response to a registration ceremony started with challenge, and return the new credential.
+// Attestation statements aren't checked, since any authenticator is allowed and "none" attestation is asked for.
+func (rp RelyingParty) VerifyRegistration(challenge string, clientDataJSON []byte, attestationObject []byte) (Credential, error) {
+	err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	decoded, length, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("invalid attestation object: %v", err)
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok || length != len(attestationObject) {
+		return Credential{}, errors.New("invalid attestation object")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, errors.New("attestation object has no authenticator data")
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	err = rp.verifyAuthenticatorData(authData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.flags&flagAttestedCredData == 0 {
+		return Credential{}, errors.New("authenticator did not create a credential")
+	}
+
+	pub, err := parsePublicKey(authData.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:           authData.credentialID,
+		PublicKey:    authData.publicKey,
+		Algorithm:    pub.algorithm,
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// An Assertion is the result of a successful login ceremony
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Verify the response to a login ceremony started with challenge, for a credential with the stored public key and sign count.
+// The new sign count in the result should be stored, so a cloned authenticator can be noticed.
+func (rp RelyingParty) VerifyAssertion(challenge string, storedPublicKey []byte, storedSignCount uint32, clientDataJSON []byte, rawAuthData []byte, signature []byte) (Assertion, error) {
+	err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Assertion{}, err
+	}
+	err = rp.verifyAuthenticatorData(authData)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	pub, err := parsePublicKey(storedPublicKey)
+	if err != nil {
+		return Assertion{}, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	err = pub.verify(signed, signature)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	// Authenticators that don't count signatures always send 0
+	if (authData.signCount != 0 || storedSignCount != 0) && authData.signCount <= storedSignCount {
+		return Assertion{}, ErrSignCountNotIncreased
+	}
+
+	return Assertion{
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
@@ -19,24 +19,61 @@ import (
 	"github.com/soumitradev/Dwitter/backend/cdn"
 	"github.com/soumitradev/Dwitter/backend/common"
 	"github.com/soumitradev/Dwitter/backend/database"
+	"github.com/soumitradev/Dwitter/backend/export"
 	"github.com/soumitradev/Dwitter/backend/gql"
+	"github.com/soumitradev/Dwitter/backend/mailer"
 	"github.com/soumitradev/Dwitter/backend/middleware"
+	"github.com/soumitradev/Dwitter/backend/passwords"
 	"github.com/soumitradev/Dwitter/frontend"
 	"github.com/unrolled/secure"
 )
 
 func main() {
-	// When returning from main(), make sure to disconnect from database
-	defer database.DisconnectDB()
-
 	// Load .env
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file: ", err)
 	}
 
-	// Initialize sendgrid
-	common.InitSendgrid()
+	// Connect to the database, and make sure to disconnect from it when returning from main()
+	database.ConnectDB()
+	defer database.DisconnectDB()
+
+	// Connect to the bucket media is stored in
+	cdn.InitBucket()
+
+	// Pick how emails are sent
+	err = mailer.Init()
+	if err != nil {
+		log.Fatal("Error initializing mailer: ", err)
+	}
+
+	// Load the password policy
+	err = passwords.Init()
+	if err != nil {
+		log.Fatal("Error loading password policy: ", err)
+	}
+
+	// Load the keys access tokens are signed with
+	err = auth.InitSigningKeys()
+	if err != nil {
+		log.Fatal("Error loading signing keys: ", err)
+	}
+
+	// Load OAuth providers
+	err = auth.InitOAuthProviders()
+	if err != nil {
+		log.Fatal("Error loading OAuth providers: ", err)
+	}
+
+	// Load the site passkeys are registered with
+	auth.InitWebAuthn()
+
+	// Load how much a single GraphQL query can ask for
+	err = gql.InitLimits()
+	if err != nil {
+		log.Fatal("Error loading GraphQL query limits: ", err)
+	}
 
 	// Check for an error in schema at runtime
 	if gql.SchemaError != nil {
@@ -48,40 +85,73 @@ func main() {
 	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
 	flag.Parse()
 
+	// Create a validator for data validation. The sweepers below use it, so it has to exist before they start.
+	common.Validate = validator.New()
+
+	// Delete unverified accounts with expired verification links on startup, and periodically after that
+	go auth.SweepUnverifiedUsers(time.Minute * 10)
+	// Forget old failed login attempts periodically
+	go auth.SweepLoginThrottles(time.Hour)
+	// Delete expired magic links periodically
+	go auth.SweepMagicLinks(time.Hour)
+	// Delete passkey ceremonies that were never finished
+	go auth.SweepWebAuthnChallenges(time.Hour)
+	// Lift suspensions once they end
+	go auth.SweepExpiredSuspensions(time.Minute)
+	// Permanently delete accounts whose deletion grace period is over
+	go database.SweepDeletedAccounts(time.Hour)
+	// Fail data exports the server stopped building, and delete ones that can't be downloaded anymore
+	go export.SweepExpiredExports(time.Hour)
+
 	// Create a new router
 	router := mux.NewRouter().StrictSlash(true)
 
-	// Create a validator for data validation
-	common.Validate = validator.New()
-
 	// Create a graphql query handler
 	h := handler.New(&handler.Config{
 		Schema:     &gql.Schema,
 		Pretty:     true,
 		GraphiQL:   false,
 		Playground: true,
-		// This is a way to pass context about the request into the resolver function of graphql
+		// This is a way to pass context about the request into the resolver function of graphql.
+		// Resolvers keep the request's loaders in it, so it has to be a fresh map for every request.
 		RootObjectFn: func(myCtx context.Context, r *http.Request) map[string]interface{} {
-			// Pass down the authorization token to the graphql query
-			authHeader := r.Header.Get("authorization")
-			tokenString := auth.SplitAuthToken(authHeader)
 			return map[string]interface{}{
-				"token": tokenString,
+				// Some mutations log users in, and need to know where the request came from
+				"request": r,
 			}
 		},
 	})
 
-	// Map /graphql to the graphql handler, and attach a middleware to it
-	router.Handle("/api/graphql", h)
+	// Map /graphql to the graphql handler, and attach middleware to it that finds out who the viewer is
+	// and rejects queries that ask for too much
+	router.Handle("/api/graphql", middleware.ViewerHandler(gql.LimitHandler(h)))
 
 	// Handle some API endpoints using a non-GraphQL solution
 	router.HandleFunc("/api/login", auth.LoginHandler).Methods("POST")
+	router.HandleFunc("/api/login/totp", auth.TOTPLoginHandler).Methods("POST")
 	router.HandleFunc("/api/refresh_token", auth.RefreshHandler).Methods("POST")
+	router.HandleFunc("/api/logout", auth.LogoutHandler).Methods("POST")
+	router.HandleFunc("/api/reactivate", auth.ReactivateHandler).Methods("POST")
 	router.HandleFunc("/api/verify/{token}", auth.VerifyHandler).Methods("GET")
+	router.HandleFunc("/api/resend_verification", auth.ResendVerificationHandler).Methods("POST")
+	router.HandleFunc("/api/forgot_password", auth.ForgotPasswordHandler).Methods("POST")
+	router.HandleFunc("/api/reset_password", auth.ResetPasswordHandler).Methods("POST")
+	router.HandleFunc("/api/magic_link", auth.MagicLinkRequestHandler).Methods("POST")
+	router.HandleFunc("/api/magic_login", auth.MagicLoginHandler).Methods("POST")
+	router.HandleFunc("/api/passkeys/register/begin", auth.PasskeyRegisterBeginHandler).Methods("POST")
+	router.HandleFunc("/api/passkeys/register/finish", auth.PasskeyRegisterFinishHandler).Methods("POST")
+	router.HandleFunc("/api/passkeys/login/begin", auth.PasskeyLoginBeginHandler).Methods("POST")
+	router.HandleFunc("/api/passkeys/login/finish", auth.PasskeyLoginFinishHandler).Methods("POST")
+	router.HandleFunc("/api/confirm_email/{token}", auth.ConfirmEmailHandler).Methods("GET")
+	router.HandleFunc("/api/cancel_email_change/{token}", auth.CancelEmailChangeHandler).Methods("GET")
+	router.HandleFunc("/api/export/{token}", export.DownloadHandler).Methods("GET")
 	router.HandleFunc("/api/media_upload", cdn.UploadMediaHandler).Methods("POST")
 	router.HandleFunc("/api/pfp_upload", cdn.UploadPFPHandler).Methods("POST")
-	router.HandleFunc("/api/callback", auth.OAuth2callbackHandler)
+	router.HandleFunc("/api/oauth/{provider}/start", auth.OAuthStartHandler).Methods("GET")
+	router.HandleFunc("/api/oauth/{provider}/callback", auth.OAuthCallbackHandler).Methods("GET")
+	router.HandleFunc("/api/oauth/{provider}/link", auth.OAuthLinkHandler).Methods("POST")
 	router.Handle("/api/subscriptions", common.GraphqlwsHandler)
+	router.HandleFunc("/.well-known/jwks.json", auth.JWKSHandler).Methods("GET")
 
 	// Handle frontend
 	frontend := frontend.FrontendHandler{StaticPath: "frontend/dist", IndexPath: "index.html"}
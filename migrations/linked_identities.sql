@@ -0,0 +1,54 @@
+-- Users who signed up with Discord before accounts could link several providers only have "OAuthProvider" set to 'Discord'.
+-- Pushing the schema drops that column, so this moves them to LinkedIdentity first. It does nothing on databases that
+-- never had the column, so it is safe to run before every `prisma db push`.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT FROM information_schema.columns
+        WHERE table_name = 'User' AND column_name = 'OAuthProvider'
+    ) THEN
+        RETURN;
+    END IF;
+
+    -- The same table and indexes db push would create, so it has nothing left to do for them
+    CREATE TABLE IF NOT EXISTS "LinkedIdentity" (
+        "dbID" TEXT NOT NULL,
+        "provider" VARCHAR(40) NOT NULL,
+        "providerUserID" TEXT NOT NULL,
+        "userID" VARCHAR(20) NOT NULL,
+        "createdAt" TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+        CONSTRAINT "LinkedIdentity_pkey" PRIMARY KEY ("dbID")
+    );
+    CREATE UNIQUE INDEX IF NOT EXISTS "LinkedIdentity_provider_providerUserID_key" ON "LinkedIdentity"("provider", "providerUserID");
+    CREATE UNIQUE INDEX IF NOT EXISTS "LinkedIdentity_userID_provider_key" ON "LinkedIdentity"("userID", "provider");
+    IF NOT EXISTS (
+        SELECT FROM information_schema.table_constraints
+        WHERE table_name = 'LinkedIdentity' AND constraint_name = 'LinkedIdentity_userID_fkey'
+    ) THEN
+        ALTER TABLE "LinkedIdentity" ADD CONSTRAINT "LinkedIdentity_userID_fkey"
+            FOREIGN KEY ("userID") REFERENCES "User"("username") ON DELETE CASCADE ON UPDATE CASCADE;
+    END IF;
+
+    ALTER TABLE "User" ADD COLUMN IF NOT EXISTS "hasPassword" BOOLEAN NOT NULL DEFAULT true;
+
+    -- Discord users were never asked for a password, and got a hash of their Discord refresh token instead
+    UPDATE "User" SET "hasPassword" = false WHERE "OAuthProvider" = 'Discord';
+
+    -- Their Discord ID was only kept in their profile picture URL, https://cdn.discordapp.com/avatars/<id>/<hash>.png.
+    -- Users who have changed their picture since get a placeholder ID instead, which their next Discord login claims.
+    INSERT INTO "LinkedIdentity" ("dbID", "provider", "providerUserID", "userID")
+    SELECT
+        md5(random()::text || clock_timestamp()::text)::uuid::text,
+        'discord',
+        COALESCE(
+            substring("profilePicURL" FROM '^https://cdn\.discordapp\.com/avatars/([0-9]+)/'),
+            'legacy:' || "username"
+        ),
+        "username"
+    FROM "User"
+    WHERE "OAuthProvider" = 'Discord'
+    ON CONFLICT DO NOTHING;
+
+    ALTER TABLE "User" DROP COLUMN "OAuthProvider";
+END $$;